@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// API: 存储运维（仅管理员，作用于 X-Tenant 选择的租户）
+//
+//	POST   /api/admin/flush                   立即刷盘
+//	GET    /api/admin/segments                列出段（大小、时间范围、条数）
+//	DELETE /api/admin/segments?from=&to=      删除完全落在时间范围内的段
+//	POST   /api/admin/compact                 立即压缩已结束的小时段
+//	POST   /api/admin/reindex[?all=true]      重建失效（或全部）段索引
+//	POST   /api/admin/offload                 立即按时长把段移到温层 / 冷层
+//	GET    /api/admin/ingest                  写入状态
+//	POST   /api/admin/ingest/pause|resume     暂停 / 恢复写入
+func handleStorageAdmin(w http.ResponseWriter, r *http.Request) {
+	tenant := tenantFrom(r)
+	logs := tenant.Logs
+	action := strings.TrimPrefix(r.URL.Path, "/api/admin/")
+
+	result := map[string]interface{}{"tenant": tenant.ID}
+	switch {
+	case action == "flush" && r.Method == "POST":
+		flushed, err := logs.flushToDisk()
+		if err != nil {
+			http.Error(w, "刷盘失败: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if flushed == nil {
+			flushed = &FlushResult{}
+		}
+		result["flush"] = flushed
+
+	case action == "segments" && r.Method == "GET":
+		segments := logs.Segments()
+		var bytes int64
+		entries := 0
+		for _, seg := range segments {
+			bytes += seg.Bytes
+			entries += seg.Entries
+		}
+		result["segments"] = segments
+		result["total_bytes"] = bytes
+		result["total_entries"] = entries
+
+	case action == "segments" && r.Method == "DELETE":
+		from, err := parseTimeParam(r.URL.Query().Get("from"))
+		if err != nil {
+			http.Error(w, "from 参数错误: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		to, err := parseTimeParam(r.URL.Query().Get("to"))
+		if err != nil {
+			http.Error(w, "to 参数错误: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !from.Before(to) {
+			http.Error(w, "from 必须早于 to", http.StatusBadRequest)
+			return
+		}
+		deleted, err := logs.DeleteSegments(from, to)
+		if err != nil {
+			http.Error(w, "删除段失败: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var freed int64
+		for _, seg := range deleted {
+			freed += seg.Bytes
+		}
+		result["deleted"] = deleted
+		result["freed_bytes"] = freed
+
+	case action == "compact" && r.Method == "POST":
+		compacted, err := logs.Compact(time.Now(), true)
+		result["compacted"] = compacted
+		if err != nil {
+			result["error"] = err.Error()
+		}
+
+	case action == "reindex" && r.Method == "POST":
+		rebuilt, err := logs.RebuildIndexes(r.URL.Query().Get("all") == "true")
+		result["rebuilt"] = rebuilt
+		if err != nil {
+			result["error"] = err.Error()
+		}
+
+	case action == "offload" && r.Method == "POST":
+		if logs.warm == nil && logs.remote == nil {
+			http.Error(w, "未配置温层或远端存储（storage.tiers.warm_dir / storage.remote）", http.StatusBadRequest)
+			return
+		}
+		offloaded, err := logs.Offload(time.Now())
+		result["offloaded"] = offloaded
+		if err != nil {
+			result["error"] = err.Error()
+		}
+
+	case action == "ingest" && r.Method == "GET":
+		result["paused"] = tenant.GetStats()["tenant_ingest_paused"]
+
+	case (action == "ingest/pause" || action == "ingest/resume") && r.Method == "POST":
+		paused := action == "ingest/pause"
+		tenant.SetPaused(paused)
+		fmt.Printf("⏸️  [Admin] Ingestion for tenant %s paused=%t\n", tenant.ID, paused)
+		result["paused"] = paused
+
+	default:
+		http.Error(w, "未知的管理操作: "+r.Method+" "+r.URL.Path, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// 时间参数：RFC3339、"2006-01-02 15:04:05" 或 "2006-01-02"（本地时间）
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, fmt.Errorf("不能为空")
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("无法解析时间 %q", value)
+}
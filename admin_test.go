@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type adminTestServer struct {
+	t       *testing.T
+	handler http.HandlerFunc
+	tenants *TenantManager
+	audit   *AuditLog
+	admin   string
+	query   string
+}
+
+// 和 main 中 /api/admin/ 的中间件顺序一致
+func newAdminTestServer(t *testing.T) *adminTestServer {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.DataDir = t.TempDir()
+	auth, err := NewAuthStore(cfg.DataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit, err := NewAuditLog(cfg.DataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.Audit = audit
+	tenants, err := NewTenantManager(cfg, cfg.IngestConfig([]byte("test-key")), audit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tenants.Create("acme"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{defaultTenant, "acme"} {
+		tenant, _ := tenants.Get(id)
+		waitReplayed(t, tenant.Logs)
+	}
+
+	srv := &adminTestServer{
+		t:       t,
+		handler: auth.Require(ScopeAdmin, audit.Admin(tenants.Resolve(handleStorageAdmin))),
+		tenants: tenants,
+		audit:   audit,
+	}
+	if srv.admin, _, err = auth.Create(APIKey{Name: "ops", Scopes: []string{ScopeAdmin}}); err != nil {
+		t.Fatal(err)
+	}
+	if srv.query, _, err = auth.Create(APIKey{Name: "grafana", Scopes: []string{ScopeQuery, ScopeIngest}}); err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func (s *adminTestServer) do(key, method, target, tenant string) (int, map[string]interface{}) {
+	s.t.Helper()
+	r := httptest.NewRequest(method, target, nil)
+	if key != "" {
+		r.Header.Set("Authorization", "Bearer "+key)
+	}
+	if tenant != "" {
+		r.Header.Set("X-Tenant", tenant)
+	}
+	w := httptest.NewRecorder()
+	s.handler(w, r)
+
+	var result map[string]interface{}
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			s.t.Fatalf("%s %s: %v: %s", method, target, err, w.Body.String())
+		}
+	}
+	return w.Code, result
+}
+
+func TestAdminRequiresAdminScope(t *testing.T) {
+	srv := newAdminTestServer(t)
+	endpoints := []struct{ method, target string }{
+		{"POST", "/api/admin/flush"},
+		{"GET", "/api/admin/segments"},
+		{"DELETE", "/api/admin/segments?from=2024-01-01&to=2024-01-02"},
+		{"POST", "/api/admin/compact"},
+		{"POST", "/api/admin/reindex"},
+		{"GET", "/api/admin/ingest"},
+		{"POST", "/api/admin/ingest/pause"},
+	}
+	for _, e := range endpoints {
+		if code, _ := srv.do("", e.method, e.target, ""); code != http.StatusUnauthorized {
+			t.Errorf("%s %s without key: %d, want 401", e.method, e.target, code)
+		}
+		if code, _ := srv.do(srv.query, e.method, e.target, ""); code != http.StatusForbidden {
+			t.Errorf("%s %s with query key: %d, want 403", e.method, e.target, code)
+		}
+	}
+	tenant, _ := srv.tenants.Get(defaultTenant)
+	if tenant.admit() != 0 {
+		t.Error("a rejected pause request paused the tenant")
+	}
+
+	for _, c := range []struct {
+		method, target, tenant string
+		code                   int
+	}{
+		{"GET", "/api/admin/flush", "", http.StatusNotFound},
+		{"POST", "/api/admin/unknown", "", http.StatusNotFound},
+		{"POST", "/api/admin/flush", "missing", http.StatusNotFound},
+		{"POST", "/api/admin/offload", "", http.StatusBadRequest}, // 没有配置温层 / 远端
+		{"DELETE", "/api/admin/segments?from=2024-01-02&to=2024-01-01", "", http.StatusBadRequest},
+		{"DELETE", "/api/admin/segments?from=yesterday&to=2024-01-01", "", http.StatusBadRequest},
+	} {
+		if code, _ := srv.do(srv.admin, c.method, c.target, c.tenant); code != c.code {
+			t.Errorf("%s %s (tenant %q): %d, want %d", c.method, c.target, c.tenant, code, c.code)
+		}
+	}
+
+	// 认证失败和管理操作都写入审计日志
+	failures, err := srv.audit.Query(auditFilter{Action: AuditAuthFailure, Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 2*len(endpoints) {
+		t.Errorf("%d auth failures audited, want %d", len(failures), 2*len(endpoints))
+	}
+	admin, _ := srv.audit.Query(auditFilter{Action: AuditAdmin, Limit: 100})
+	if len(admin) == 0 || admin[0].Status == 0 {
+		t.Errorf("admin requests not audited: %+v", admin)
+	}
+}
+
+func TestAdminStorageOperations(t *testing.T) {
+	srv := newAdminTestServer(t)
+	tenant, _ := srv.tenants.Get(defaultTenant)
+	hour := time.Now().Add(-2 * time.Hour).Format("2006-01-02-15")
+	start, _ := time.ParseInLocation("2006-01-02-15", hour, time.Local)
+	past := start.Add(10 * time.Minute)
+
+	// 两次刷盘：同一个已结束的小时段有两个块
+	for i, messages := range [][]string{{"b", "c"}, {"a"}} {
+		for j, message := range messages {
+			ts := past.Add(time.Duration(10*(2-i)+j) * time.Second).Format(columnTimeLayout)
+			tenant.Logs.Append(LogEntry{Timestamp: ts, Server: "web-01", Level: "INFO", Message: message})
+		}
+		code, result := srv.do(srv.admin, "POST", "/api/admin/flush", "")
+		if code != http.StatusOK {
+			t.Fatalf("flush: %d", code)
+		}
+		if flush := result["flush"].(map[string]interface{}); flush["entries"] != float64(len(messages)) {
+			t.Errorf("flush #%d: %+v", i, flush)
+		}
+	}
+	// 缓冲为空时刷盘返回空结果
+	if _, result := srv.do(srv.admin, "POST", "/api/admin/flush", ""); result["flush"].(map[string]interface{})["entries"] != float64(0) {
+		t.Errorf("empty flush: %+v", result)
+	}
+
+	segment := func() map[string]interface{} {
+		t.Helper()
+		code, result := srv.do(srv.admin, "GET", "/api/admin/segments", "")
+		segments, _ := result["segments"].([]interface{})
+		if code != http.StatusOK || len(segments) != 1 || result["total_entries"] != float64(3) {
+			t.Fatalf("segments: %d %+v", code, result)
+		}
+		return segments[0].(map[string]interface{})
+	}
+	if seg := segment(); seg["hour"] != hour || seg["chunks"] != float64(2) || seg["indexed"] != true {
+		t.Errorf("segment before compaction: %+v", seg)
+	}
+
+	// 手动压缩忽略 min_chunks
+	_, result := srv.do(srv.admin, "POST", "/api/admin/compact", "")
+	compacted, _ := result["compacted"].([]interface{})
+	if len(compacted) != 1 || result["error"] != nil {
+		t.Fatalf("compact: %+v", result)
+	}
+	if c := compacted[0].(map[string]interface{}); c["chunks_before"] != float64(2) || c["chunks_after"] != float64(1) || c["reordered"] != true {
+		t.Errorf("compact result: %+v", c)
+	}
+	if seg := segment(); seg["chunks"] != float64(1) {
+		t.Errorf("segment after compaction: %+v", seg)
+	}
+
+	// 索引丢失后重建
+	if err := os.Remove(indexPath(segmentPath(tenant.Logs.dataDir, hour))); err != nil {
+		t.Fatal(err)
+	}
+	if seg := segment(); seg["indexed"] != false {
+		t.Errorf("segment without index: %+v", seg)
+	}
+	if _, result := srv.do(srv.admin, "POST", "/api/admin/reindex", ""); result["rebuilt"] != float64(1) {
+		t.Errorf("reindex: %+v", result)
+	}
+	if _, result := srv.do(srv.admin, "POST", "/api/admin/reindex?all=true", ""); result["rebuilt"] != float64(1) {
+		t.Errorf("reindex all: %+v", result)
+	}
+	if seg := segment(); seg["indexed"] != true {
+		t.Errorf("segment after reindex: %+v", seg)
+	}
+	logs := tenant.Logs.Query("", "", "", past.Add(-time.Minute).Format(columnTimeLayout), "", 10, nil)
+	if len(logs) != 3 || logs[0].Message != "c" || logs[2].Message != "a" {
+		t.Errorf("query after compaction and reindex: %+v", logs)
+	}
+
+	// 删除时间范围内的段
+	from := start.Format("2006-01-02 15:04:05")
+	to := start.Add(time.Hour).Format("2006-01-02 15:04:05")
+	_, result = srv.do(srv.admin, "DELETE", "/api/admin/segments?from="+strings.ReplaceAll(from, " ", "+")+"&to="+strings.ReplaceAll(to, " ", "+"), "")
+	if deleted, _ := result["deleted"].([]interface{}); len(deleted) != 1 || result["freed_bytes"] == float64(0) {
+		t.Errorf("delete segments: %+v", result)
+	}
+	if _, result := srv.do(srv.admin, "GET", "/api/admin/segments", ""); len(result["segments"].([]interface{})) != 0 {
+		t.Errorf("segments after delete: %+v", result)
+	}
+}
+
+// 暂停和恢复只作用于 X-Tenant 选择的租户
+func TestAdminPauseIngestion(t *testing.T) {
+	srv := newAdminTestServer(t)
+	acme, _ := srv.tenants.Get("acme")
+	def, _ := srv.tenants.Get(defaultTenant)
+
+	code, result := srv.do(srv.admin, "POST", "/api/admin/ingest/pause", "acme")
+	if code != http.StatusOK || result["paused"] != true || result["tenant"] != "acme" {
+		t.Fatalf("pause: %d %+v", code, result)
+	}
+	if acme.admit() != http.StatusServiceUnavailable || def.admit() != 0 {
+		t.Errorf("admit after pause: acme %d, default %d", acme.admit(), def.admit())
+	}
+	if _, result := srv.do(srv.admin, "GET", "/api/admin/ingest", "acme"); result["paused"] != true {
+		t.Errorf("status: %+v", result)
+	}
+	if _, result := srv.do(srv.admin, "GET", "/api/admin/ingest", ""); result["paused"] != false {
+		t.Errorf("default tenant status: %+v", result)
+	}
+
+	if _, result := srv.do(srv.admin, "POST", "/api/admin/ingest/resume", "acme"); result["paused"] != false {
+		t.Errorf("resume: %+v", result)
+	}
+	if acme.admit() != 0 {
+		t.Error("tenant still paused after resume")
+	}
+}
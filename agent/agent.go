@@ -2,6 +2,10 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -21,6 +25,7 @@ import (
 
 // 日志条目（与 MiniLog 服务器保持一致）
 type LogEntry struct {
+	ID        string   `json:"id,omitempty"` // 幂等键：重试时不变，服务器只保存一次
 	Timestamp string   `json:"timestamp"`
 	Level     string   `json:"level"`
 	Server    string   `json:"server"`
@@ -41,6 +46,8 @@ type Agent struct {
 	serverName string
 	minilogURL string
 	interval   int
+	token      string
+	client     *http.Client
 }
 
 func main() {
@@ -48,13 +55,24 @@ func main() {
 	serverName := flag.String("server", "", "服务器名称（默认使用主机名）")
 	minilogURL := flag.String("minilog", "http://localhost:8080", "MiniLog 服务器地址")
 	interval := flag.Int("interval", 30, "采集间隔（秒）")
+	token := flag.String("token", os.Getenv("MINILOG_TOKEN"), "MiniLog API Key（ingest 权限，也可用 MINILOG_TOKEN 环境变量）")
+	caFile := flag.String("ca", "", "校验 MiniLog 服务器证书的 CA 文件（https）")
+	certFile := flag.String("cert", "", "客户端证书（mTLS）")
+	keyFile := flag.String("key", "", "客户端私钥（mTLS）")
 	flag.Parse()
 
+	client, err := newHTTPClient(*caFile, *certFile, *keyFile)
+	if err != nil {
+		log.Fatal("TLS 配置错误:", err)
+	}
+
 	// 创建 Agent
 	agent := &Agent{
 		serverName: *serverName,
 		minilogURL: *minilogURL,
 		interval:   *interval,
+		token:      *token,
+		client:     client,
 	}
 
 	// 如果未指定服务器名称，使用主机名
@@ -150,9 +168,16 @@ func (a *Agent) collectMetrics() (*Metrics, error) {
 	return metrics, nil
 }
 
+// 推送失败（网络错误、429 或 5xx）时的重试次数和间隔
+const (
+	pushAttempts = 3
+	pushBackoff  = 2 * time.Second
+)
+
 func (a *Agent) sendToMiniLog(metrics *Metrics) error {
-	// 构造日志条目
+	// 构造日志条目（ID 只生成一次，重试时服务器据此去重）
 	entry := LogEntry{
+		ID:        newEntryID(),
 		Timestamp: time.Now().Format("2006-01-02 15:04:05"),
 		Level:     "METRICS",
 		Server:    a.serverName,
@@ -166,19 +191,87 @@ func (a *Agent) sendToMiniLog(metrics *Metrics) error {
 		return fmt.Errorf("序列化失败: %w", err)
 	}
 
-	// HTTP 请求
+	for attempt := 1; ; attempt++ {
+		retry, err := a.push(data)
+		if err == nil || !retry || attempt == pushAttempts {
+			return err
+		}
+		log.Printf("🔁 Push attempt %d failed, retrying: %v\n", attempt, err)
+		time.Sleep(time.Duration(attempt) * pushBackoff)
+	}
+}
+
+// 发送一次；返回的 retry 表示失败可以重试（请求可能已经被服务器保存，重试依赖 ID 去重）
+func (a *Agent) push(data []byte) (bool, error) {
 	url := a.minilogURL + "/api/logs"
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return false, fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+
+	resp, err := a.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("HTTP 请求失败: %w", err)
+		return true, fmt.Errorf("HTTP 请求失败: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("服务器返回错误: %d", resp.StatusCode)
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("服务器返回错误: %d", resp.StatusCode)
 	}
 
-	return nil
+	return false, nil
+}
+
+// 随机的日志 ID（128 位，十六进制）
+func newEntryID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// 创建 HTTP 客户端（可选自定义 CA 和客户端证书）
+func newHTTPClient(caFile, certFile, keyFile string) (*http.Client, error) {
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return &http.Client{Timeout: 10 * time.Second}, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 CA 失败: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA 文件中没有有效证书: %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("加载客户端证书失败: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	// 在默认 Transport 的基础上修改，保留代理环境变量（HTTPS_PROXY 等）、连接超时和 HTTP/2
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: transport,
+	}, nil
 }
 
 // 辅助函数：四舍五入
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 审计动作
+const (
+	AuditQuery       = "query"        // 查询日志 / 指标 / 审计记录
+	AuditAdmin       = "admin"        // 管理操作（key、角色、租户配额等变更）
+	AuditAuthFailure = "auth_failure" // 认证或授权失败
+	AuditRetention   = "retention"    // 保留策略删除过期的段
+)
+
+const auditTimeFormat = "2006-01-02 15:04:05.000"
+
+// 审计事件：每行一条 JSON，Prev 指向上一条的 Hash，形成哈希链
+type AuditEvent struct {
+	Seq        int64             `json:"seq"`
+	Time       string            `json:"time"`
+	Action     string            `json:"action"`
+	KeyID      string            `json:"key_id,omitempty"`
+	KeyName    string            `json:"key_name,omitempty"`
+	Tenant     string            `json:"tenant,omitempty"`
+	Remote     string            `json:"remote,omitempty"`
+	Method     string            `json:"method,omitempty"`
+	Path       string            `json:"path,omitempty"`
+	Status     int               `json:"status,omitempty"`
+	Details    map[string]string `json:"details,omitempty"` // 查询条件、请求内容、失败原因等
+	Results    int               `json:"results,omitempty"`
+	DurationMs float64           `json:"duration_ms,omitempty"`
+	Prev       string            `json:"prev"`
+	Hash       string            `json:"hash,omitempty"`
+}
+
+// 哈希覆盖除 Hash 外的所有字段（包括 Prev）
+func (e AuditEvent) computeHash() string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// 审计日志：dataDir/audit/audit-YYYYMMDD.log，只追加，哈希链跨段连续
+type AuditLog struct {
+	dir string
+
+	mu       sync.Mutex
+	file     *os.File
+	day      string
+	seq      int64
+	lastHash string
+	written  int64
+}
+
+func NewAuditLog(dataDir string) (*AuditLog, error) {
+	a := &AuditLog{dir: filepath.Join(dataDir, "audit")}
+	if err := os.MkdirAll(a.dir, 0700); err != nil {
+		return nil, err
+	}
+
+	// 从最新的段恢复序号和链尾哈希
+	segments, err := auditSegments(a.dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) > 0 {
+		// 崩溃时最后一条可能只写了一半：截掉再接着写，链上之前的记录不受影响
+		if err := truncateTornAuditTail(segments[len(segments)-1]); err != nil {
+			return nil, err
+		}
+		last, err := lastAuditEvent(segments[len(segments)-1])
+		if err != nil {
+			return nil, fmt.Errorf("审计日志损坏，请先用 -audit-verify 检查: %w", err)
+		}
+		if last != nil {
+			a.seq = last.Seq
+			a.lastHash = last.Hash
+		}
+	}
+	return a, nil
+}
+
+// 按文件名排序的审计段
+func auditSegments(dir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "audit-*.log"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// 最后一条记录没有换行（写了一半）
+var errTornAuditRecord = errors.New("不完整的记录")
+
+// 截掉段末尾没有换行的半条记录
+func truncateTornAuditTail(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 || data[len(data)-1] == '\n' {
+		return nil
+	}
+	keep := bytes.LastIndexByte(data, '\n') + 1
+	if err := os.Truncate(path, int64(keep)); err != nil {
+		return err
+	}
+	fmt.Printf("⚠️  [Audit] Dropped torn record at end of %s (%d bytes)\n", filepath.Base(path), len(data)-keep)
+	return nil
+}
+
+func lastAuditEvent(path string) (*AuditEvent, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimRight(data, "\n")
+	if len(data) == 0 {
+		return nil, nil
+	}
+	line := data
+	if idx := bytes.LastIndexByte(data, '\n'); idx != -1 {
+		line = data[idx+1:]
+	}
+	var e AuditEvent
+	if err := json.Unmarshal(line, &e); err != nil {
+		return nil, fmt.Errorf("%s: 最后一行无法解析: %w", path, err)
+	}
+	return &e, nil
+}
+
+// 追加一条事件（nil 时不记录）
+func (a *AuditLog) Record(e AuditEvent) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if err := a.openSegmentLocked(now); err != nil {
+		fmt.Println("⚠️  Audit write failed:", err)
+		return
+	}
+
+	a.seq++
+	e.Seq = a.seq
+	e.Time = now.Format(auditTimeFormat)
+	e.Prev = a.lastHash
+	e.Hash = e.computeHash()
+
+	data, _ := json.Marshal(e)
+	data = append(data, '\n')
+	if _, err := a.file.Write(data); err != nil {
+		fmt.Println("⚠️  Audit write failed:", err)
+		return
+	}
+	// 审计记录必须落盘后才算完成
+	a.file.Sync()
+	a.lastHash = e.Hash
+	a.written++
+}
+
+// 调用方持有 a.mu；按天切换段
+func (a *AuditLog) openSegmentLocked(now time.Time) error {
+	day := now.Format("20060102")
+	if a.file != nil && a.day == day {
+		return nil
+	}
+	if a.file != nil {
+		a.file.Close()
+	}
+	path := filepath.Join(a.dir, "audit-"+day+".log")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	a.file = file
+	a.day = day
+	return nil
+}
+
+// 记录一次 HTTP 请求（填充调用方、租户和来源地址）
+func (a *AuditLog) RecordRequest(r *http.Request, action string, status int, e AuditEvent) {
+	if a == nil {
+		return
+	}
+	if p := principalFrom(r); p != nil {
+		e.KeyID = p.KeyID
+		e.KeyName = p.Name
+	}
+	if t := tenantFrom(r); t != nil {
+		e.Tenant = t.ID
+	}
+	e.Action = action
+	e.Remote = r.RemoteAddr
+	e.Method = r.Method
+	e.Path = r.URL.Path
+	e.Status = status
+	a.Record(e)
+}
+
+// 审计查询条件
+type auditFilter struct {
+	Action string
+	KeyID  string
+	Since  string // 按 auditTimeFormat 的前缀比较
+	Until  string
+	Limit  int
+}
+
+func (f auditFilter) match(e *AuditEvent) bool {
+	if f.Action != "" && e.Action != f.Action {
+		return false
+	}
+	if f.KeyID != "" && e.KeyID != f.KeyID {
+		return false
+	}
+	if f.Since != "" && e.Time < f.Since {
+		return false
+	}
+	if f.Until != "" && e.Time > f.Until && !strings.HasPrefix(e.Time, f.Until) {
+		return false
+	}
+	return true
+}
+
+// 查询审计事件（最新的在前）
+func (a *AuditLog) Query(filter auditFilter) ([]AuditEvent, error) {
+	a.mu.Lock()
+	segments, err := auditSegments(a.dir)
+	a.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]AuditEvent, 0)
+	for i := len(segments) - 1; i >= 0; i-- {
+		// 段按天命名，整段早于 since 时可以停止
+		day := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(segments[i]), "audit-"), ".log")
+		if filter.Since != "" && len(filter.Since) >= 10 && day < strings.ReplaceAll(filter.Since[:10], "-", "") {
+			break
+		}
+
+		var events []AuditEvent
+		err := readAuditSegment(segments[i], func(e *AuditEvent) error {
+			if filter.match(e) {
+				events = append(events, *e)
+			}
+			return nil
+		})
+		// 最新的段可能正在写入最后一条
+		if err != nil && !(i == len(segments)-1 && errors.Is(err, errTornAuditRecord)) {
+			return nil, err
+		}
+		for j := len(events) - 1; j >= 0; j-- {
+			results = append(results, events[j])
+			if len(results) >= filter.Limit {
+				return results, nil
+			}
+		}
+	}
+	return results, nil
+}
+
+// 逐条读取段；最后一行没有换行时读完之前的记录后返回 errTornAuditRecord
+func readAuditSegment(path string, fn func(e *AuditEvent) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	lineNo := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			lineNo++
+			if line[len(line)-1] != '\n' {
+				return fmt.Errorf("%s:%d: %w", path, lineNo, errTornAuditRecord)
+			}
+			var e AuditEvent
+			if jerr := json.Unmarshal(line, &e); jerr != nil {
+				return fmt.Errorf("%s:%d: %w", path, lineNo, jerr)
+			}
+			if ferr := fn(&e); ferr != nil {
+				return fmt.Errorf("%s:%d: %w", path, lineNo, ferr)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// 离线校验审计目录：检查每条记录的哈希、链接和序号；返回记录数和链尾哈希。
+// 最新段末尾写了一半的记录（崩溃，下次启动时截掉）只提示不算损坏；其它位置的断裂都返回错误
+func VerifyAuditLog(dir string) (int64, string, error) {
+	segments, err := auditSegments(dir)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var count, seq int64
+	prev := ""
+	for i, path := range segments {
+		err := readAuditSegment(path, func(e *AuditEvent) error {
+			if e.Seq != seq+1 {
+				return fmt.Errorf("序号不连续: 期望 %d，得到 %d", seq+1, e.Seq)
+			}
+			if e.Prev != prev {
+				return fmt.Errorf("链接断开: prev=%s，上一条 hash=%s", e.Prev, prev)
+			}
+			if got := e.computeHash(); got != e.Hash {
+				return fmt.Errorf("哈希不匹配: 记录 %s，计算 %s", e.Hash, got)
+			}
+			seq = e.Seq
+			prev = e.Hash
+			count++
+			return nil
+		})
+		if i == len(segments)-1 && errors.Is(err, errTornAuditRecord) {
+			fmt.Printf("⚠️  %v (crash during write, removed on next start)\n", err)
+			err = nil
+		}
+		if err != nil {
+			return count, prev, err
+		}
+	}
+	return count, prev, nil
+}
+
+func (a *AuditLog) GetStats() map[string]interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return map[string]interface{}{
+		"audit_events": a.written,
+		"audit_seq":    a.seq,
+		"audit_head":   a.lastHash, // 链尾哈希：定期记录到外部可以发现尾部被截断
+	}
+}
+
+// 管理接口请求体的上限
+const maxAdminBody = 1 << 20
+
+// 记录管理操作：写请求（非 GET）的方法、路径、参数、请求体和返回状态
+func (a *AuditLog) Admin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" || a == nil {
+			next(w, r)
+			return
+		}
+
+		// 管理接口的请求体不超过 1MB，超出时拒绝而不是截断后交给处理函数；
+		// 请求体最多记录 4KB（key 的明文只出现在响应里，不会被记录）
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxAdminBody+1))
+		details := map[string]string{}
+		if r.URL.RawQuery != "" {
+			details["query"] = r.URL.RawQuery
+		}
+		if err != nil || len(body) > maxAdminBody {
+			status := http.StatusRequestEntityTooLarge
+			message := "请求体过大（管理接口最多 1MB）"
+			if err != nil {
+				status = http.StatusBadRequest
+				message = "读取请求体失败: " + err.Error()
+			}
+			http.Error(w, message, status)
+			a.RecordRequest(r, AuditAdmin, status, AuditEvent{Details: details})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if len(body) > 0 {
+			if len(body) > 4096 {
+				body = body[:4096]
+			}
+			details["request"] = string(body)
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+		a.RecordRequest(r, AuditAdmin, rec.status, AuditEvent{Details: details})
+	}
+}
+
+// 记录响应状态码
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// API: 查询审计记录（action / key_id / since / until / limit）
+func (a *AuditLog) handleAudit(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	q := r.URL.Query()
+	filter := auditFilter{
+		Action: q.Get("action"),
+		KeyID:  q.Get("key_id"),
+		Since:  q.Get("since"),
+		Until:  q.Get("until"),
+		Limit:  200,
+	}
+	if n, err := strconv.Atoi(q.Get("limit")); err == nil && n > 0 {
+		filter.Limit = n
+	}
+
+	events, err := a.Query(filter)
+	if err != nil {
+		http.Error(w, "读取审计日志失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// 查看审计记录本身也要留痕
+	a.RecordRequest(r, AuditQuery, http.StatusOK, AuditEvent{
+		Details:    map[string]string{"target": "audit", "filter": r.URL.RawQuery},
+		Results:    len(events),
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
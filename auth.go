@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 权限范围
+const (
+	ScopeIngest = "ingest" // 写入日志 / 指标
+	ScopeQuery  = "query"  // 查询日志、指标、统计
+	ScopeAdmin  = "admin"  // 管理（包含所有权限）
+)
+
+// API Key（只保存哈希，明文只在创建时返回一次）
+type APIKey struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Hash      string   `json:"hash,omitempty"`
+	Scopes    []string `json:"scopes"`
+	Server    string   `json:"server,omitempty"` // 绑定服务器：只能以该名称写入，防止冒充其它主机
+	Tenant    string   `json:"tenant,omitempty"` // 绑定租户：只能访问该租户的数据
+	Roles     []string `json:"roles,omitempty"`  // 角色：限制可读取的服务器 / 级别 / 字段
+	CreatedAt string   `json:"created_at"`
+}
+
+func (k *APIKey) hasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// 已认证的调用方
+type Principal struct {
+	KeyID  string   `json:"key_id"`
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	Server string   `json:"server,omitempty"`
+	Tenant string   `json:"tenant,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+
+	Access *AccessPolicy `json:"-"` // 由角色生成，nil 表示不受限制
+}
+
+// 调用方的访问策略（未认证时为 nil）
+func accessFrom(r *http.Request) *AccessPolicy {
+	if p := principalFrom(r); p != nil {
+		return p.Access
+	}
+	return nil
+}
+
+func (p *Principal) isAdmin() bool {
+	for _, s := range p.Scopes {
+		if s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+func principalFrom(r *http.Request) *Principal {
+	p, _ := r.Context().Value(principalKey{}).(*Principal)
+	return p
+}
+
+// Key 存储（dataDir/keys.json）
+type AuthStore struct {
+	path  string
+	keys  map[string]*APIKey // hash -> key
+	mu    sync.RWMutex
+	Roles *RoleStore
+	Audit *AuditLog // 记录认证失败（nil 时不记录）
+}
+
+func NewAuthStore(dataDir string) (*AuthStore, error) {
+	store := &AuthStore{
+		path: filepath.Join(dataDir, "keys.json"),
+		keys: make(map[string]*APIKey),
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, err
+	}
+	roles, err := NewRoleStore(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	store.Roles = roles
+
+	data, err := os.ReadFile(store.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		var keys []*APIKey
+		if err := json.Unmarshal(data, &keys); err != nil {
+			return nil, fmt.Errorf("%s: %w", store.path, err)
+		}
+		for _, k := range keys {
+			store.keys[k.Hash] = k
+		}
+	}
+
+	return store, nil
+}
+
+// 首次启动时创建管理员 key：优先 MINILOG_ADMIN_KEY，否则随机生成并打印一次
+func (s *AuthStore) Bootstrap() (string, error) {
+	s.mu.RLock()
+	empty := len(s.keys) == 0
+	s.mu.RUnlock()
+	if !empty {
+		return "", nil
+	}
+
+	if secret := os.Getenv("MINILOG_ADMIN_KEY"); secret != "" {
+		_, err := s.add(secret, APIKey{Name: "admin", Scopes: []string{ScopeAdmin}})
+		return "", err
+	}
+
+	secret, _, err := s.Create(APIKey{Name: "admin", Scopes: []string{ScopeAdmin}})
+	return secret, err
+}
+
+// 创建新 key（使用 spec 中的名称、权限范围和绑定），返回明文（只此一次）
+func (s *AuthStore) Create(spec APIKey) (string, *APIKey, error) {
+	for _, scope := range spec.Scopes {
+		if scope != ScopeIngest && scope != ScopeQuery && scope != ScopeAdmin {
+			return "", nil, fmt.Errorf("未知权限范围: %q", scope)
+		}
+	}
+	if len(spec.Scopes) == 0 {
+		return "", nil, fmt.Errorf("至少需要一个权限范围")
+	}
+	if spec.Tenant != "" && !tenantIDPattern.MatchString(spec.Tenant) {
+		return "", nil, fmt.Errorf("无效的租户 ID: %q", spec.Tenant)
+	}
+	for _, role := range spec.Roles {
+		if !s.Roles.Exists(role) {
+			return "", nil, fmt.Errorf("角色不存在: %q", role)
+		}
+	}
+
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	secret := "ml_" + hex.EncodeToString(buf)
+
+	key, err := s.add(secret, spec)
+	return secret, key, err
+}
+
+func (s *AuthStore) add(secret string, spec APIKey) (*APIKey, error) {
+	hash := hashAPIKey(secret)
+	key := &APIKey{
+		ID:        hash[:12],
+		Name:      spec.Name,
+		Hash:      hash,
+		Scopes:    spec.Scopes,
+		Server:    spec.Server,
+		Tenant:    spec.Tenant,
+		Roles:     spec.Roles,
+		CreatedAt: time.Now().Format("2006-01-02 15:04:05"),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[hash] = key
+	return key, s.saveLocked()
+}
+
+// 按 ID 删除
+func (s *AuthStore) Delete(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, key := range s.keys {
+		if key.ID == id {
+			delete(s.keys, hash)
+			return true, s.saveLocked()
+		}
+	}
+	return false, nil
+}
+
+func (s *AuthStore) List() []APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		k := *key
+		k.Hash = ""
+		result = append(result, k)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt < result[j].CreatedAt
+	})
+	return result
+}
+
+// 校验明文 key（按哈希查找，明文不落盘也不在内存中保留）
+func (s *AuthStore) Lookup(secret string) *APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys[hashAPIKey(secret)]
+}
+
+// 调用方持有 s.mu
+func (s *AuthStore) saveLocked() error {
+	keys := make([]*APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// 先写临时文件再改名，避免写一半
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// 从请求头取 key：Authorization: Bearer <key> 或 X-API-Key: <key>
+func apiKeyFromRequest(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+			return strings.TrimSpace(auth[7:])
+		}
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// 认证中间件：校验 key 并检查权限范围
+func (s *AuthStore) Require(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		secret := apiKeyFromRequest(r)
+		if secret == "" {
+			s.auditFailure(r, nil, http.StatusUnauthorized, "missing key")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="minilog"`)
+			http.Error(w, "缺少 API Key", http.StatusUnauthorized)
+			return
+		}
+
+		key := s.Lookup(secret)
+		if key == nil {
+			s.auditFailure(r, nil, http.StatusUnauthorized, "invalid key")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="minilog"`)
+			http.Error(w, "API Key 无效", http.StatusUnauthorized)
+			return
+		}
+		if !key.hasScope(scope) {
+			s.auditFailure(r, key, http.StatusForbidden, "missing scope "+scope)
+			http.Error(w, "权限不足，需要 "+scope, http.StatusForbidden)
+			return
+		}
+
+		principal := &Principal{
+			KeyID:  key.ID,
+			Name:   key.Name,
+			Scopes: key.Scopes,
+			Server: key.Server,
+			Tenant: key.Tenant,
+			Roles:  key.Roles,
+		}
+		// 管理员不受角色限制
+		if len(key.Roles) > 0 && !key.hasScope(ScopeAdmin) {
+			principal.Access = s.Roles.Policy(key.Roles)
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	}
+}
+
+// 记录认证失败（key 为 nil 表示没有或无法识别的 key）
+func (s *AuthStore) auditFailure(r *http.Request, key *APIKey, status int, reason string) {
+	e := AuditEvent{Details: map[string]string{"reason": reason}}
+	if key != nil {
+		e.KeyID = key.ID
+		e.KeyName = key.Name
+	}
+	s.Audit.RecordRequest(r, AuditAuthFailure, status, e)
+}
+
+// API: Key 管理（GET 列表 / POST 创建 / DELETE 删除）
+func (s *AuthStore) handleKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		json.NewEncoder(w).Encode(s.List())
+
+	case "POST":
+		var req APIKey
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "请求格式错误: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		secret, key, err := s.Create(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp := *key
+		resp.Hash = ""
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"key":  secret, // 明文只返回这一次
+			"info": resp,
+		})
+
+	case "DELETE":
+		deleted, err := s.Delete(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "key 不存在", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"deleted": true})
+
+	default:
+		http.Error(w, "只接受 GET / POST / DELETE", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAuthStore(t *testing.T) *AuthStore {
+	t.Helper()
+	store, err := NewAuthStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestRequireScopes(t *testing.T) {
+	store := newTestAuthStore(t)
+	if err := store.Roles.Put(Role{Name: "web", Servers: []string{"web-*"}}); err != nil {
+		t.Fatal(err)
+	}
+	keys := make(map[string]string)
+	for name, spec := range map[string]APIKey{
+		"ingest":     {Name: "agent", Scopes: []string{ScopeIngest}},
+		"query":      {Name: "grafana", Scopes: []string{ScopeQuery}, Roles: []string{"web"}},
+		"admin":      {Name: "ops", Scopes: []string{ScopeAdmin}, Roles: []string{"web"}},
+		"both":       {Name: "tool", Scopes: []string{ScopeIngest, ScopeQuery}},
+		"admin-only": {Name: "root", Scopes: []string{ScopeAdmin}},
+	} {
+		secret, _, err := store.Create(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[name] = secret
+	}
+
+	var got *Principal
+	next := func(w http.ResponseWriter, r *http.Request) { got = principalFrom(r) }
+
+	cases := []struct {
+		key    string
+		header string
+		scope  string
+		status int
+	}{
+		{"ingest", "Authorization", ScopeIngest, http.StatusOK},
+		{"ingest", "Authorization", ScopeQuery, http.StatusForbidden},
+		{"ingest", "X-API-Key", ScopeAdmin, http.StatusForbidden},
+		{"query", "X-API-Key", ScopeQuery, http.StatusOK},
+		{"query", "Authorization", ScopeIngest, http.StatusForbidden},
+		{"both", "Authorization", ScopeQuery, http.StatusOK},
+		{"both", "Authorization", ScopeAdmin, http.StatusForbidden},
+		{"admin-only", "Authorization", ScopeIngest, http.StatusOK},
+		{"admin-only", "X-API-Key", ScopeAdmin, http.StatusOK},
+		{"", "", ScopeQuery, http.StatusUnauthorized},
+		{"bogus", "Authorization", ScopeQuery, http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		got = nil
+		r := httptest.NewRequest("GET", "/api/query", nil)
+		secret, ok := keys[c.key]
+		if !ok && c.key != "" {
+			secret = "ml_" + c.key
+		}
+		switch c.header {
+		case "Authorization":
+			r.Header.Set("Authorization", "bearer "+secret)
+		case "X-API-Key":
+			r.Header.Set("X-API-Key", secret)
+		}
+		w := httptest.NewRecorder()
+		store.Require(c.scope, next)(w, r)
+
+		if w.Code != c.status {
+			t.Errorf("%s key, scope %s: status %d, want %d", c.key, c.scope, w.Code, c.status)
+		}
+		if (got != nil) != (c.status == http.StatusOK) {
+			t.Errorf("%s key, scope %s: handler called = %v", c.key, c.scope, got != nil)
+		}
+		if c.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s key: 401 without WWW-Authenticate", c.key)
+		}
+	}
+
+	// 角色只限制非管理员
+	for name, restricted := range map[string]bool{"query": true, "admin": false, "both": false} {
+		r := httptest.NewRequest("GET", "/api/query", nil)
+		r.Header.Set("X-API-Key", keys[name])
+		store.Require(ScopeQuery, next)(httptest.NewRecorder(), r)
+		if got == nil || (got.Access != nil) != restricted {
+			t.Errorf("%s key: principal %+v, restricted = %v", name, got, restricted)
+		}
+	}
+}
+
+// 绑定服务器的 key 只能以该名称写入，未填写时自动使用绑定的名称
+func TestServerBoundKey(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.DataDir = t.TempDir()
+	store, err := NewAuthStore(cfg.DataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenants, err := NewTenantManager(cfg, cfg.IngestConfig([]byte("test-key")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replication := NewReplicationManager(cfg.Replication, tenants, cfg.DataDir)
+	handler := store.Require(ScopeIngest, tenants.Resolve(handleIngest(cfg, replication, NewCluster(cfg.Cluster))))
+	tenant, _ := tenants.Get(defaultTenant)
+	waitReplayed(t, tenant.Logs)
+
+	bound, _, err := store.Create(APIKey{Name: "web-01 agent", Scopes: []string{ScopeIngest}, Server: "web-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Format(columnTimeLayout)
+	for _, c := range []struct {
+		server string
+		status int
+	}{
+		{"web-01", http.StatusOK},
+		{"", http.StatusOK},
+		{"web-02", http.StatusForbidden},
+	} {
+		body := `{"timestamp":"` + now + `","level":"INFO","server":"` + c.server + `","message":"from ` + c.server + `"}`
+		r := httptest.NewRequest("POST", "/api/logs", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+bound)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != c.status {
+			t.Errorf("server %q: status %d, want %d (%s)", c.server, w.Code, c.status, w.Body.String())
+		}
+	}
+
+	if got := len(tenant.Logs.Query("", "web-01", "", "", "", 10, nil)); got != 2 {
+		t.Errorf("web-01: %d entries, want 2", got)
+	}
+	if got := len(tenant.Logs.Query("", "web-02", "", "", "", 10, nil)); got != 0 {
+		t.Errorf("web-02: %d entries written with a key bound to web-01", got)
+	}
+}
+
+// keys.json 只保存 SHA-256 哈希；重新打开后按明文查找，删除后失效
+func TestKeysStoredHashed(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewAuthStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, key, err := store.Create(APIKey{Name: "agent", Scopes: []string{ScopeIngest}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret, "ml_") {
+		t.Errorf("secret %q has no ml_ prefix", secret)
+	}
+	sum := sha256.Sum256([]byte(secret))
+	if key.Hash != hex.EncodeToString(sum[:]) || key.ID != key.Hash[:12] {
+		t.Errorf("key hash %q / id %q", key.Hash, key.ID)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "keys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), secret) || !strings.Contains(string(data), key.Hash) {
+		t.Errorf("keys.json must hold the hash only: %s", data)
+	}
+	for _, k := range store.List() {
+		if k.Hash != "" {
+			t.Errorf("List exposes the hash of %s", k.ID)
+		}
+	}
+
+	for _, spec := range []APIKey{
+		{Name: "none"},
+		{Name: "bad scope", Scopes: []string{"root"}},
+		{Name: "bad tenant", Scopes: []string{ScopeQuery}, Tenant: "../x"},
+		{Name: "unknown role", Scopes: []string{ScopeQuery}, Roles: []string{"missing"}},
+	} {
+		if _, _, err := store.Create(spec); err == nil {
+			t.Errorf("%s: expected error", spec.Name)
+		}
+	}
+
+	reopened, err := NewAuthStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k := reopened.Lookup(secret); k == nil || k.ID != key.ID {
+		t.Fatalf("lookup after reopen: %+v", k)
+	}
+	if reopened.Lookup(key.Hash) != nil {
+		t.Error("the stored hash works as a key")
+	}
+	if deleted, err := reopened.Delete(key.ID); !deleted || err != nil {
+		t.Fatalf("Delete: %v %v", deleted, err)
+	}
+	if reopened.Lookup(secret) != nil {
+		t.Error("deleted key still valid")
+	}
+}
+
+func TestBootstrapAdminKey(t *testing.T) {
+	t.Setenv("MINILOG_ADMIN_KEY", "")
+	store := newTestAuthStore(t)
+	secret, err := store.Bootstrap()
+	if err != nil || secret == "" {
+		t.Fatalf("Bootstrap: %q %v", secret, err)
+	}
+	if k := store.Lookup(secret); k == nil || !k.hasScope(ScopeAdmin) {
+		t.Fatalf("bootstrap key %+v is not an admin key", k)
+	}
+	// 已经有 key 时不再创建
+	if again, err := store.Bootstrap(); again != "" || err != nil || len(store.List()) != 1 {
+		t.Errorf("second Bootstrap: %q %v, %d keys", again, err, len(store.List()))
+	}
+
+	// MINILOG_ADMIN_KEY 指定明文时不打印，直接可用
+	t.Setenv("MINILOG_ADMIN_KEY", "ml_from_env")
+	store = newTestAuthStore(t)
+	if printed, err := store.Bootstrap(); printed != "" || err != nil {
+		t.Fatalf("Bootstrap with env: %q %v", printed, err)
+	}
+	if k := store.Lookup("ml_from_env"); k == nil || !k.hasScope(ScopeAdmin) {
+		t.Errorf("env admin key %+v", k)
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 备份：先刷盘，再在 flushMu 下冻结段列表（打开文件句柄、记录大小、读取索引），之后按冻结时的大小复制，
+// 之后的刷盘追加、压缩替换和分层移动都不影响备份内容。tar 中的路径相对 data_dir，
+// 最后一个文件是 manifest.json（每个文件的大小和 SHA-256）。
+// 包含：段和索引（热层）、zstd 字典、监控聚合、API key、角色、租户配额和脱敏密钥；
+// 不包含：WAL（刷盘后为空）、审计日志、冷层块缓存，以及温层和冷层中的段（它们本身在独立的存储里）
+const (
+	backupManifestName = "manifest.json"
+	backupVersion      = 1
+)
+
+type BackupManifest struct {
+	Version   int          `json:"version"`
+	CreatedAt string       `json:"created_at"`
+	Tenants   []string     `json:"tenants"`
+	Segments  int          `json:"segments"`
+	Entries   int          `json:"entries"`
+	Files     []BackupFile `json:"files"`
+}
+
+type BackupFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// 冻结的文件：小文件在冻结时读入内存，段文件保留句柄按冻结时的大小复制
+type frozenFile struct {
+	path string // tar 中的路径（/ 分隔）
+	data []byte
+	file *os.File
+	size int64
+}
+
+func (f frozenFile) reader() io.Reader {
+	if f.file != nil {
+		return io.NewSectionReader(f.file, 0, f.size)
+	}
+	return bytes.NewReader(f.data)
+}
+
+func closeFrozen(files []frozenFile) {
+	for _, f := range files {
+		if f.file != nil {
+			f.file.Close()
+		}
+	}
+}
+
+// 读取小文件（不存在时跳过）
+func freezeSmallFiles(dir, prefix string, patterns ...string) ([]frozenFile, error) {
+	files := make([]frozenFile, 0)
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		sort.Strings(matches)
+		for _, match := range matches {
+			data, err := os.ReadFile(match)
+			if err != nil {
+				return files, err
+			}
+			rel, _ := filepath.Rel(dir, match)
+			files = append(files, frozenFile{path: prefix + filepath.ToSlash(rel), data: data, size: int64(len(data))})
+		}
+	}
+	return files, nil
+}
+
+// 刷盘后冻结一个租户的段：返回段、索引和字典文件，以及段数和日志条数
+func (s *LogStorage) freezeForBackup(prefix string) ([]frozenFile, int, int, error) {
+	if _, err := s.flushToDisk(); err != nil {
+		return nil, 0, 0, err
+	}
+
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	s.swapMu.RLock()
+	defer s.swapMu.RUnlock()
+
+	files := make([]frozenFile, 0)
+	segments, entries := 0, 0
+	for _, segment := range listSegments(s.dataDir) {
+		f, err := os.Open(segment)
+		if err != nil {
+			closeFrozen(files)
+			return nil, 0, 0, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			closeFrozen(files)
+			return nil, 0, 0, err
+		}
+		index, ok := readSegmentIndex(segment, info.Size())
+		if !ok {
+			data := make([]byte, info.Size())
+			if _, err := f.ReadAt(data, 0); err != nil {
+				f.Close()
+				closeFrozen(files)
+				return nil, 0, 0, err
+			}
+			index = scanSegment(data, s.codecs)
+		}
+		indexData := encodeSegmentIndex(index)
+		files = append(files,
+			frozenFile{path: prefix + filepath.Base(segment), file: f, size: info.Size()},
+			frozenFile{path: prefix + filepath.Base(indexPath(segment)), data: indexData, size: int64(len(indexData))},
+		)
+		segments++
+		for _, c := range index {
+			entries += c.Entries
+		}
+	}
+
+	dicts, err := freezeSmallFiles(s.dataDir, prefix, "dicts/*.dict")
+	if err != nil {
+		closeFrozen(files)
+		return nil, 0, 0, err
+	}
+	return append(files, dicts...), segments, entries, nil
+}
+
+// 备份整个实例（所有租户）到 w
+func (m *TenantManager) Backup(w io.Writer) (*BackupManifest, error) {
+	manifest := &BackupManifest{Version: backupVersion, CreatedAt: time.Now().Format(time.RFC3339), Files: make([]BackupFile, 0)}
+
+	// 实例级状态
+	files, err := freezeSmallFiles(m.dataDir, "", "keys.json", "roles.json", "tenants.json", "redact.key")
+	if err != nil {
+		return nil, err
+	}
+
+	// 先冻结所有租户，再开始复制
+	tenants := m.List()
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].ID < tenants[j].ID })
+	for _, t := range tenants {
+		prefix := ""
+		if t.ID != defaultTenant {
+			prefix = "tenants/" + t.ID + "/"
+		}
+		frozen, segments, entries, err := t.Logs.freezeForBackup(prefix)
+		if err != nil {
+			closeFrozen(files)
+			return nil, fmt.Errorf("tenant %s: %v", t.ID, err)
+		}
+		files = append(files, frozen...)
+		metrics, err := freezeSmallFiles(t.dataDir, prefix, "metrics-*.json")
+		if err != nil {
+			closeFrozen(files)
+			return nil, fmt.Errorf("tenant %s: %v", t.ID, err)
+		}
+		files = append(files, metrics...)
+		manifest.Tenants = append(manifest.Tenants, t.ID)
+		manifest.Segments += segments
+		manifest.Entries += entries
+	}
+	defer closeFrozen(files)
+
+	tw := tar.NewWriter(w)
+	modTime := time.Now()
+	for _, f := range files {
+		header := &tar.Header{Name: f.path, Mode: 0644, Size: f.size, ModTime: modTime, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		sum := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(tw, sum), f.reader()); err != nil {
+			return nil, fmt.Errorf("%s: %v", f.path, err)
+		}
+		manifest.Files = append(manifest.Files, BackupFile{Path: f.path, Size: f.size, SHA256: hex.EncodeToString(sum.Sum(nil))})
+	}
+
+	data, _ := json.MarshalIndent(manifest, "", "  ")
+	if err := tw.WriteHeader(&tar.Header{Name: backupManifestName, Mode: 0644, Size: int64(len(data)), ModTime: modTime, Typeflag: tar.TypeReg}); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return nil, err
+	}
+	return manifest, tw.Close()
+}
+
+// 校验备份：每个文件的大小和校验和与 manifest 一致、没有多余或缺失的文件、路径不会逃出数据目录。
+// extractDir 不为空时同时解压到该目录
+func verifyBackup(r io.Reader, extractDir string) (*BackupManifest, error) {
+	type seenFile struct {
+		size int64
+		sum  string
+	}
+	seen := make(map[string]seenFile)
+	var manifest *BackupManifest
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive: %v", err)
+		}
+		if manifest != nil {
+			return nil, fmt.Errorf("unexpected %s after manifest", header.Name)
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("unexpected entry type for %s", header.Name)
+		}
+		name := header.Name
+		if name != path.Clean(name) || path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("unsafe path %q", name)
+		}
+		if _, dup := seen[name]; dup {
+			return nil, fmt.Errorf("duplicate file %s", name)
+		}
+
+		if name == backupManifestName {
+			data, err := io.ReadAll(io.LimitReader(tr, 64<<20))
+			if err != nil {
+				return nil, err
+			}
+			manifest = &BackupManifest{}
+			if err := json.Unmarshal(data, manifest); err != nil {
+				return nil, fmt.Errorf("invalid manifest: %v", err)
+			}
+			continue
+		}
+
+		sum := sha256.New()
+		size, err := copyBackupFile(tr, sum, extractDir, name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		seen[name] = seenFile{size: size, sum: hex.EncodeToString(sum.Sum(nil))}
+	}
+
+	if manifest == nil {
+		return nil, fmt.Errorf("manifest.json missing (archive truncated?)")
+	}
+	if manifest.Version != backupVersion {
+		return nil, fmt.Errorf("unsupported backup version %d", manifest.Version)
+	}
+	if len(manifest.Files) != len(seen) {
+		return nil, fmt.Errorf("manifest lists %d files, archive has %d", len(manifest.Files), len(seen))
+	}
+	for _, f := range manifest.Files {
+		got, ok := seen[f.Path]
+		if !ok {
+			return nil, fmt.Errorf("%s missing from archive", f.Path)
+		}
+		if got.size != f.Size || got.sum != f.SHA256 {
+			return nil, fmt.Errorf("%s checksum mismatch", f.Path)
+		}
+	}
+	return manifest, nil
+}
+
+func copyBackupFile(r io.Reader, sum hash.Hash, extractDir, name string) (int64, error) {
+	if extractDir == "" {
+		return io.Copy(sum, r)
+	}
+	target := filepath.Join(extractDir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return 0, err
+	}
+	mode := os.FileMode(0644)
+	if path.Base(name) == "redact.key" || name == "keys.json" {
+		mode = 0600
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return 0, err
+	}
+	size, err := io.Copy(io.MultiWriter(f, sum), r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return size, err
+}
+
+// 恢复到空的数据目录：先解压到临时目录并校验，全部通过后再改名为目标目录
+func restoreBackup(r io.Reader, dataDir string) (*BackupManifest, error) {
+	if entries, err := os.ReadDir(dataDir); err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("%s is not empty", dataDir)
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	tmp := strings.TrimRight(dataDir, string(filepath.Separator)) + ".restoring"
+	if err := os.RemoveAll(tmp); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return nil, err
+	}
+	manifest, err := verifyBackup(r, tmp)
+	if err != nil {
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+	os.Remove(dataDir) // 空目录
+	if err := os.Rename(tmp, dataDir); err != nil {
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// API: 下载备份（POST，记入审计日志）
+func (m *TenantManager) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "只接受POST", http.StatusMethodNotAllowed)
+		return
+	}
+	start := time.Now()
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=minilog-backup-%s.tar", start.Format("20060102-150405")))
+	manifest, err := m.Backup(w)
+	if err != nil {
+		// 已经开始写入时无法再返回错误状态，客户端校验 manifest 时会发现备份不完整
+		fmt.Println("❌ Backup failed:", err)
+		http.Error(w, "备份失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("💼 [Backup] %d tenants, %d segments, %d entries, %d files in %s\n",
+		len(manifest.Tenants), manifest.Segments, manifest.Entries, len(manifest.Files), time.Since(start).Round(time.Millisecond))
+}
+
+// minilog backup：从运行中的服务器下载备份并校验
+func runBackupCommand(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	server := fs.String("server", "http://localhost:8080", "MiniLog 服务器地址")
+	key := fs.String("key", os.Getenv("MINILOG_ADMIN_KEY"), "管理员 API key（默认 MINILOG_ADMIN_KEY）")
+	output := fs.String("o", "", "备份文件（.tar）")
+	fs.Parse(args)
+	if *output == "" {
+		return fmt.Errorf("-o is required")
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimRight(*server, "/")+"/api/backup", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+*key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	tmp := *output + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	manifest, err := verifyBackupFile(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("downloaded backup is invalid: %v", err)
+	}
+	if err := os.Rename(tmp, *output); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Backup written to %s: %d tenants, %d segments, %d entries, %d files\n",
+		*output, len(manifest.Tenants), manifest.Segments, manifest.Entries, len(manifest.Files))
+	return nil
+}
+
+// minilog restore：校验备份并恢复到空的数据目录（服务器不能在使用该目录）
+func runRestoreCommand(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	input := fs.String("i", "", "备份文件（.tar）")
+	dataDir := fs.String("data-dir", "data", "恢复到的数据目录（必须为空或不存在）")
+	verifyOnly := fs.Bool("verify", false, "只校验备份，不恢复")
+	fs.Parse(args)
+	if *input == "" {
+		return fmt.Errorf("-i is required")
+	}
+
+	if *verifyOnly {
+		manifest, err := verifyBackupFile(*input)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ Backup %s is valid: created %s, %d files\n", *input, manifest.CreatedAt, len(manifest.Files))
+		return nil
+	}
+
+	f, err := os.Open(*input)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	manifest, err := restoreBackup(f, *dataDir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✅ Restored backup from %s into %s: %d tenants, %d segments, %d entries\n",
+		manifest.CreatedAt, *dataDir, len(manifest.Tenants), manifest.Segments, manifest.Entries)
+	return nil
+}
+
+func verifyBackupFile(name string) (*BackupManifest, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return verifyBackup(f, "")
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 复制归档，用 edit 修改指定文件的内容（大小不变）
+func rewriteArchive(t *testing.T, archive []byte, name string, edit func([]byte)) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	tr := tar.NewReader(bytes.NewReader(archive))
+	tw := tar.NewWriter(&out)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		if header.Name == name {
+			edit(data)
+		}
+		tw.WriteHeader(header)
+		tw.Write(data)
+	}
+	tw.Close()
+	return out.Bytes()
+}
+
+func TestBackupRestoreVerifiesChecksums(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.DataDir = t.TempDir()
+	tenants, err := NewTenantManager(cfg, cfg.IngestConfig([]byte("test-key")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tenants.SetQuota("team-a", TenantQuota{}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Format(columnTimeLayout)
+	for _, tenant := range tenants.List() {
+		tenant.Logs.Append(LogEntry{Timestamp: now, Server: "web-01", Level: "INFO", Message: "hello from " + tenant.ID})
+	}
+
+	var archive bytes.Buffer
+	manifest, err := tenants.Backup(&archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Entries != 2 || len(manifest.Tenants) != 2 {
+		t.Fatalf("manifest: %d entries, tenants %v", manifest.Entries, manifest.Tenants)
+	}
+	segment := ""
+	for _, f := range manifest.Files {
+		if strings.HasPrefix(f.Path, "tenants/team-a/logs-") && strings.HasSuffix(f.Path, ".lz4") {
+			segment = f.Path
+		}
+	}
+	if segment == "" {
+		t.Fatalf("team-a segment missing from manifest: %+v", manifest.Files)
+	}
+
+	// 正常恢复：文件内容与原数据目录一致
+	restored := filepath.Join(t.TempDir(), "data")
+	if _, err := restoreBackup(bytes.NewReader(archive.Bytes()), restored); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := os.ReadFile(filepath.Join(cfg.DataDir, filepath.FromSlash(segment)))
+	got, err := os.ReadFile(filepath.Join(restored, filepath.FromSlash(segment)))
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("restored %s differs from the original (%v)", segment, err)
+	}
+
+	// 内容被改动、归档被截断：恢复失败，不留下任何目录
+	tampered := rewriteArchive(t, archive.Bytes(), segment, func(data []byte) { data[len(data)/2] ^= 0xff })
+	truncated := archive.Bytes()[:archive.Len()/2]
+	for name, data := range map[string][]byte{"tampered": tampered, "truncated": truncated} {
+		target := filepath.Join(t.TempDir(), "data")
+		_, err := restoreBackup(bytes.NewReader(data), target)
+		if err == nil {
+			t.Errorf("%s archive restored without error", name)
+			continue
+		}
+		if name == "tampered" && !strings.Contains(err.Error(), "checksum mismatch") {
+			t.Errorf("tampered archive: unexpected error %v", err)
+		}
+		for _, dir := range []string{target, target + ".restoring"} {
+			if _, err := os.Stat(dir); !os.IsNotExist(err) {
+				t.Errorf("%s archive left %s behind", name, dir)
+			}
+		}
+	}
+}
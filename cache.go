@@ -0,0 +1,164 @@
+package main
+
+import (
+	"container/list"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 冷层（远端）块的本地磁盘缓存：按段 ID（小时）分目录，<dir>/<段>/<offset>-<length>.chunk 和 <dir>/<段>/index，
+// 超过容量时淘汰最久未使用的文件；访问时更新文件修改时间，重启后按修改时间恢复顺序
+type chunkCache struct {
+	dir      string
+	maxBytes int64
+
+	mu        sync.Mutex
+	order     *list.List // 最近使用的在前
+	entries   map[string]*list.Element
+	bytes     int64
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+type cacheEntry struct {
+	key  string // <段>/<文件名>
+	size int64
+}
+
+func newChunkCache(dir string, maxBytes int64) *chunkCache {
+	c := &chunkCache{dir: dir, maxBytes: maxBytes, order: list.New(), entries: make(map[string]*list.Element)}
+
+	// 恢复已有的缓存文件（从旧到新加入，最新的在前）
+	type file struct {
+		key      string
+		size     int64
+		modified time.Time
+	}
+	files := make([]file, 0)
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || strings.HasSuffix(path, ".tmp") {
+			os.Remove(path)
+			return nil
+		}
+		files = append(files, file{key: filepath.ToSlash(rel), size: info.Size(), modified: info.ModTime()})
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].modified.Before(files[j].modified) })
+	for _, f := range files {
+		c.entries[f.key] = c.order.PushFront(&cacheEntry{key: f.key, size: f.size})
+		c.bytes += f.size
+	}
+	c.mu.Lock()
+	c.evictLocked()
+	c.mu.Unlock()
+	return c
+}
+
+func (c *chunkCache) path(key string) string {
+	return filepath.Join(c.dir, filepath.FromSlash(key))
+}
+
+func (c *chunkCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		c.removeLocked(elem)
+		c.misses++
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	now := time.Now()
+	os.Chtimes(c.path(key), now, now)
+	c.hits++
+	return data, true
+}
+
+// 写入缓存（关闭缓存或单个文件超过容量时不缓存，写入失败忽略）
+func (c *chunkCache) put(key string, data []byte) {
+	if int64(len(data)) > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.removeLocked(elem)
+	}
+
+	file := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, size: int64(len(data))})
+	c.bytes += int64(len(data))
+	c.evictLocked()
+}
+
+// 删除一个段的全部缓存（段在远端被追加或删除后）
+func (c *chunkCache) dropSegment(segment string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, elem := range c.entries {
+		if strings.HasPrefix(key, segment+"/") {
+			c.removeLocked(elem)
+		}
+	}
+	os.RemoveAll(filepath.Join(c.dir, segment))
+}
+
+func (c *chunkCache) evictLocked() {
+	for c.bytes > c.maxBytes && c.order.Len() > 0 {
+		c.removeLocked(c.order.Back())
+		c.evictions++
+	}
+}
+
+func (c *chunkCache) removeLocked(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+	c.order.Remove(elem)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.size
+	os.Remove(c.path(entry.key))
+}
+
+// 缓存统计（/api/stats）
+func (c *chunkCache) stats() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ratio := 0.0
+	if c.hits+c.misses > 0 {
+		ratio = float64(c.hits) / float64(c.hits+c.misses)
+	}
+	return map[string]interface{}{
+		"dir":       c.dir,
+		"entries":   len(c.entries),
+		"bytes":     c.bytes,
+		"max_bytes": c.maxBytes,
+		"hits":      c.hits,
+		"misses":    c.misses,
+		"evictions": c.evictions,
+		"hit_ratio": ratio,
+	}
+}
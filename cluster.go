@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 集群（分片）：每条日志按分片键（服务器或租户）用 rendezvous 哈希分配到一个节点，
+// 写到其它节点的日志转发给所属节点；任意节点都可以作为查询协调者，把 /api/query 分发到所有节点，
+// 按时间戳合并并截断到 limit，超时或不可用的节点跳过，响应头标记结果不完整。
+// 节点之间用 cluster.key（各节点上的管理员 key）认证。
+const (
+	clusterForwardedHeader = "X-MiniLog-Forwarded" // 转发的写入（值为来源节点），所属节点直接写入本地
+	shardByServer          = "server"
+	shardByTenant          = "tenant"
+)
+
+// 集群配置（只在启动时读取）
+type ClusterConfig struct {
+	Self    string        `yaml:"self"`     // 本节点名称（必须在 nodes 中）
+	Nodes   []ClusterNode `yaml:"nodes"`    // 所有节点（为空表示不启用集群）
+	ShardBy string        `yaml:"shard_by"` // server / tenant
+	Key     string        `yaml:"key"`      // 节点之间使用的管理员 API key（也可以用 MINILOG_CLUSTER_KEY）
+	Timeout time.Duration `yaml:"timeout"`  // 转发写入和查询单个节点的超时
+}
+
+type ClusterNode struct {
+	Name string `yaml:"name" json:"name"`
+	URL  string `yaml:"url" json:"url"`
+}
+
+// 集群：未启用时所有方法都按单机处理
+type Cluster struct {
+	cfg    ClusterConfig
+	client *http.Client
+
+	stats struct {
+		forwarded      atomic.Int64 // 转发给其它节点的写入
+		forwardErrors  atomic.Int64
+		queries        atomic.Int64 // 作为协调者的查询
+		partialQueries atomic.Int64 // 有节点失败的查询
+	}
+	mu    sync.Mutex
+	nodes map[string]*clusterNodeState
+}
+
+// 节点最近一次请求的结果（/api/cluster）
+type clusterNodeState struct {
+	lastOK    time.Time
+	lastError string
+	errorAt   time.Time
+}
+
+func NewCluster(cfg ClusterConfig) *Cluster {
+	c := &Cluster{cfg: cfg, client: &http.Client{}, nodes: make(map[string]*clusterNodeState)}
+	for _, node := range cfg.Nodes {
+		c.nodes[node.Name] = &clusterNodeState{}
+	}
+	if c.Enabled() {
+		fmt.Printf("🧩 [Cluster] Node %s of %d, sharding by %s\n", cfg.Self, len(cfg.Nodes), cfg.ShardBy)
+	}
+	return c
+}
+
+func (c *Cluster) Enabled() bool {
+	return len(c.cfg.Nodes) > 1
+}
+
+// 分片键：按服务器分片时同一租户的不同服务器分散到不同节点
+func (c *Cluster) shardKey(tenant, server string) string {
+	if c.cfg.ShardBy == shardByTenant {
+		return tenant
+	}
+	return tenant + "\x00" + server
+}
+
+// rendezvous 哈希：每个节点对分片键打分，分数最高的节点拥有该分片（增删节点只影响该节点的分片）
+func (c *Cluster) ownerOf(key string) ClusterNode {
+	var best ClusterNode
+	var bestScore uint64
+	for _, node := range c.cfg.Nodes {
+		h := fnv.New64a()
+		h.Write([]byte(node.Name))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		if score := mix64(h.Sum64()); best.Name == "" || score > bestScore {
+			best, bestScore = node, score
+		}
+	}
+	return best
+}
+
+// FNV 的高位对前面的字节不敏感（节点名只差一个字符时打分几乎总是同一个节点赢），再做一次 splitmix64 混合
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// 日志所属的其它节点；属于本节点或未启用集群时返回 nil
+func (c *Cluster) Owner(tenant, server string) *ClusterNode {
+	if !c.Enabled() {
+		return nil
+	}
+	owner := c.ownerOf(c.shardKey(tenant, server))
+	if owner.Name == c.cfg.Self {
+		return nil
+	}
+	return &owner
+}
+
+// 其它节点转发来的写入（只认管理员 key，普通 key 带上这个头也照常分片）
+func (c *Cluster) Forwarded(r *http.Request) bool {
+	principal := principalFrom(r)
+	return r.Header.Get(clusterForwardedHeader) != "" && principal != nil && principal.isAdmin()
+}
+
+// 请求另一个节点（X-Tenant 选择租户）
+func (c *Cluster) do(ctx context.Context, node ClusterNode, method, path, tenant string, body []byte) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(node.URL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.cfg.Key)
+	req.Header.Set("X-Tenant", tenant)
+	req.Header.Set(clusterForwardedHeader, c.cfg.Self)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		cancel()
+		c.record(node.Name, err)
+		return nil, err
+	}
+	// 读完响应后再取消
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+func (c *Cluster) record(node string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state := c.nodes[node]
+	if state == nil {
+		return
+	}
+	if err != nil {
+		state.lastError = err.Error()
+		state.errorAt = time.Now()
+		return
+	}
+	state.lastOK = time.Now()
+}
+
+// 转发写入，把所属节点的响应原样返回；所属节点不可用时返回 503，由 Agent 重试
+func (c *Cluster) Forward(w http.ResponseWriter, r *http.Request, node *ClusterNode, tenant string, log LogEntry) {
+	body, _ := json.Marshal(log)
+	resp, err := c.do(r.Context(), *node, "POST", "/api/logs", tenant, body)
+	if err != nil {
+		c.stats.forwardErrors.Add(1)
+		http.Error(w, "分片节点 "+node.Name+" 不可用: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer resp.Body.Close()
+	c.record(node.Name, nil)
+	c.stats.forwarded.Add(1)
+	w.Header().Set("X-MiniLog-Shard", node.Name)
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// 查询条件（转发给其它节点时原样带上）
+type queryParams struct {
+	Keyword, Server, Level, Since, Until string
+	Limit                                int
+}
+
+// 分发查询的结果
+type clusterQueryResult struct {
+	Entries []LogEntry
+	Nodes   int
+	Failed  map[string]string // 节点 -> 错误
+}
+
+// 协调查询：本节点直接查，其它节点并发请求（scope=local），超时的节点记为失败；
+// 其它节点用集群 key 查询，返回的日志在这里按调用方的角色过滤、掩码后重新匹配
+func (c *Cluster) Query(ctx context.Context, tenant *Tenant, q queryParams, access *AccessPolicy) clusterQueryResult {
+	c.stats.queries.Add(1)
+	result := clusterQueryResult{Nodes: len(c.cfg.Nodes), Failed: make(map[string]string)}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, node := range c.cfg.Nodes {
+		wg.Add(1)
+		go func(node ClusterNode) {
+			defer wg.Done()
+			var entries []LogEntry
+			var err error
+			if node.Name == c.cfg.Self {
+				entries = tenant.Logs.Query(q.Keyword, q.Server, q.Level, q.Since, q.Until, q.Limit, access)
+			} else {
+				entries, err = c.queryNode(ctx, node, tenant.ID, q)
+				if err == nil {
+					entries = filterRemoteEntries(tenant.Logs, entries, q, access)
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result.Failed[node.Name] = err.Error()
+				return
+			}
+			result.Entries = append(result.Entries, entries...)
+		}(node)
+	}
+	wg.Wait()
+
+	// 新的在前，和单机查询的顺序一致
+	sort.SliceStable(result.Entries, func(i, j int) bool {
+		return result.Entries[i].Timestamp > result.Entries[j].Timestamp
+	})
+	if len(result.Entries) > q.Limit {
+		result.Entries = result.Entries[:q.Limit]
+	}
+	if len(result.Failed) > 0 {
+		c.stats.partialQueries.Add(1)
+		for name, err := range result.Failed {
+			fmt.Printf("⚠️  [Cluster] Query on node %s failed: %s\n", name, err)
+		}
+	}
+	return result
+}
+
+func (c *Cluster) queryNode(ctx context.Context, node ClusterNode, tenant string, q queryParams) ([]LogEntry, error) {
+	query := url.Values{
+		"keyword": {q.Keyword},
+		"server":  {q.Server},
+		"level":   {q.Level},
+		"since":   {q.Since},
+		"until":   {q.Until},
+		"limit":   {strconv.Itoa(q.Limit)},
+		"format":  {"json"},
+		"scope":   {"local"},
+	}
+	resp, err := c.do(ctx, node, "GET", "/api/query?"+query.Encode(), tenant, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err := fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+		c.record(node.Name, err)
+		return nil, err
+	}
+	var entries []LogEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		c.record(node.Name, err)
+		return nil, err
+	}
+	c.record(node.Name, nil)
+	return entries, nil
+}
+
+// 其它节点返回的是未掩码的结果：按角色过滤、先掩码再匹配（和本地查询一样，不能通过关键字探测被隐藏的字段）
+func filterRemoteEntries(s *LogStorage, entries []LogEntry, q queryParams, access *AccessPolicy) []LogEntry {
+	if access == nil {
+		return entries
+	}
+	level := parseLevelFilter(q.Level)
+	keyword, server := strings.ToLower(q.Keyword), strings.ToLower(q.Server)
+	result := make([]LogEntry, 0, len(entries))
+	for _, log := range entries {
+		if !access.AllowEntry(log) {
+			continue
+		}
+		if log = access.Mask(log); s.matchLogWithFilters(log, keyword, server, level) {
+			result = append(result, log)
+		}
+	}
+	return result
+}
+
+// 写入分片结果的响应头：节点数、是否完整、失败的节点
+func (r clusterQueryResult) setHeaders(w http.ResponseWriter) {
+	w.Header().Set("X-MiniLog-Nodes", strconv.Itoa(r.Nodes))
+	w.Header().Set("X-MiniLog-Partial", strconv.FormatBool(len(r.Failed) > 0))
+	if len(r.Failed) > 0 {
+		names := make([]string, 0, len(r.Failed))
+		for name := range r.Failed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		w.Header().Set("X-MiniLog-Failed-Nodes", strings.Join(names, ","))
+	}
+}
+
+// API: 集群状态（?server= 或 ?tenant= 查询分片所属节点）
+func (c *Cluster) handleCluster(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	result := map[string]interface{}{"enabled": c.Enabled()}
+	if !c.Enabled() {
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	c.mu.Lock()
+	nodes := make([]map[string]interface{}, 0, len(c.cfg.Nodes))
+	for _, node := range c.cfg.Nodes {
+		entry := map[string]interface{}{"name": node.Name, "url": node.URL, "self": node.Name == c.cfg.Self}
+		state := c.nodes[node.Name]
+		if !state.lastOK.IsZero() {
+			entry["last_ok"] = state.lastOK.Format("2006-01-02 15:04:05")
+		}
+		if state.lastError != "" && state.errorAt.After(state.lastOK) {
+			entry["last_error"] = state.lastError
+			entry["error_at"] = state.errorAt.Format("2006-01-02 15:04:05")
+		}
+		nodes = append(nodes, entry)
+	}
+	c.mu.Unlock()
+
+	result["self"] = c.cfg.Self
+	result["shard_by"] = c.cfg.ShardBy
+	result["nodes"] = nodes
+	result["forwarded"] = c.stats.forwarded.Load()
+	result["forward_errors"] = c.stats.forwardErrors.Load()
+	result["queries"] = c.stats.queries.Load()
+	result["partial_queries"] = c.stats.partialQueries.Load()
+
+	q := r.URL.Query()
+	if q.Has("server") || q.Has("tenant") {
+		tenant := q.Get("tenant")
+		if tenant == "" {
+			tenant = defaultTenant
+		}
+		result["owner"] = c.ownerOf(c.shardKey(tenant, q.Get("server"))).Name
+	}
+	json.NewEncoder(w).Encode(result)
+}
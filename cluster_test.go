@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func clusterNodes(names ...string) []ClusterNode {
+	nodes := make([]ClusterNode, len(names))
+	for i, name := range names {
+		nodes[i] = ClusterNode{Name: name, URL: "http://" + name + ":8080"}
+	}
+	return nodes
+}
+
+// rendezvous 哈希：分片大致均匀；增删节点只移动新节点 / 被删节点的分片
+func TestOwnerOfDistributionAndStability(t *testing.T) {
+	const keys = 6000
+	three := &Cluster{cfg: ClusterConfig{Nodes: clusterNodes("node-1", "node-2", "node-3")}}
+	four := &Cluster{cfg: ClusterConfig{Nodes: clusterNodes("node-1", "node-2", "node-3", "node-4")}}
+	two := &Cluster{cfg: ClusterConfig{Nodes: clusterNodes("node-1", "node-3")}}
+
+	counts := make(map[string]int)
+	moved := 0
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("default\x00web-%d", i)
+		owner := three.ownerOf(key).Name
+		counts[owner]++
+		if owner != three.ownerOf(key).Name {
+			t.Fatalf("%q: owner is not stable", key)
+		}
+
+		// 加节点：分片要么不动，要么移到新节点
+		if added := four.ownerOf(key).Name; added != owner {
+			if added != "node-4" {
+				t.Errorf("%q moved from %s to %s after adding node-4", key, owner, added)
+			}
+			moved++
+		}
+		// 删节点：只有 node-2 的分片移动
+		if removed := two.ownerOf(key).Name; owner != "node-2" && removed != owner {
+			t.Errorf("%q moved from %s to %s after removing node-2", key, owner, removed)
+		}
+	}
+
+	for _, node := range three.cfg.Nodes {
+		if share := float64(counts[node.Name]) / keys; share < 0.28 || share > 0.39 {
+			t.Errorf("%s owns %.1f%% of the keys", node.Name, share*100)
+		}
+	}
+	if share := float64(moved) / keys; share < 0.2 || share > 0.3 {
+		t.Errorf("adding a fourth node moved %.1f%% of the keys, want about 25%%", share*100)
+	}
+}
+
+// 只有管理员 key 带上转发头才算转发的写入
+func TestForwardedRequiresAdmin(t *testing.T) {
+	c := NewCluster(ClusterConfig{})
+	admin := &Principal{Name: "node-2", Scopes: []string{ScopeAdmin}}
+	ingest := &Principal{Name: "agent", Scopes: []string{ScopeIngest}}
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		header    string
+		want      bool
+	}{
+		{"admin with header", admin, "node-2", true},
+		{"ingest key with header", ingest, "node-2", false},
+		{"admin without header", admin, "", false},
+		{"unauthenticated", nil, "node-2", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/api/logs", nil)
+		if tt.header != "" {
+			r.Header.Set(clusterForwardedHeader, tt.header)
+		}
+		if tt.principal != nil {
+			r = r.WithContext(context.WithValue(r.Context(), principalKey{}, tt.principal))
+		}
+		if got := c.Forwarded(r); got != tt.want {
+			t.Errorf("%s: Forwarded = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// 超时的节点记为失败，其它节点的结果照常合并
+func TestClusterQueryPartialResults(t *testing.T) {
+	now := time.Now()
+	at := func(d time.Duration) string { return now.Add(d).Format(columnTimeLayout) }
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("scope") != "local" || r.Header.Get(clusterForwardedHeader) != "node-1" {
+			t.Errorf("unexpected node query: %s %v", r.URL, r.Header)
+		}
+		json.NewEncoder(w).Encode([]LogEntry{
+			{Timestamp: at(-time.Minute), Server: "web-02", Level: "INFO", Message: "remote older"},
+			{Timestamp: at(time.Second), Server: "web-02", Level: "INFO", Message: "remote newest"},
+		})
+	}))
+	defer healthy.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer slow.Close()
+
+	c := NewCluster(ClusterConfig{
+		Self:    "node-1",
+		ShardBy: shardByServer,
+		Timeout: 200 * time.Millisecond,
+		Nodes: []ClusterNode{
+			{Name: "node-1", URL: "http://unused"},
+			{Name: "node-2", URL: healthy.URL},
+			{Name: "node-3", URL: slow.URL},
+		},
+	})
+	s := NewLogStorage(t.TempDir(), defaultConfig().Storage, nil, defaultTenant)
+	waitReplayed(t, s)
+	s.Append(LogEntry{Timestamp: at(0), Server: "web-01", Level: "INFO", Message: "local"})
+
+	start := time.Now()
+	result := c.Query(context.Background(), &Tenant{ID: defaultTenant, Logs: s}, queryParams{Since: at(-time.Hour), Limit: 2}, nil)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("query waited %v for the slow node", elapsed)
+	}
+	if _, ok := result.Failed["node-3"]; !ok || len(result.Failed) != 1 {
+		t.Errorf("failed nodes = %v, want node-3", result.Failed)
+	}
+	if len(result.Entries) != 2 || result.Entries[0].Message != "remote newest" || result.Entries[1].Message != "local" {
+		t.Errorf("merged entries = %+v, want newest two", result.Entries)
+	}
+
+	w := httptest.NewRecorder()
+	result.setHeaders(w)
+	if w.Header().Get("X-MiniLog-Partial") != "true" || w.Header().Get("X-MiniLog-Failed-Nodes") != "node-3" || w.Header().Get("X-MiniLog-Nodes") != "3" {
+		t.Errorf("headers = %v", w.Header())
+	}
+	if c.stats.partialQueries.Load() != 1 {
+		t.Errorf("partial queries = %d, want 1", c.stats.partialQueries.Load())
+	}
+}
+
+// 其它节点返回的日志先按角色过滤和掩码，再做关键字匹配
+func TestFilterRemoteEntriesMasksBeforeMatching(t *testing.T) {
+	s := &LogStorage{}
+	access := compileAccessPolicy([]Role{{Name: "web", Servers: []string{"web-*"}, Levels: []string{">=WARN"}, DenyFields: []string{"message", "token"}}})
+	entries := []LogEntry{
+		{Server: "web-01", Level: "ERROR", Message: "password=hunter2", Fields: map[string]string{"token": "hunter2"}},
+		{Server: "web-01", Level: "ERROR", Message: "disk full", Fields: map[string]string{"path": "/var/hunter2"}},
+		{Server: "db-01", Level: "ERROR", Message: "hunter2 on db"},
+		{Server: "web-02", Level: "INFO", Message: "hunter2 at info"},
+	}
+
+	got := filterRemoteEntries(s, entries, queryParams{Keyword: "hunter2"}, access)
+	if len(got) != 1 || got[0].Message != maskedValue || got[0].Fields["path"] != "/var/hunter2" {
+		t.Fatalf("keyword matched hidden data: %+v", got)
+	}
+
+	all := filterRemoteEntries(s, entries, queryParams{}, access)
+	if len(all) != 2 {
+		t.Fatalf("role filter: %d entries, want 2 (web-*, >=WARN)", len(all))
+	}
+	if all[0].Message != maskedValue || all[0].Fields["token"] != maskedValue {
+		t.Errorf("entry not masked: %+v", all[0])
+	}
+	if entries[0].Fields["token"] != "hunter2" {
+		t.Error("masking modified the caller's entry")
+	}
+
+	// 不受限制的调用方原样返回
+	if got := filterRemoteEntries(s, entries, queryParams{Keyword: "hunter2"}, nil); len(got) != len(entries) {
+		t.Errorf("nil policy filtered entries: %d", len(got))
+	}
+}
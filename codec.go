@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// 块压缩编码：编码名写在块分隔符里（===CHUNK_<unix>_<codec>===），读取时按块选择解码器，
+// 同一个段中可以混合不同编码。旧的块没有编码名，按 lz4 读取
+type Codec interface {
+	Name() string
+	Compress(plain []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// 可以在配置中使用的编码（zstd 字典编码名为 zstd.<字典 ID>，由压缩任务生成）
+var codecNames = []string{"lz4", "zstd", "none"}
+
+// 编码族：zstd.1a2b3c4d -> zstd
+func codecFamily(name string) string {
+	if name == "" {
+		return "lz4"
+	}
+	return strings.SplitN(name, ".", 2)[0]
+}
+
+// 数据压缩配置
+type CompressionConfig struct {
+	Hot        string        `yaml:"hot"`        // 刷盘使用的编码（写入快）
+	Cold       string        `yaml:"cold"`       // 段超过 cold_after 后重新压缩为该编码（压缩率高）
+	ColdAfter  time.Duration `yaml:"cold_after"` // 0 表示不转冷
+	Dictionary bool          `yaml:"dictionary"` // cold 为 zstd 时用已有日志训练字典，小块压缩率更高
+}
+
+// 按段的时间选择编码族
+func (c CompressionConfig) codecFor(hour time.Time, now time.Time) string {
+	if c.ColdAfter > 0 && now.Sub(hour.Add(time.Hour)) >= c.ColdAfter {
+		return c.Cold
+	}
+	return c.Hot
+}
+
+type lz4Codec struct{}
+
+func (lz4Codec) Name() string { return "lz4" }
+
+func (lz4Codec) Compress(plain []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := lz4.NewWriter(&buf)
+	if _, err := writer.Write(plain); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (lz4Codec) Decompress(data []byte) ([]byte, error) {
+	return io.ReadAll(lz4.NewReader(bytes.NewReader(data)))
+}
+
+type noneCodec struct{}
+
+func (noneCodec) Name() string { return "none" }
+
+func (noneCodec) Compress(plain []byte) ([]byte, error) { return plain, nil }
+
+func (noneCodec) Decompress(data []byte) ([]byte, error) { return data, nil }
+
+// zstd（可选字典）；Encoder/Decoder 的 EncodeAll/DecodeAll 可以并发调用
+type zstdCodec struct {
+	name    string
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func newZstdCodec(name string, dict []byte) (*zstdCodec, error) {
+	encOpts := []zstd.EOption{zstd.WithEncoderLevel(zstd.SpeedBetterCompression), zstd.WithEncoderConcurrency(1)}
+	decOpts := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+	if dict != nil {
+		encOpts = append(encOpts, zstd.WithEncoderDict(dict))
+		decOpts = append(decOpts, zstd.WithDecoderDicts(dict))
+	}
+	encoder, err := zstd.NewWriter(nil, encOpts...)
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := zstd.NewReader(nil, decOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return &zstdCodec{name: name, encoder: encoder, decoder: decoder}, nil
+}
+
+func (c *zstdCodec) Name() string { return c.name }
+
+func (c *zstdCodec) Compress(plain []byte) ([]byte, error) {
+	return c.encoder.EncodeAll(plain, nil), nil
+}
+
+func (c *zstdCodec) Decompress(data []byte) ([]byte, error) {
+	return c.decoder.DecodeAll(data, nil)
+}
+
+// 每个存储目录一组编码；字典保存在 dicts/<ID>.dict，首次用到时加载
+type codecSet struct {
+	dir string
+
+	mu     sync.Mutex
+	codecs map[string]Codec
+}
+
+func newCodecSet(dataDir string) *codecSet {
+	return &codecSet{
+		dir:    filepath.Join(dataDir, "dicts"),
+		codecs: map[string]Codec{"lz4": lz4Codec{}, "none": noneCodec{}},
+	}
+}
+
+func (c *codecSet) get(name string) (Codec, error) {
+	if name == "" {
+		name = "lz4"
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if codec, ok := c.codecs[name]; ok {
+		return codec, nil
+	}
+
+	var codec Codec
+	switch {
+	case name == "zstd":
+		zc, err := newZstdCodec(name, nil)
+		if err != nil {
+			return nil, err
+		}
+		codec = zc
+	case strings.HasPrefix(name, "zstd."):
+		dict, err := os.ReadFile(filepath.Join(c.dir, strings.TrimPrefix(name, "zstd.")+".dict"))
+		if err != nil {
+			return nil, fmt.Errorf("zstd dictionary for %s: %v", name, err)
+		}
+		zc, err := newZstdCodec(name, dict)
+		if err != nil {
+			return nil, err
+		}
+		codec = zc
+	default:
+		return nil, fmt.Errorf("unknown codec %q", name)
+	}
+	c.codecs[name] = codec
+	return codec, nil
+}
+
+// 字典编码：使用最新的字典，没有时用样本训练一个（样本太少训练失败时退回不带字典的 zstd）
+func (c *codecSet) dictionaryCodec(samples []string) (Codec, error) {
+	if name := c.latestDictionary(); name != "" {
+		return c.get(name)
+	}
+
+	dict, id, err := trainDictionary(samples)
+	if err != nil {
+		fmt.Println("⚠️  Cannot train zstd dictionary, using plain zstd:", err)
+		return c.get("zstd")
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(c.dir, fmt.Sprintf("%08x.dict", id)), dict, 0644); err != nil {
+		return nil, err
+	}
+	fmt.Printf("📚 [Codec] Trained zstd dictionary %08x (%d B) in %s\n", id, len(dict), c.dir)
+	return c.get(fmt.Sprintf("zstd.%08x", id))
+}
+
+func (c *codecSet) latestDictionary() string {
+	files, _ := filepath.Glob(filepath.Join(c.dir, "*.dict"))
+	latest, latestTime := "", time.Time{}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if latest == "" || info.ModTime().After(latestTime) {
+			latest, latestTime = file, info.ModTime()
+		}
+	}
+	if latest == "" {
+		return ""
+	}
+	return "zstd." + strings.TrimSuffix(filepath.Base(latest), ".dict")
+}
+
+// 训练字典：均匀抽取样本行拼接作为字典内容（最多 64KB），全部样本按 4KB 分组用于统计编码表
+func trainDictionary(lines []string) (dict []byte, id uint32, err error) {
+	const maxHistory = 64 * 1024
+	step := 1
+	total := 0
+	for _, line := range lines {
+		total += len(line) + 1
+	}
+	if total > maxHistory {
+		step = total/maxHistory + 1
+	}
+
+	var history bytes.Buffer
+	for i := 0; i < len(lines); i += step {
+		if history.Len()+len(lines[i])+1 > maxHistory {
+			break
+		}
+		history.WriteString(lines[i])
+		history.WriteByte('\n')
+	}
+
+	contents := make([][]byte, 0)
+	var block bytes.Buffer
+	for _, line := range lines {
+		block.WriteString(line)
+		block.WriteByte('\n')
+		if block.Len() >= 4096 {
+			contents = append(contents, append([]byte{}, block.Bytes()...))
+			block.Reset()
+		}
+	}
+	if block.Len() > 0 {
+		contents = append(contents, block.Bytes())
+	}
+
+	// 样本太少时 BuildDict 会 panic（除零），转为错误
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("not enough samples (%d lines): %v", len(lines), r)
+		}
+	}()
+	id = crc32.ChecksumIEEE(history.Bytes())
+	if id == 0 {
+		id = 1
+	}
+	dict, err = zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       id,
+		Contents: contents,
+		History:  history.Bytes(),
+		Offsets:  [3]int{1, 4, 8},
+	})
+	return dict, id, err
+}
+
+// 块分隔符：===CHUNK_<unix>_<codec>_<layout>===\n
+// 旧格式 ===CHUNK_<unix>===\n 为 lz4 行式，===CHUNK_<unix>_<codec>===\n 为行式
+func chunkHeader(created time.Time, codec, layout string) string {
+	return fmt.Sprintf("%s%d_%s_%s===\n", chunkMarker, created.Unix(), codec, layout)
+}
+
+// 解析分隔符，返回编码名、布局和压缩数据
+func parseChunkHeader(chunk []byte) (string, string, []byte, error) {
+	idx := bytes.IndexByte(chunk, '\n')
+	if idx == -1 || !bytes.HasPrefix(chunk, []byte(chunkMarker)) {
+		return "", "", nil, fmt.Errorf("invalid chunk header")
+	}
+	header := strings.TrimSuffix(string(chunk[len(chunkMarker):idx]), "===")
+	parts := strings.SplitN(header, "_", 3)
+	if _, err := strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return "", "", nil, fmt.Errorf("invalid chunk header %q", header)
+	}
+	codec, layout := "lz4", layoutRows
+	if len(parts) >= 2 {
+		codec = parts[1]
+	}
+	if len(parts) == 3 {
+		layout = parts[2]
+	}
+	return codec, layout, chunk[idx+1:], nil
+}
+
+type codecTotals struct {
+	chunks     int
+	raw        int64
+	compressed int64
+}
+
+// 每个段的编码统计，按段文件的大小和修改时间失效（追加、压缩替换都会改变两者）
+type codecStatsCache struct {
+	mu       sync.Mutex
+	segments map[string]cachedCodecTotals
+}
+
+type cachedCodecTotals struct {
+	size    int64
+	modTime time.Time
+	totals  map[string]codecTotals
+}
+
+// 一个段的编码统计：缓存命中时不读段和索引，未命中时才读取（没有索引的段需要解压扫描）
+func (s *LogStorage) segmentCodecTotals(path string, seen map[string]cachedCodecTotals) (map[string]codecTotals, bool) {
+	s.swapMu.RLock()
+	defer s.swapMu.RUnlock()
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+	s.codecTotals.mu.Lock()
+	cached, ok := s.codecTotals.segments[path]
+	s.codecTotals.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		seen[path] = cached
+		return cached.totals, true
+	}
+
+	chunks, _, _, err := segmentChunks(path, s.codecs)
+	if err != nil {
+		return nil, false
+	}
+	totals := make(map[string]codecTotals)
+	for _, c := range chunks {
+		family := codecFamily(c.Codec)
+		t := totals[family]
+		t.chunks++
+		t.raw += c.RawBytes
+		t.compressed += c.Length
+		totals[family] = t
+	}
+	seen[path] = cachedCodecTotals{size: info.Size(), modTime: info.ModTime(), totals: totals}
+	return totals, true
+}
+
+// 各编码的块数、原始大小和压缩后大小（按段索引统计，/api/stats）
+func (s *LogStorage) codecStats() map[string]interface{} {
+	totals := make(map[string]*codecTotals)
+	seen := make(map[string]cachedCodecTotals)
+	for _, path := range listSegments(s.dataDir) {
+		segment, ok := s.segmentCodecTotals(path, seen)
+		if !ok {
+			continue
+		}
+		for family, c := range segment {
+			if totals[family] == nil {
+				totals[family] = &codecTotals{}
+			}
+			totals[family].chunks += c.chunks
+			totals[family].raw += c.raw
+			totals[family].compressed += c.compressed
+		}
+	}
+	// 只保留仍然存在的段
+	s.codecTotals.mu.Lock()
+	s.codecTotals.segments = seen
+	s.codecTotals.mu.Unlock()
+
+	result := make(map[string]interface{}, len(totals))
+	for name, t := range totals {
+		ratio := 0.0
+		if t.compressed > 0 {
+			ratio = float64(t.raw) / float64(t.compressed)
+		}
+		result[name] = map[string]interface{}{
+			"chunks":           t.chunks,
+			"raw_bytes":        t.raw,
+			"compressed_bytes": t.compressed,
+			"ratio":            fmt.Sprintf("%.1f:1", ratio),
+		}
+	}
+	return result
+}
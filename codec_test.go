@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// /api/stats 的编码统计按段缓存：段没变时不重新读取索引，追加后重新统计，删除的段不再计入
+func TestCodecStatsCachedPerSegment(t *testing.T) {
+	s := newCompactTestStorage(t)
+	now := time.Now()
+	hour := now.Add(-2 * time.Hour).Truncate(time.Hour)
+	segment := segmentPath(s.dataDir, hour.Format("2006-01-02-15"))
+	codec, _ := s.codecs.get("lz4")
+	logs := []LogEntry{{Timestamp: hour.Format(columnTimeLayout), Message: "hello"}}
+	if _, _, err := s.appendChunk(segment, codec, logs, now); err != nil {
+		t.Fatal(err)
+	}
+
+	chunks := func(family string) int {
+		stats, ok := s.codecStats()[family].(map[string]interface{})
+		if !ok {
+			return 0
+		}
+		return stats["chunks"].(int)
+	}
+	if got := chunks("lz4"); got != 1 {
+		t.Fatalf("lz4 chunks = %d, want 1", got)
+	}
+
+	// 段没变：索引损坏也不会重新读取
+	if err := os.WriteFile(indexPath(segment), []byte("garbage\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := chunks("lz4"); got != 1 {
+		t.Fatalf("lz4 chunks with cached stats = %d, want 1", got)
+	}
+
+	// 段被追加：重新统计（索引无效，扫描段文件）
+	none, _ := s.codecs.get("none")
+	if _, _, err := s.appendChunk(segment, none, logs, now); err != nil {
+		t.Fatal(err)
+	}
+	if lz4, plain := chunks("lz4"), chunks("none"); lz4 != 1 || plain != 1 {
+		t.Fatalf("after append: lz4 %d, none %d, want 1 and 1", lz4, plain)
+	}
+
+	os.Remove(segment)
+	if stats := s.codecStats(); len(stats) != 0 {
+		t.Errorf("stats after removing the segment: %v", stats)
+	}
+	if len(s.codecTotals.segments) != 0 {
+		t.Errorf("cache still holds removed segments: %v", s.codecTotals.segments)
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	codecs := newCodecSet(t.TempDir())
+	plain := []byte(strings.Repeat("GET /api/logs 200 12ms\n", 50))
+	for _, name := range []string{"lz4", "zstd", "none", ""} {
+		codec, err := codecs.get(name)
+		if err != nil {
+			t.Fatalf("%q: %v", name, err)
+		}
+		compressed, err := codec.Compress(plain)
+		if err != nil {
+			t.Fatalf("%q: compress: %v", name, err)
+		}
+		got, err := codec.Decompress(compressed)
+		if err != nil {
+			t.Fatalf("%q: decompress: %v", name, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("%q: round trip mismatch", name)
+		}
+	}
+	if _, err := codecs.get("snappy"); err == nil {
+		t.Error("unknown codec accepted")
+	}
+	if _, err := codecs.get("zstd.deadbeef"); err == nil {
+		t.Error("missing dictionary accepted")
+	}
+}
+
+// 块分隔符里的编码名和布局；旧格式没有编码名时按 lz4 行式读取
+func TestChunkHeader(t *testing.T) {
+	created := time.Unix(1714557600, 0)
+	tests := []struct {
+		header, codec, layout string
+	}{
+		{chunkHeader(created, "zstd.1a2b3c4d", layoutColumns), "zstd.1a2b3c4d", layoutColumns},
+		{chunkHeader(created, "none", layoutRows), "none", layoutRows},
+		{"===CHUNK_1714557600_zstd===\n", "zstd", layoutRows},
+		{"===CHUNK_1714557600===\n", "lz4", layoutRows},
+	}
+	for _, tt := range tests {
+		codec, layout, body, err := parseChunkHeader([]byte(tt.header + "body"))
+		if err != nil {
+			t.Errorf("%q: %v", tt.header, err)
+			continue
+		}
+		if codec != tt.codec || layout != tt.layout || string(body) != "body" {
+			t.Errorf("%q: got %q %q %q", tt.header, codec, layout, body)
+		}
+	}
+	for _, bad := range []string{"", "===CHUNK_x_lz4===\nbody", "garbage\n", "===CHUNK_1"} {
+		if _, _, _, err := parseChunkHeader([]byte(bad)); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+// 超过 cold_after 的段重新压缩为 cold 编码（带字典），重启后用新的编码集合仍能读出
+func TestRecompressAgingSegmentWithDictionary(t *testing.T) {
+	s := newCompactTestStorage(t)
+	s.compression.ColdAfter = time.Hour
+	now := time.Now()
+	hour := now.Add(-3 * time.Hour).Truncate(time.Hour)
+	segment := segmentPath(s.dataDir, hour.Format("2006-01-02-15"))
+
+	lz4, _ := s.codecs.get("lz4")
+	var want []string
+	for chunk := 0; chunk < 4; chunk++ {
+		logs := make([]LogEntry, 0)
+		for i := 0; i < 500; i++ {
+			n := chunk*500 + i
+			msg := fmt.Sprintf("GET /api/users/%d HTTP/1.1 status=%d bytes=%d upstream=10.0.0.%d", n, 200+n%5, n*37, n%16)
+			logs = append(logs, LogEntry{Timestamp: hour.Add(time.Duration(n) * time.Second).Format(columnTimeLayout), Server: "web-01", Level: "INFO", Message: msg})
+			want = append(want, msg)
+		}
+		if _, _, err := s.appendChunk(segment, lz4, logs, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := s.Compact(now, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !strings.HasPrefix(results[0].Codec, "zstd.") {
+		t.Fatalf("aging segment not recompressed with a dictionary: %+v", results)
+	}
+	if name := s.codecs.latestDictionary(); name != results[0].Codec {
+		t.Errorf("latest dictionary %q, chunks use %q", name, results[0].Codec)
+	}
+
+	// 重启：新的编码集合从 dicts/ 加载字典
+	restarted := newCodecSet(s.dataDir)
+	data, err := os.ReadFile(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, ok := readSegmentIndex(segment, int64(len(data)))
+	if !ok {
+		t.Fatal("index invalid after compaction")
+	}
+	var got []string
+	for _, raw := range indexedChunks(data, index) {
+		if name, _, _, _ := parseChunkHeader(raw.data); name != results[0].Codec {
+			t.Errorf("chunk codec %q, want %q", name, results[0].Codec)
+		}
+		logs, err := decodeChunk(restarted, raw.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, log := range logs {
+			got = append(got, log.Message)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read %d entries after restart, want %d", len(got), len(want))
+	}
+
+	// 已经是目标编码族，不再重新压缩
+	if results, _ := s.Compact(now, false); len(results) != 0 {
+		t.Errorf("recompressed again: %+v", results)
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// 列式块：每列单独压缩，查询先解压小列（时间、级别、服务器）筛选候选行，
+// 有候选行时才解压消息和字段列
+//
+//	uvarint 行数
+//	uvarint 列数，每列：uvarint 列名长度 + 列名 + uvarint 压缩后长度
+//	各列压缩数据（使用块分隔符中的编码）
+//
+// 列编码：
+//
+//	wall     墙上时间（按 UTC 解释的秒数，与进程时区无关）与上一行的差值（zigzag varint）
+//	ts       旧版块的时间戳列：同上，但按本地时区解释（只读取，不再写入）
+//	ts_raw   无法按 "2006-01-02 15:04:05" 还原的时间戳：uvarint 条数 +（行号, 原始字符串）
+//	level / server / source   字典编码：uvarint 取值数 + 取值 + 每行 uvarint 序号
+//	message / fields          每行 uvarint 长度 + 内容（fields 为 JSON，空表示没有字段）
+const (
+	layoutRows    = "rows"
+	layoutColumns = "columns"
+)
+
+const columnTimeLayout = "2006-01-02 15:04:05"
+
+// 单个块的行数上限（防止损坏的行数溢出 int）
+const maxChunkRows = 1 << 30
+
+// 列的写入顺序（小列在前）
+var columnOrder = []string{"wall", "ts_raw", "level", "server", "source", "message", "fields"}
+
+// 把一组日志编码为各列（未压缩）
+func encodeColumns(logs []LogEntry) map[string][]byte {
+	var wall, tsRaw, message, fields []byte
+	var rawCount uint64
+	var rawRows []byte
+	levels := newDictColumn()
+	servers := newDictColumn()
+	sources := newDictColumn()
+
+	var prev int64
+	for i, log := range logs {
+		sec := prev
+		if t, err := time.ParseInLocation(columnTimeLayout, log.Timestamp, time.UTC); err == nil && t.Format(columnTimeLayout) == log.Timestamp {
+			sec = t.Unix()
+		} else {
+			rawCount++
+			rawRows = binary.AppendUvarint(rawRows, uint64(i))
+			rawRows = appendString(rawRows, log.Timestamp)
+		}
+		wall = binary.AppendVarint(wall, sec-prev)
+		prev = sec
+
+		levels.add(log.Level)
+		servers.add(log.Server)
+		sources.add(log.Source)
+		message = appendString(message, log.Message)
+		if len(log.Fields) > 0 {
+			data, _ := json.Marshal(log.Fields)
+			fields = appendString(fields, string(data))
+		} else {
+			fields = appendString(fields, "")
+		}
+	}
+	tsRaw = append(binary.AppendUvarint(tsRaw, rawCount), rawRows...)
+
+	return map[string][]byte{
+		"wall":    wall,
+		"ts_raw":  tsRaw,
+		"level":   levels.bytes(),
+		"server":  servers.bytes(),
+		"source":  sources.bytes(),
+		"message": message,
+		"fields":  fields,
+	}
+}
+
+// 压缩各列，拼成块数据（不含分隔符）
+func encodeColumnChunk(codec Codec, logs []LogEntry) ([]byte, error) {
+	columns := encodeColumns(logs)
+	compressed := make([][]byte, len(columnOrder))
+	for i, name := range columnOrder {
+		data, err := codec.Compress(columns[name])
+		if err != nil {
+			return nil, err
+		}
+		compressed[i] = data
+	}
+
+	out := binary.AppendUvarint(nil, uint64(len(logs)))
+	out = binary.AppendUvarint(out, uint64(len(columnOrder)))
+	for i, name := range columnOrder {
+		out = appendString(out, name)
+		out = binary.AppendUvarint(out, uint64(len(compressed[i])))
+	}
+	for _, data := range compressed {
+		out = append(out, data...)
+	}
+	return out, nil
+}
+
+// 列式块的读取器：列按需解压并缓存
+type columnChunk struct {
+	codec   Codec
+	rows    int
+	columns map[string][]byte // 列名 -> 压缩数据
+	decoded map[string][]byte
+}
+
+func openColumnChunk(codec Codec, body []byte) (*columnChunk, error) {
+	r := &byteReader{buf: body}
+	c := &columnChunk{codec: codec, columns: make(map[string][]byte), decoded: make(map[string][]byte)}
+	// 行数只能按解压后的列检查（每行至少占一个字节），这里先限制在 int 范围内
+	rows := r.uvarint()
+	if rows > maxChunkRows {
+		return nil, fmt.Errorf("invalid columnar chunk: %d rows", rows)
+	}
+	c.rows = int(rows)
+	count := r.count(2) // 每列至少有列名长度和压缩后长度
+	names := make([]string, count)
+	sizes := make([]int, count)
+	for i := 0; i < count; i++ {
+		names[i] = r.string()
+		sizes[i] = int(r.uvarint())
+	}
+	for i, name := range names {
+		c.columns[name] = r.next(sizes[i])
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid columnar chunk: %v", r.err)
+	}
+	return c, nil
+}
+
+func (c *columnChunk) column(name string) (*byteReader, error) {
+	if data, ok := c.decoded[name]; ok {
+		return &byteReader{buf: data}, nil
+	}
+	compressed, ok := c.columns[name]
+	if !ok {
+		return nil, fmt.Errorf("column %s missing", name)
+	}
+	data, err := c.codec.Decompress(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("column %s: %v", name, err)
+	}
+	c.decoded[name] = data
+	return &byteReader{buf: data}, nil
+}
+
+// 行数与解压后的列长度不符（每行至少一个字节）时返回错误，避免按损坏的行数分配内存
+func (c *columnChunk) checkRows(name string, r *byteReader) error {
+	if c.rows > len(r.buf)-r.pos {
+		return fmt.Errorf("invalid %s column: %d rows in %d bytes", name, c.rows, len(r.buf)-r.pos)
+	}
+	return nil
+}
+
+// 时间戳列
+func (c *columnChunk) timestamps() ([]string, error) {
+	name, zone := "wall", time.UTC
+	if _, ok := c.columns[name]; !ok {
+		name, zone = "ts", time.Local
+	}
+	r, err := c.column(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkRows(name, r); err != nil {
+		return nil, err
+	}
+	result := make([]string, c.rows)
+	var sec int64
+	for i := 0; i < c.rows; i++ {
+		sec += r.varint()
+		result[i] = time.Unix(sec, 0).In(zone).Format(columnTimeLayout)
+	}
+
+	raw, err := c.column("ts_raw")
+	if err != nil {
+		return nil, err
+	}
+	for n := raw.count(2); n > 0 && raw.err == nil; n-- {
+		row := raw.uvarint()
+		value := raw.string()
+		if row < uint64(c.rows) {
+			result[row] = value
+		}
+	}
+	if r.err != nil || raw.err != nil {
+		return nil, fmt.Errorf("invalid timestamp column")
+	}
+	return result, nil
+}
+
+// 字典编码列
+func (c *columnChunk) dictColumn(name string) ([]string, error) {
+	r, err := c.column(name)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, r.count(1))
+	for i := range values {
+		values[i] = r.string()
+	}
+	if err := c.checkRows(name, r); err != nil {
+		return nil, err
+	}
+	result := make([]string, c.rows)
+	for i := 0; i < c.rows; i++ {
+		idx := r.uvarint()
+		if idx < uint64(len(values)) {
+			result[i] = values[idx]
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid %s column", name)
+	}
+	return result, nil
+}
+
+// 逐行字符串列
+func (c *columnChunk) stringColumn(name string) ([]string, error) {
+	r, err := c.column(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkRows(name, r); err != nil {
+		return nil, err
+	}
+	result := make([]string, c.rows)
+	for i := 0; i < c.rows; i++ {
+		result[i] = r.string()
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid %s column", name)
+	}
+	return result, nil
+}
+
+// 只解压筛选用的小列，返回时间、级别、服务器组成的部分日志（消息和字段为空）
+func (c *columnChunk) headers() ([]LogEntry, error) {
+	timestamps, err := c.timestamps()
+	if err != nil {
+		return nil, err
+	}
+	levels, err := c.dictColumn("level")
+	if err != nil {
+		return nil, err
+	}
+	servers, err := c.dictColumn("server")
+	if err != nil {
+		return nil, err
+	}
+	result := make([]LogEntry, c.rows)
+	for i := range result {
+		result[i] = LogEntry{
+			Timestamp: timestamps[i],
+			Level:     levels[i],
+			NormLevel: normalizeLevel(levels[i]),
+			Server:    servers[i],
+		}
+	}
+	return result, nil
+}
+
+// 补全候选行的来源、消息和字段
+func (c *columnChunk) fill(logs []LogEntry, rows []int) error {
+	sources, err := c.dictColumn("source")
+	if err != nil {
+		return err
+	}
+	messages, err := c.stringColumn("message")
+	if err != nil {
+		return err
+	}
+	fields, err := c.stringColumn("fields")
+	if err != nil {
+		return err
+	}
+	for _, i := range rows {
+		logs[i].Source = sources[i]
+		logs[i].Message = messages[i]
+		if fields[i] != "" {
+			json.Unmarshal([]byte(fields[i]), &logs[i].Fields)
+		}
+	}
+	return nil
+}
+
+// 解压全部列
+func (c *columnChunk) entries() ([]LogEntry, error) {
+	logs, err := c.headers()
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]int, len(logs))
+	for i := range rows {
+		rows[i] = i
+	}
+	if err := c.fill(logs, rows); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// 字典编码列的写入器
+type dictColumn struct {
+	values []string
+	index  map[string]uint64
+	rows   []byte
+}
+
+func newDictColumn() *dictColumn {
+	return &dictColumn{index: make(map[string]uint64)}
+}
+
+func (d *dictColumn) add(value string) {
+	idx, ok := d.index[value]
+	if !ok {
+		idx = uint64(len(d.values))
+		d.index[value] = idx
+		d.values = append(d.values, value)
+	}
+	d.rows = binary.AppendUvarint(d.rows, idx)
+}
+
+func (d *dictColumn) bytes() []byte {
+	out := binary.AppendUvarint(nil, uint64(len(d.values)))
+	for _, value := range d.values {
+		out = appendString(out, value)
+	}
+	return append(out, d.rows...)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// 顺序读取 varint 和字符串，出错后后续读取都返回零值
+type byteReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *byteReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		r.err = fmt.Errorf("truncated varint at %d", r.pos)
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+func (r *byteReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.buf[r.pos:])
+	if n <= 0 {
+		r.err = fmt.Errorf("truncated varint at %d", r.pos)
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+func (r *byteReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.buf)-r.pos {
+		r.err = fmt.Errorf("truncated data at %d", r.pos)
+		return nil
+	}
+	data := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	return data
+}
+
+// 读取一个条目数；每个条目至少占 min 个字节，超过剩余数据时视为损坏，返回 0
+func (r *byteReader) count(min int) int {
+	v := r.uvarint()
+	if r.err != nil {
+		return 0
+	}
+	if v > uint64(len(r.buf)-r.pos)/uint64(min) {
+		r.err = fmt.Errorf("count %d exceeds remaining %d bytes at %d", v, len(r.buf)-r.pos, r.pos)
+		return 0
+	}
+	return int(v)
+}
+
+func (r *byteReader) string() string {
+	return string(r.next(int(r.uvarint())))
+}
+
+// 列式块（不含分隔符）的字节数：列头加上各列压缩后的长度；列头损坏或超出 body 时返回 false
+func columnChunkSize(body []byte) (int, bool) {
+	r := &byteReader{buf: body}
+	r.uvarint()
+	count := r.count(2)
+	total := 0
+	for i := 0; i < count; i++ {
+		r.string()
+		size := r.uvarint()
+		if size > uint64(len(body)) {
+			return 0, false
+		}
+		total += int(size)
+	}
+	if r.err != nil || count == 0 || total > len(body)-r.pos {
+		return 0, false
+	}
+	return r.pos + total, true
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestColumnChunkRoundTrip(t *testing.T) {
+	logs := []LogEntry{
+		{Timestamp: "2024-05-01 10:00:00", Level: "INFO", Server: "web-01", Source: "nginx", Message: "GET /"},
+		{Timestamp: "2024-05-01 10:00:00", Level: "error", Server: "web-01", Message: "upstream 超时", Fields: map[string]string{"status": "504", "path": "/api"}},
+		{Timestamp: "2024-05-01 09:59:58", Level: "WARN", Server: "db-01", Source: "postgres", Message: ""},
+		{Timestamp: "01/May/2024:10:00:01 +0000", Level: "INFO", Server: "web-02", Message: "raw timestamp"},
+		{Timestamp: "2024-05-01 10:00:05", Level: "", Server: "", Message: "line\nwith newline"},
+	}
+	want := make([]LogEntry, len(logs))
+	for i, log := range logs {
+		log.NormLevel = normalizeLevel(log.Level)
+		want[i] = log
+	}
+
+	codecs := newCodecSet(t.TempDir())
+	for _, name := range []string{"lz4", "zstd", "none"} {
+		codec, err := codecs.get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunk, raw, err := encodeChunk(codec, logs, time.Now())
+		if err != nil {
+			t.Fatalf("%s: encode: %v", name, err)
+		}
+		if raw != rawSize(logs) {
+			t.Errorf("%s: raw size %d, want %d", name, raw, rawSize(logs))
+		}
+
+		got, err := decodeChunk(codecs, chunk)
+		if err != nil {
+			t.Fatalf("%s: decode: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: round trip mismatch\n got %+v\nwant %+v", name, got, want)
+		}
+
+		// 只解压小列时消息和字段为空
+		columns, _, err := openChunk(codecs, chunk)
+		if err != nil || columns == nil {
+			t.Fatalf("%s: open columns: %v", name, err)
+		}
+		headers, err := columns.headers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if headers[3].Timestamp != logs[3].Timestamp || headers[1].Server != "web-01" || headers[1].Message != "" {
+			t.Errorf("%s: unexpected headers %+v", name, headers)
+		}
+	}
+}
+
+var zoneTestLogs = []LogEntry{
+	{Timestamp: "2024-03-10 02:30:00", Message: "在美国东部时间里不存在的时刻"},
+	{Timestamp: "2024-11-03 01:30:00", Message: "在美国东部时间里有两个的时刻"},
+	{Timestamp: "2024-05-01 23:59:59", Message: "跨日"},
+}
+
+// 墙上时间与进程时区无关：换了时区（重启、在别的机器上恢复备份）读出的时间不变。
+// 修改 time.Local 会和其它测试的后台协程竞争，所以在设置了 TZ 的子进程里编码和解码
+func TestColumnTimestampsIgnoreTimeZone(t *testing.T) {
+	if mode := os.Getenv("MINILOG_ZONE_TEST"); mode != "" {
+		zoneTestChild(t, mode, os.Getenv("MINILOG_ZONE_CHUNK"))
+		return
+	}
+	for _, zone := range []string{"Asia/Shanghai", "America/New_York", "Asia/Kolkata"} {
+		if _, err := time.LoadLocation(zone); err != nil {
+			t.Skipf("no tzdata: %v", err)
+		}
+	}
+
+	chunk := filepath.Join(t.TempDir(), "chunk")
+	run := func(zone, mode string) {
+		t.Helper()
+		cmd := exec.Command(os.Args[0], "-test.run=^TestColumnTimestampsIgnoreTimeZone$", "-test.count=1")
+		cmd.Env = append(os.Environ(), "TZ="+zone, "MINILOG_ZONE_TEST="+mode, "MINILOG_ZONE_CHUNK="+chunk)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("%s in %s: %v\n%s", mode, zone, err, out)
+		}
+	}
+	run("Asia/Shanghai", "encode")
+	run("America/New_York", "decode")
+	run("Asia/Kolkata", "decode")
+}
+
+func zoneTestChild(t *testing.T, mode, path string) {
+	codecs := newCodecSet(t.TempDir())
+	codec, _ := codecs.get("none")
+	if mode == "encode" {
+		chunk, _, err := encodeChunk(codec, zoneTestLogs, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, chunk, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	chunk, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeChunk(codecs, chunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range zoneTestLogs {
+		if got[i].Timestamp != zoneTestLogs[i].Timestamp {
+			t.Errorf("%s: row %d timestamp %q, want %q", time.Local, i, got[i].Timestamp, zoneTestLogs[i].Timestamp)
+		}
+	}
+}
+
+// 旧版块的 ts 列仍按本地时区读取
+func TestLegacyTimestampColumn(t *testing.T) {
+	codec, _ := newCodecSet(t.TempDir()).get("none")
+	sec := time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local).Unix()
+	columns := map[string][]byte{
+		"ts":     binary.AppendVarint(nil, sec),
+		"ts_raw": {0},
+	}
+	body := binary.AppendUvarint(nil, 1)
+	body = binary.AppendUvarint(body, uint64(len(columns)))
+	for _, name := range []string{"ts", "ts_raw"} {
+		body = appendString(body, name)
+		body = binary.AppendUvarint(body, uint64(len(columns[name])))
+	}
+	body = append(append(body, columns["ts"]...), columns["ts_raw"]...)
+
+	c, err := openColumnChunk(codec, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.timestamps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != "2024-05-01 10:00:00" {
+		t.Errorf("legacy timestamp = %q", got[0])
+	}
+}
+
+// 损坏或截断的块只返回错误，不能 panic 或按损坏的计数分配内存
+func TestCorruptColumnChunk(t *testing.T) {
+	codec, _ := newCodecSet(t.TempDir()).get("none")
+	logs := []LogEntry{
+		{Timestamp: "2024-05-01 10:00:00", Level: "INFO", Server: "web-01", Message: "GET /", Fields: map[string]string{"status": "200"}},
+		{Timestamp: "bad", Level: "ERROR", Server: "web-02", Message: "boom"},
+	}
+	body, err := encodeColumnChunk(codec, logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	huge := binary.AppendUvarint(nil, 1<<62)
+	cases := map[string][]byte{
+		"huge rows":    append(append([]byte{}, huge...), body[1:]...),
+		"huge columns": append(append([]byte{body[0]}, huge...), body[2:]...),
+	}
+	for i := 0; i < len(body); i++ {
+		cases[fmt.Sprintf("truncated at %d", i)] = body[:i]
+		flipped := append([]byte{}, body...)
+		flipped[i] ^= 0xff
+		cases[fmt.Sprintf("flipped byte %d", i)] = flipped
+	}
+
+	for name, data := range cases {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("%s: panic: %v", name, r)
+				}
+			}()
+			c, err := openColumnChunk(codec, data)
+			if err != nil {
+				return
+			}
+			c.entries()
+		}()
+	}
+	if _, err := openColumnChunk(codec, cases["huge rows"]); err == nil {
+		t.Error("huge row count accepted")
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// 后台压缩配置
+type CompactionConfig struct {
+	Interval     time.Duration `yaml:"interval"`      // 检查间隔（0 表示关闭后台压缩，仍可通过 /api/admin/compact 手动触发）
+	MinChunks    int           `yaml:"min_chunks"`    // 段中块数达到多少才压缩
+	ChunkEntries int           `yaml:"chunk_entries"` // 压缩后每块的条数
+}
+
+// 压缩结果
+type CompactResult struct {
+	Segment      string `json:"segment"`
+	Entries      int    `json:"entries"`
+	ChunksBefore int    `json:"chunks_before"`
+	ChunksAfter  int    `json:"chunks_after"`
+	BytesBefore  int64  `json:"bytes_before"`
+	BytesAfter   int64  `json:"bytes_after"`
+	Codec        string `json:"codec"`
+	Reordered    bool   `json:"reordered"` // 段中有乱序的日志
+}
+
+// 后台压缩：定期把已经结束的小时段重写为少量按时间排序的大块，并把超过 cold_after 的段转为冷数据编码；
+// 配置了远端存储时，压缩之后转存超过 offload_after 的段
+func (s *LogStorage) compactor() {
+	for {
+		cfg, _ := s.compactionConfig()
+		wait := cfg.Interval
+		if wait <= 0 {
+			wait = time.Minute // 关闭时也定期检查，热加载可以重新打开
+		}
+		time.Sleep(wait)
+		if s.replica.Load() {
+			continue // follower 的段和 leader 保持一致
+		}
+		if cfg.Interval > 0 {
+			s.Compact(time.Now(), false) // 失败的段已经逐个输出
+		}
+		if _, err := s.Offload(time.Now()); err != nil {
+			fmt.Println("⚠️  Offload failed:", err)
+		}
+	}
+}
+
+func (s *LogStorage) compactionConfig() (CompactionConfig, CompressionConfig) {
+	s.bufferMu.RLock()
+	defer s.bufferMu.RUnlock()
+	return s.compaction, s.compression
+}
+
+// 压缩已经结束的小时段（当前小时还在写入，跳过）；force 为 true 时忽略 min_chunks。
+// 某个段失败时输出错误后继续处理其它段，返回所有失败段的错误
+func (s *LogStorage) Compact(now time.Time, force bool) ([]CompactResult, error) {
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	cfg, compression := s.compactionConfig()
+	current := now.Format("2006-01-02-15")
+	results := make([]CompactResult, 0)
+	var errs []error
+	for _, path := range listSegments(s.dataDir) {
+		if segmentHour(path) >= current {
+			continue
+		}
+		hour, err := time.ParseInLocation("2006-01-02-15", segmentHour(path), time.Local)
+		if err != nil {
+			continue
+		}
+		target := compressionTarget{family: compression.codecFor(hour, now), dictionary: compression.Dictionary}
+		result, err := s.compactSegment(path, cfg, target, force)
+		if err != nil {
+			// 一个段失败（包括压缩期间被追加、下次重试）不影响其它段
+			err = fmt.Errorf("%s: %v", filepath.Base(path), err)
+			fmt.Println("⚠️  Compaction failed:", err)
+			errs = append(errs, err)
+			continue
+		}
+		if result != nil {
+			results = append(results, *result)
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// 段压缩后使用的编码
+type compressionTarget struct {
+	family     string // lz4 / zstd / none
+	dictionary bool   // zstd 使用字典
+}
+
+// 段是否需要压缩：编码与目标不同、块太多或块之间时间范围交错（乱序）
+func needsCompaction(chunks []chunkIndex, cfg CompactionConfig, target compressionTarget, force bool) bool {
+	for _, c := range chunks {
+		if codecFamily(c.Codec) != target.family {
+			return true
+		}
+	}
+	if len(chunks) <= 1 {
+		return false
+	}
+	entries := 0
+	for i, c := range chunks {
+		entries += c.Entries
+		if i > 0 && c.MinTime < chunks[i-1].MaxTime {
+			return true
+		}
+	}
+	wanted := (entries + cfg.ChunkEntries - 1) / cfg.ChunkEntries
+	if len(chunks) <= wanted {
+		return false
+	}
+	return force || len(chunks) >= cfg.MinChunks
+}
+
+// 重写一个段：解压全部块 → 按时间排序 → 按 chunk_entries 用目标编码重新分块 → 写临时文件 → 原子替换。
+// 重写期间查询和刷盘照常进行；替换前段文件被追加过（迟到的日志）则放弃，下次再压缩
+func (s *LogStorage) compactSegment(segment string, cfg CompactionConfig, target compressionTarget, force bool) (*CompactResult, error) {
+	chunks, _, _, err := segmentChunks(segment, s.codecs)
+	if err != nil {
+		return nil, err
+	}
+	if !needsCompaction(chunks, cfg, target, force) {
+		return nil, nil
+	}
+
+	// 段和索引一起读取；索引有效时按偏移切块（消息里可能出现分隔符文本），无效时才扫描
+	s.swapMu.RLock()
+	data, err := os.ReadFile(segment)
+	var current []chunkIndex
+	indexed := false
+	if err == nil {
+		current, indexed = readSegmentIndex(segment, int64(len(data)))
+	}
+	s.swapMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if !indexed {
+		current = scanSegment(data, s.codecs)
+	}
+	raws := indexedChunks(data, current)
+	rows := make([]LogEntry, 0)
+	for _, raw := range raws {
+		logs, err := decodeChunk(s.codecs, raw.data)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, logs...)
+	}
+
+	reordered := !sort.SliceIsSorted(rows, func(i, j int) bool { return rows[i].Timestamp < rows[j].Timestamp })
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].Timestamp < rows[j].Timestamp })
+
+	codec, err := s.targetCodec(target, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	// 按 chunk_entries 分块，同时生成索引
+	var output []byte
+	index := make([]chunkIndex, 0)
+	created := time.Now()
+	for start := 0; start < len(rows); start += cfg.ChunkEntries {
+		end := start + cfg.ChunkEntries
+		if end > len(rows) {
+			end = len(rows)
+		}
+		chunkLogs := rows[start:end]
+		chunk, rawSize, err := encodeChunk(codec, chunkLogs, created)
+		if err != nil {
+			return nil, err
+		}
+		index = append(index, newChunkIndex(chunkLogs, codec.Name(), int64(len(output)), int64(len(chunk)), rawSize))
+		output = append(output, chunk...)
+	}
+
+	tmp := segment + ".tmp"
+	if err := os.WriteFile(tmp, output, 0644); err != nil {
+		return nil, err
+	}
+	if err := s.swapSegment(segment, tmp, int64(len(data)), index); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	fmt.Printf("🧱 [Compact] %s: %d chunks → %d (%s) | %d B → %d B\n", filepath.Base(segment), len(raws), len(index), codec.Name(), len(data), len(output))
+	return &CompactResult{
+		Segment:      filepath.Base(segment),
+		Entries:      len(rows),
+		ChunksBefore: len(raws),
+		ChunksAfter:  len(index),
+		BytesBefore:  int64(len(data)),
+		BytesAfter:   int64(len(output)),
+		Codec:        codec.Name(),
+		Reordered:    reordered,
+	}, nil
+}
+
+// 目标编码；zstd 字典用本段的消息作为训练样本（消息列最大，已有字典时直接复用）
+func (s *LogStorage) targetCodec(target compressionTarget, rows []LogEntry) (Codec, error) {
+	if target.family != "zstd" || !target.dictionary {
+		return s.codecs.get(target.family)
+	}
+	samples := make([]string, len(rows))
+	for i, row := range rows {
+		samples[i] = row.Message
+	}
+	return s.codecs.dictionaryCodec(samples)
+}
+
+// 替换段文件和索引：持有 flushMu 防止并发追加，持有 swapMu 让查询看到一致的段和索引
+func (s *LogStorage) swapSegment(segment, tmp string, expectedSize int64, index []chunkIndex) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	info, err := os.Stat(segment)
+	if err != nil {
+		return err
+	}
+	if info.Size() != expectedSize {
+		return fmt.Errorf("segment changed during compaction, will retry")
+	}
+
+	s.swapMu.Lock()
+	defer s.swapMu.Unlock()
+	if err := os.Rename(tmp, segment); err != nil {
+		return err
+	}
+	// 索引写入失败时段文件已经替换，查询会退回扫描，下次启动重建
+	return writeSegmentIndex(segment, index)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestNeedsCompaction(t *testing.T) {
+	cfg := CompactionConfig{MinChunks: 4, ChunkEntries: 100}
+	lz4 := compressionTarget{family: "lz4"}
+	chunk := func(codec string, entries int, min, max string) chunkIndex {
+		return chunkIndex{Codec: codec, Entries: entries, MinTime: min, MaxTime: max}
+	}
+	cases := []struct {
+		name   string
+		chunks []chunkIndex
+		target compressionTarget
+		force  bool
+		want   bool
+	}{
+		{"single chunk", []chunkIndex{chunk("lz4", 10, "10:00", "10:05")}, lz4, true, false},
+		{"codec differs", []chunkIndex{chunk("lz4", 10, "10:00", "10:05")}, compressionTarget{family: "zstd"}, false, true},
+		{"ordered, below min_chunks", []chunkIndex{chunk("lz4", 10, "10:00", "10:05"), chunk("lz4", 10, "10:06", "10:10")}, lz4, false, false},
+		{"ordered, forced", []chunkIndex{chunk("lz4", 10, "10:00", "10:05"), chunk("lz4", 10, "10:06", "10:10")}, lz4, true, true},
+		{"overlapping time ranges", []chunkIndex{chunk("lz4", 10, "10:00", "10:30"), chunk("lz4", 10, "10:10", "10:20")}, lz4, false, true},
+		{"already minimal", []chunkIndex{chunk("lz4", 100, "10:00", "10:05"), chunk("lz4", 100, "10:06", "10:10")}, lz4, true, false},
+		{"many small chunks", []chunkIndex{
+			chunk("lz4", 1, "10:00", "10:01"), chunk("lz4", 1, "10:02", "10:03"),
+			chunk("lz4", 1, "10:04", "10:05"), chunk("lz4", 1, "10:06", "10:07"),
+		}, lz4, false, true},
+	}
+	for _, c := range cases {
+		if got := needsCompaction(c.chunks, cfg, c.target, c.force); got != c.want {
+			t.Errorf("%s: needsCompaction = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func newCompactTestStorage(t *testing.T) *LogStorage {
+	dir := t.TempDir()
+	cfg := defaultConfig().Storage
+	cfg.Compression.ColdAfter = 0
+	return &LogStorage{dataDir: dir, codecs: newCodecSet(dir), compaction: cfg.Compaction, compression: cfg.Compression}
+}
+
+func TestCompactSegmentOrdersEntries(t *testing.T) {
+	s := newCompactTestStorage(t)
+	now := time.Now()
+	hour := now.Add(-2 * time.Hour).Truncate(time.Hour)
+	at := func(minute int) string { return hour.Add(time.Duration(minute) * time.Minute).Format(columnTimeLayout) }
+
+	// 第二个块是迟到的日志，时间落在第一个块的范围内
+	codec, _ := s.codecs.get("lz4")
+	segment := segmentPath(s.dataDir, hour.Format("2006-01-02-15"))
+	for _, logs := range [][]LogEntry{
+		{{Timestamp: at(1), Message: "a"}, {Timestamp: at(30), Message: "d"}},
+		{{Timestamp: at(10), Message: "b"}, {Timestamp: at(20), Message: "c"}},
+	} {
+		if _, _, err := s.appendChunk(segment, codec, logs, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := s.Compact(now, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !results[0].Reordered || results[0].ChunksAfter != 1 {
+		t.Fatalf("unexpected compaction results: %+v", results)
+	}
+
+	data, err := os.ReadFile(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, raw := range splitChunks(data) {
+		logs, err := decodeChunk(s.codecs, raw.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, log := range logs {
+			messages = append(messages, log.Message)
+		}
+	}
+	if !sort.StringsAreSorted(messages) || len(messages) != 4 {
+		t.Errorf("entries after compaction: %v, want a b c d", messages)
+	}
+
+	// 已经有序的段不会再次压缩
+	if results, _ := s.Compact(now, true); len(results) != 0 {
+		t.Errorf("compacted an ordered segment again: %+v", results)
+	}
+}
+
+func TestCompactContinuesAfterSegmentError(t *testing.T) {
+	s := newCompactTestStorage(t)
+	now := time.Now()
+	broken := now.Add(-3 * time.Hour).Truncate(time.Hour)
+	good := now.Add(-2 * time.Hour).Truncate(time.Hour)
+
+	// 较早的段里有一个无法解码的块（未知编码）
+	bogus := append([]byte(chunkHeader(now, "bogus", layoutColumns)), "garbage"...)
+	if err := os.WriteFile(segmentPath(s.dataDir, broken.Format("2006-01-02-15")), bogus, 0644); err != nil {
+		t.Fatal(err)
+	}
+	codec, _ := s.codecs.get("lz4")
+	segment := segmentPath(s.dataDir, good.Format("2006-01-02-15"))
+	for _, minute := range []int{30, 10} {
+		logs := []LogEntry{{Timestamp: good.Add(time.Duration(minute) * time.Minute).Format(columnTimeLayout), Message: "x"}}
+		if _, _, err := s.appendChunk(segment, codec, logs, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := s.Compact(now, true)
+	if err == nil {
+		t.Error("expected an error for the broken segment")
+	}
+	if len(results) != 1 || results[0].Segment != filepath.Base(segment) {
+		t.Errorf("later segment was not compacted: %+v", results)
+	}
+}
+
+// 消息里出现分隔符文本（编码为 none 时原样落盘）不能把块切错
+func TestCompactMessageContainingChunkMarker(t *testing.T) {
+	s := newCompactTestStorage(t)
+	now := time.Now()
+	hour := now.Add(-2 * time.Hour).Truncate(time.Hour)
+	at := func(minute int) string { return hour.Add(time.Duration(minute) * time.Minute).Format(columnTimeLayout) }
+
+	codec, _ := s.codecs.get("none")
+	segment := segmentPath(s.dataDir, hour.Format("2006-01-02-15"))
+	fake := chunkHeader(now, "none", layoutColumns) + "not a chunk"
+	for _, logs := range [][]LogEntry{
+		{{Timestamp: at(20), Message: "b " + fake}},
+		{{Timestamp: at(10), Message: "a " + fake}},
+	} {
+		if _, _, err := s.appendChunk(segment, codec, logs, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 没有索引时按列头算出的长度切分
+	data, err := os.ReadFile(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunks := scanSegment(data, s.codecs); len(chunks) != 2 || chunks[0].Entries != 1 || chunks[1].Entries != 1 {
+		t.Fatalf("scanSegment split the segment wrong: %+v", chunks)
+	}
+
+	results, err := s.Compact(now, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Entries != 2 || results[0].ChunksBefore != 2 {
+		t.Fatalf("unexpected compaction results: %+v", results)
+	}
+	data, err = os.ReadFile(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, ok := readSegmentIndex(segment, int64(len(data)))
+	if !ok || len(index) != 1 {
+		t.Fatalf("index after compaction: %+v (valid %v)", index, ok)
+	}
+	logs, err := decodeChunk(s.codecs, indexedChunks(data, index)[0].data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 || logs[0].Message != "a "+fake || logs[1].Message != "b "+fake {
+		t.Errorf("entries after compaction: %+v", logs)
+	}
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 服务器配置：默认值 < 配置文件（YAML）< MINILOG_* 环境变量 < 命令行参数
+type Config struct {
+	DataDir     string            `yaml:"data_dir"`
+	Addr        string            `yaml:"addr"`
+	Storage     StorageConfig     `yaml:"storage"`
+	Metrics     MetricsConfig     `yaml:"metrics"`
+	TLS         TLSConfig         `yaml:"tls"`
+	Ingest      IngestSettings    `yaml:"ingest"`
+	Health      HealthConfig      `yaml:"health"`
+	Replication ReplicationConfig `yaml:"replication"` // 主从复制（只在启动时读取）
+	Cluster     ClusterConfig     `yaml:"cluster"`     // 集群分片（只在启动时读取）
+}
+
+// 日志存储配置（NewLogStorage 使用）
+type StorageConfig struct {
+	BufferSize    int               `yaml:"buffer_size"`    // 攒够多少条就压缩
+	BufferMemory  ByteSize          `yaml:"buffer_memory"`  // 或者超过多少内存就压缩
+	FlushInterval time.Duration     `yaml:"flush_interval"` // 或者超过多久就压缩
+	Retention     time.Duration     `yaml:"retention"`      // 磁盘日志保留时长（0 表示永久保留）
+	Compaction    CompactionConfig  `yaml:"compaction"`
+	Compression   CompressionConfig `yaml:"compression"`
+	Remote        RemoteConfig      `yaml:"remote"` // 远端段存储（冷层，只在启动时读取）
+	Tiers         TierConfig        `yaml:"tiers"`  // 温层和冷层缓存（只在启动时读取）
+	Dedup         DedupConfig       `yaml:"dedup"`  // 重试日志去重
+	Late          LateConfig        `yaml:"late"`   // 迟到和超前日志的时间范围
+}
+
+// 监控存储配置（NewMetricsStorage 使用）
+type MetricsConfig struct {
+	MaxPoints        int           `yaml:"max_points"`        // 每台服务器保留的数据点
+	OfflineThreshold time.Duration `yaml:"offline_threshold"` // 多久未推送视为离线
+}
+
+// 写入链路配置（配置文件中的 ingest 段）
+type IngestSettings struct {
+	Multiline       []MultilineRule  `yaml:"multiline"`
+	Pipelines       []PipelineConfig `yaml:"pipelines"`
+	Rules           []IngestRule     `yaml:"rules"`
+	Redaction       []RedactionRule  `yaml:"redaction"`
+	RedactionMode   string           `yaml:"redaction_mode"`
+	RedactionKey    string           `yaml:"redaction_key,omitempty"` // 为空时使用 MINILOG_REDACT_KEY 或 data/redact.key
+	SummaryInterval time.Duration    `yaml:"summary_interval"`
+}
+
+// 和原来硬编码的值保持一致
+func defaultConfig() *Config {
+	return &Config{
+		DataDir: "data",
+		Addr:    ":8080",
+		Storage: StorageConfig{
+			BufferSize:    1000,
+			BufferMemory:  10 * 1024 * 1024,
+			FlushInterval: 60 * time.Second,
+			Compaction: CompactionConfig{
+				Interval:     10 * time.Minute,
+				MinChunks:    4,
+				ChunkEntries: 10000,
+			},
+			Compression: CompressionConfig{
+				Hot:        "lz4",
+				Cold:       "zstd",
+				ColdAfter:  24 * time.Hour,
+				Dictionary: true,
+			},
+			Dedup: DedupConfig{
+				Window:  10 * time.Minute,
+				MaxKeys: 100000,
+			},
+			Tiers: TierConfig{
+				WarmAfter: 24 * time.Hour,
+				CacheSize: 256 * 1024 * 1024,
+			},
+		},
+		Metrics: MetricsConfig{
+			MaxPoints:        120, // 1小时（30秒间隔）
+			OfflineThreshold: 90 * time.Second,
+		},
+		TLS: TLSConfig{ClientAuth: "optional"},
+		Ingest: IngestSettings{
+			Pipelines:       defaultPipelines(),
+			Redaction:       defaultRedactionRules(),
+			RedactionMode:   "mask",
+			SummaryInterval: 60 * time.Second,
+		},
+		Health: HealthConfig{
+			MaxDiskUsedPercent: 95,
+			MaxBufferFactor:    2,
+			FlushStuckAfter:    5 * time.Minute,
+		},
+		Replication: ReplicationConfig{Interval: 30 * time.Second},
+		Cluster:     ClusterConfig{ShardBy: shardByServer, Timeout: 5 * time.Second},
+	}
+}
+
+// 字节数：配置中可以写 10MB / 512KB / 1GB 或纯数字
+type ByteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1},
+}
+
+func parseByteSize(s string) (ByteSize, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			n, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(s, unit.suffix)), 64)
+			if err != nil {
+				return 0, fmt.Errorf("无效的大小: %q", s)
+			}
+			return ByteSize(n * float64(unit.size)), nil
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("无效的大小: %q", s)
+	}
+	return ByteSize(n), nil
+}
+
+func (b ByteSize) String() string {
+	for _, unit := range byteUnits {
+		if int64(b) >= unit.size && int64(b)%unit.size == 0 {
+			return fmt.Sprintf("%d%s", int64(b)/unit.size, unit.suffix)
+		}
+	}
+	return strconv.FormatInt(int64(b), 10)
+}
+
+func (b *ByteSize) UnmarshalYAML(node *yaml.Node) error {
+	size, err := parseByteSize(node.Value)
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
+
+func (b ByteSize) MarshalYAML() (interface{}, error) {
+	return b.String(), nil
+}
+
+// 可以通过环境变量和命令行参数覆盖的配置项
+type configSetting struct {
+	name  string // 命令行参数名；环境变量为 MINILOG_ + 大写下划线形式
+	usage string
+	apply func(c *Config, value string) error
+}
+
+func (s configSetting) env() string {
+	return "MINILOG_" + strings.ToUpper(strings.ReplaceAll(s.name, "-", "_"))
+}
+
+var configSettings = []configSetting{
+	{"data-dir", "数据目录", func(c *Config, v string) error { c.DataDir = v; return nil }},
+	{"addr", "监听地址", func(c *Config, v string) error { c.Addr = v; return nil }},
+	{"buffer-size", "内存缓冲条数（达到后压缩）", func(c *Config, v string) error {
+		return parseIntSetting(v, &c.Storage.BufferSize)
+	}},
+	{"buffer-memory", "内存缓冲大小（如 10MB）", func(c *Config, v string) error {
+		size, err := parseByteSize(v)
+		c.Storage.BufferMemory = size
+		return err
+	}},
+	{"flush-interval", "刷盘间隔（如 60s）", func(c *Config, v string) error {
+		return parseDurationSetting(v, &c.Storage.FlushInterval)
+	}},
+	{"retention", "磁盘日志保留时长（如 720h，0 表示永久保留）", func(c *Config, v string) error {
+		return parseDurationSetting(v, &c.Storage.Retention)
+	}},
+	{"metrics-points", "每台服务器保留的监控数据点", func(c *Config, v string) error {
+		return parseIntSetting(v, &c.Metrics.MaxPoints)
+	}},
+	{"offline-threshold", "多久未推送视为离线（如 90s）", func(c *Config, v string) error {
+		return parseDurationSetting(v, &c.Metrics.OfflineThreshold)
+	}},
+	{"tls-cert", "TLS 证书文件（启用 HTTPS）", func(c *Config, v string) error { c.TLS.CertFile = v; return nil }},
+	{"tls-key", "TLS 私钥文件", func(c *Config, v string) error { c.TLS.KeyFile = v; return nil }},
+	{"tls-client-ca", "客户端证书 CA（启用 mTLS，证书 CN/SAN 作为可信服务器名）", func(c *Config, v string) error { c.TLS.ClientCA = v; return nil }},
+	{"tls-client-auth", "客户端证书要求：optional / require", func(c *Config, v string) error { c.TLS.ClientAuth = v; return nil }},
+	{"s3-access-key", "远端 S3 存储的 Access Key", func(c *Config, v string) error { c.Storage.Remote.AccessKey = v; return nil }},
+	{"s3-secret-key", "远端 S3 存储的 Secret Key", func(c *Config, v string) error { c.Storage.Remote.SecretKey = v; return nil }},
+	{"replication-leader", "作为 follower 复制的 leader 地址（如 http://10.0.0.1:8080）", func(c *Config, v string) error { c.Replication.Leader = v; return nil }},
+	{"replication-key", "leader 上的管理员 API key", func(c *Config, v string) error { c.Replication.Key = v; return nil }},
+	{"cluster-key", "集群节点之间使用的管理员 API key", func(c *Config, v string) error { c.Cluster.Key = v; return nil }},
+}
+
+func parseIntSetting(v string, out *int) error {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("需要整数，得到 %q", v)
+	}
+	*out = n
+	return nil
+}
+
+func parseDurationSetting(v string, out *time.Duration) error {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("需要时长（如 30s），得到 %q", v)
+	}
+	*out = d
+	return nil
+}
+
+// 注册配置相关的命令行参数；返回的函数在 flag.Parse 之后加载完整配置
+func registerConfigFlags(fs *flag.FlagSet) func() (*Config, string, error) {
+	configPath := fs.String("config", "", "配置文件（YAML），也可以用 MINILOG_CONFIG 指定")
+	values := make(map[string]string)
+	for _, s := range configSettings {
+		name := s.name
+		fs.Func(name, s.usage+"（环境变量 "+s.env()+"）", func(v string) error {
+			values[name] = v
+			return nil
+		})
+	}
+
+	return func() (*Config, string, error) {
+		path := *configPath
+		if path == "" {
+			path = os.Getenv("MINILOG_CONFIG")
+		}
+		cfg, err := loadConfig(path, os.Getenv, values)
+		return cfg, path, err
+	}
+}
+
+// 按优先级合并配置并校验
+func loadConfig(path string, getenv func(string) string, flags map[string]string) (*Config, error) {
+	cfg := defaultConfig()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true) // 拼错的配置项直接报错
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	for _, s := range configSettings {
+		if v := getenv(s.env()); v != "" {
+			if err := s.apply(cfg, v); err != nil {
+				return nil, fmt.Errorf("%s: %w", s.env(), err)
+			}
+		}
+	}
+	for _, s := range configSettings {
+		if v, ok := flags[s.name]; ok {
+			if err := s.apply(cfg, v); err != nil {
+				return nil, fmt.Errorf("-%s: %w", s.name, err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// 校验配置，一次报告所有问题
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.DataDir != "", "data_dir 不能为空")
+	check(c.Addr != "", "addr 不能为空")
+	check(c.Storage.BufferSize > 0, "storage.buffer_size 必须 > 0")
+	check(c.Storage.BufferMemory > 0, "storage.buffer_memory 必须 > 0")
+	check(c.Storage.FlushInterval >= time.Second, "storage.flush_interval 至少 1s")
+	check(c.Storage.Retention == 0 || c.Storage.Retention >= time.Hour, "storage.retention 至少 1h（日志按小时分片），0 表示永久保留")
+	check(c.Storage.Compaction.Interval == 0 || c.Storage.Compaction.Interval >= time.Minute, "storage.compaction.interval 至少 1m，0 表示关闭后台压缩")
+	check(c.Storage.Compaction.MinChunks >= 2, "storage.compaction.min_chunks 至少为 2")
+	check(c.Storage.Compaction.ChunkEntries > 0, "storage.compaction.chunk_entries 必须 > 0")
+	check(containsString(codecNames, c.Storage.Compression.Hot), "storage.compression.hot 只能是 "+strings.Join(codecNames, " / "))
+	check(containsString(codecNames, c.Storage.Compression.Cold), "storage.compression.cold 只能是 "+strings.Join(codecNames, " / "))
+	check(c.Storage.Compression.ColdAfter >= 0, "storage.compression.cold_after 不能为负数")
+	remote := c.Storage.Remote
+	check(containsString(remoteTypes, remote.Type), "storage.remote.type 只能是 local / s3 / memory，得到 %q", remote.Type)
+	check(remote.Type != "local" || remote.Dir != "", "storage.remote.dir 不能为空（type: local）")
+	check(remote.Type != "s3" || (remote.Endpoint != "" && remote.Bucket != ""), "storage.remote.endpoint 和 bucket 不能为空（type: s3）")
+	check(remote.Type != "s3" || (remote.AccessKey != "" && remote.SecretKey != ""), "storage.remote 需要 access_key 和 secret_key（或 MINILOG_S3_ACCESS_KEY / MINILOG_S3_SECRET_KEY）")
+	check(remote.OffloadAfter >= 0, "storage.remote.offload_after 不能为负数")
+	tiers := c.Storage.Tiers
+	check(tiers.WarmAfter >= 0, "storage.tiers.warm_after 不能为负数")
+	check(tiers.WarmDir == "" || remote.Type == "" || remote.OffloadAfter > tiers.WarmAfter, "storage.remote.offload_after 必须大于 storage.tiers.warm_after")
+	check(tiers.CacheSize >= 0, "storage.tiers.cache_size 不能为负数")
+	check(c.Storage.Dedup.Window >= 0, "storage.dedup.window 不能为负数，0 表示关闭去重")
+	check(c.Storage.Dedup.Window == 0 || c.Storage.Dedup.MaxKeys > 0, "storage.dedup.max_keys 必须 > 0")
+	check(c.Storage.Late.MaxPast >= 0 && c.Storage.Late.MaxFuture >= 0, "storage.late.max_past 和 max_future 不能为负数，0 表示不限制")
+	check(c.Metrics.MaxPoints > 0, "metrics.max_points 必须 > 0")
+	check(c.Metrics.OfflineThreshold > 0, "metrics.offline_threshold 必须 > 0")
+	check(c.Health.MaxDiskUsedPercent > 0 && c.Health.MaxDiskUsedPercent <= 100, "health.max_disk_used_percent 需要在 (0, 100] 之间")
+	check(c.Health.MaxBufferFactor >= 1, "health.max_buffer_factor 至少为 1")
+	check(c.Health.FlushStuckAfter > 0, "health.flush_stuck_after 必须 > 0")
+	if leader := c.Replication.Leader; leader != "" {
+		u, err := url.Parse(leader)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "replication.leader 需要 http(s)://host:port 形式的地址，得到 %q", leader)
+		check(c.Replication.Key != "", "replication.key 不能为空（或 MINILOG_REPLICATION_KEY）")
+		check(c.Replication.Interval >= time.Second, "replication.interval 至少 1s")
+	}
+	if cluster := c.Cluster; len(cluster.Nodes) > 0 {
+		names := make(map[string]bool)
+		for _, node := range cluster.Nodes {
+			u, err := url.Parse(node.URL)
+			check(node.Name != "" && !names[node.Name], "cluster.nodes 的 name 不能为空且不能重复，得到 %q", node.Name)
+			check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "cluster.nodes[%s].url 需要 http(s)://host:port 形式的地址，得到 %q", node.Name, node.URL)
+			names[node.Name] = true
+		}
+		check(names[cluster.Self], "cluster.self 必须是 cluster.nodes 中的一个节点，得到 %q", cluster.Self)
+		check(cluster.ShardBy == shardByServer || cluster.ShardBy == shardByTenant, "cluster.shard_by 只能是 server / tenant，得到 %q", cluster.ShardBy)
+		check(cluster.Key != "", "cluster.key 不能为空（或 MINILOG_CLUSTER_KEY）")
+		check(cluster.Timeout > 0, "cluster.timeout 必须 > 0")
+		check(c.Replication.Leader == "", "replication.leader 和 cluster 不能同时配置")
+	}
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls.cert_file 和 tls.key_file 需要同时指定")
+	check(c.TLS.ClientAuth == "" || c.TLS.ClientAuth == "optional" || c.TLS.ClientAuth == "require",
+		"tls.client_auth 只支持 optional / require，得到 %q", c.TLS.ClientAuth)
+
+	// 写入链路：编译一遍规则，提前发现错误的正则和动作
+	if _, err := NewPipelineSet(c.Ingest.Pipelines); err != nil {
+		problems = append(problems, "ingest.pipelines: "+err.Error())
+	}
+	if _, err := NewRuleSet(c.Ingest.Rules); err != nil {
+		problems = append(problems, "ingest.rules: "+err.Error())
+	}
+	// 密钥在启动后才加载，这里用占位密钥只检查规则本身
+	if _, err := NewRedactor(c.Ingest.Redaction, c.Ingest.RedactionMode, []byte("validate")); err != nil {
+		problems = append(problems, "ingest.redaction: "+err.Error())
+	}
+	for i := range c.Ingest.Multiline {
+		rule := c.Ingest.Multiline[i]
+		if err := rule.compile(); err != nil {
+			problems = append(problems, "ingest.multiline: "+err.Error())
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("配置错误:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+// 写入链路配置（redactKey 为启动时加载的脱敏密钥）
+func (c *Config) IngestConfig(redactKey []byte) IngestConfig {
+	if c.Ingest.RedactionKey != "" {
+		redactKey = []byte(c.Ingest.RedactionKey)
+	}
+	return IngestConfig{
+		Multiline:       c.Ingest.Multiline,
+		Pipelines:       c.Ingest.Pipelines,
+		Rules:           c.Ingest.Rules,
+		Redaction:       c.Ingest.Redaction,
+		RedactionMode:   c.Ingest.RedactionMode,
+		RedactionKey:    redactKey,
+		SummaryInterval: c.Ingest.SummaryInterval,
+	}
+}
+
+// 隐藏密钥后的配置（用于 /api/config）
+func (c *Config) Redacted() *Config {
+	copied := *c
+	if copied.Ingest.RedactionKey != "" {
+		copied.Ingest.RedactionKey = "[REDACTED]"
+	}
+	if copied.Storage.Remote.AccessKey != "" {
+		copied.Storage.Remote.AccessKey = "[REDACTED]"
+	}
+	if copied.Storage.Remote.SecretKey != "" {
+		copied.Storage.Remote.SecretKey = "[REDACTED]"
+	}
+	if copied.Replication.Key != "" {
+		copied.Replication.Key = "[REDACTED]"
+	}
+	if copied.Cluster.Key != "" {
+		copied.Cluster.Key = "[REDACTED]"
+	}
+	return &copied
+}
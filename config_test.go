@@ -0,0 +1,215 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "minilog.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// 默认值 < 配置文件 < MINILOG_* 环境变量 < 命令行参数
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `
+data_dir: /from/file
+addr: ":9000"
+storage:
+  buffer_size: 500
+  buffer_memory: 4MB
+  flush_interval: 30s
+metrics:
+  max_points: 60
+`)
+	env := map[string]string{
+		"MINILOG_ADDR":          ":9100",
+		"MINILOG_BUFFER_SIZE":   "700",
+		"MINILOG_BUFFER_MEMORY": "8MB",
+	}
+	flags := map[string]string{
+		"buffer-size":       "900",
+		"offline-threshold": "2m",
+	}
+
+	cfg, err := loadConfig(path, func(k string) string { return env[k] }, flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"data_dir from file", cfg.DataDir, "/from/file"},
+		{"addr from env", cfg.Addr, ":9100"},
+		{"buffer_size from flag", cfg.Storage.BufferSize, 900},
+		{"buffer_memory from env", cfg.Storage.BufferMemory, ByteSize(8 << 20)},
+		{"flush_interval from file", cfg.Storage.FlushInterval, 30 * time.Second},
+		{"max_points from file", cfg.Metrics.MaxPoints, 60},
+		{"offline_threshold from flag", cfg.Metrics.OfflineThreshold, 2 * time.Minute},
+		{"retention default", cfg.Storage.Retention, time.Duration(0)},
+		{"compaction default", cfg.Storage.Compaction.ChunkEntries, 10000},
+	}
+	for _, c := range cases {
+		if c.got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, c.got, c.want)
+		}
+	}
+
+	// 没有配置文件时使用默认值
+	cfg, err = loadConfig("", func(string) string { return "" }, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DataDir != "data" || cfg.Addr != ":8080" || cfg.Storage.BufferSize != 1000 {
+		t.Errorf("defaults: %+v", cfg)
+	}
+}
+
+// 命令行参数通过 FlagSet 注册，MINILOG_CONFIG 指定配置文件
+func TestRegisterConfigFlags(t *testing.T) {
+	path := writeConfigFile(t, "addr: \":9000\"\n")
+	t.Setenv("MINILOG_CONFIG", path)
+	t.Setenv("MINILOG_DATA_DIR", "/from/env")
+
+	fs := flag.NewFlagSet("minilog", flag.ContinueOnError)
+	load := registerConfigFlags(fs)
+	if err := fs.Parse([]string{"-data-dir", "/from/flag", "-retention", "48h"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, used, err := load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used != path || cfg.Addr != ":9000" || cfg.DataDir != "/from/flag" || cfg.Storage.Retention != 48*time.Hour {
+		t.Errorf("config %q: addr %q data_dir %q retention %v", used, cfg.Addr, cfg.DataDir, cfg.Storage.Retention)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	none := func(string) string { return "" }
+	cases := []struct {
+		name  string
+		file  string
+		env   map[string]string
+		flags map[string]string
+		want  string
+	}{
+		{name: "unknown key", file: "storage:\n  bufer_size: 10\n", want: "bufer_size"},
+		{name: "bad byte size", file: "storage:\n  buffer_memory: lots\n", want: "无效的大小"},
+		{name: "bad duration", file: "storage:\n  flush_interval: soon\n", want: "soon"},
+		{name: "env not an int", env: map[string]string{"MINILOG_BUFFER_SIZE": "many"}, want: "MINILOG_BUFFER_SIZE"},
+		{name: "flag not a duration", flags: map[string]string{"flush-interval": "10"}, want: "-flush-interval"},
+		{name: "flag bad byte size", flags: map[string]string{"buffer-memory": "1XB"}, want: "-buffer-memory"},
+		{name: "invalid after merge", flags: map[string]string{"buffer-size": "0"}, want: "storage.buffer_size"},
+	}
+	for _, c := range cases {
+		path := ""
+		if c.file != "" {
+			path = writeConfigFile(t, c.file)
+		}
+		getenv := none
+		if c.env != nil {
+			env := c.env
+			getenv = func(k string) string { return env[k] }
+		}
+		_, err := loadConfig(path, getenv, c.flags)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: error %v, want it to mention %q", c.name, err, c.want)
+		}
+	}
+	if _, err := loadConfig(filepath.Join(t.TempDir(), "missing.yaml"), none, nil); err == nil {
+		t.Error("missing config file accepted")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(c *Config)
+		want   string // 空表示有效
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"dedup max_keys", func(c *Config) { c.Storage.Dedup.MaxKeys = 0 }, "storage.dedup.max_keys"},
+		{"dedup disabled ignores max_keys", func(c *Config) { c.Storage.Dedup.Window = 0; c.Storage.Dedup.MaxKeys = 0 }, ""},
+		{"negative dedup window", func(c *Config) { c.Storage.Dedup.Window = -time.Second }, "storage.dedup.window"},
+		{"buffer_memory", func(c *Config) { c.Storage.BufferMemory = 0 }, "storage.buffer_memory"},
+		{"cache_size", func(c *Config) { c.Storage.Tiers.CacheSize = -1 }, "storage.tiers.cache_size"},
+		{"flush_interval", func(c *Config) { c.Storage.FlushInterval = 500 * time.Millisecond }, "storage.flush_interval"},
+		{"retention", func(c *Config) { c.Storage.Retention = 30 * time.Minute }, "storage.retention"},
+		{"compaction interval", func(c *Config) { c.Storage.Compaction.Interval = time.Second }, "storage.compaction.interval"},
+		{"compaction off", func(c *Config) { c.Storage.Compaction.Interval = 0 }, ""},
+		{"late", func(c *Config) { c.Storage.Late.MaxFuture = -time.Minute }, "storage.late"},
+		{"offline_threshold", func(c *Config) { c.Metrics.OfflineThreshold = 0 }, "metrics.offline_threshold"},
+		{"codec", func(c *Config) { c.Storage.Compression.Cold = "gzip" }, "storage.compression.cold"},
+		{"remote s3 keys", func(c *Config) {
+			c.Storage.Remote = RemoteConfig{Type: "s3", Endpoint: "http://minio:9000", Bucket: "logs"}
+		}, "access_key"},
+		{"replication", func(c *Config) { c.Replication.Leader = "10.0.0.1:8080" }, "replication.leader"},
+		{"tls pair", func(c *Config) { c.TLS.CertFile = "cert.pem" }, "tls.cert_file"},
+		{"pipeline", func(c *Config) {
+			c.Ingest.Pipelines = []PipelineConfig{{Processors: []ProcessorConfig{{Type: "regex", Pattern: "("}}}}
+		}, "ingest.pipelines"},
+		{"rules", func(c *Config) { c.Ingest.Rules = []IngestRule{{Action: "limit"}} }, "ingest.rules"},
+	}
+	for _, c := range cases {
+		cfg := defaultConfig()
+		c.modify(cfg)
+		err := cfg.Validate()
+		if c.want == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", c.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: error %v, want it to mention %q", c.name, err, c.want)
+		}
+	}
+
+	// 一次报告所有问题
+	cfg := defaultConfig()
+	cfg.DataDir = ""
+	cfg.Metrics.MaxPoints = 0
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "data_dir") || !strings.Contains(err.Error(), "metrics.max_points") {
+		t.Errorf("Validate reported %v", err)
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	cases := []struct {
+		in   string
+		want ByteSize
+		text string
+	}{
+		{"10MB", 10 << 20, "10MB"},
+		{"512kb", 512 << 10, "512KB"},
+		{"1.5GB", 3 << 29, "1536MB"},
+		{"2048", 2 << 10, "2KB"},
+		{"100B", 100, "100B"},
+		{" 1 GB ", 1 << 30, "1GB"},
+	}
+	for _, c := range cases {
+		got, err := parseByteSize(c.in)
+		if err != nil || got != c.want {
+			t.Errorf("parseByteSize(%q) = %d, %v, want %d", c.in, got, err, c.want)
+		}
+		if got.String() != c.text {
+			t.Errorf("ByteSize(%d).String() = %q, want %q", got, got.String(), c.text)
+		}
+	}
+	for _, bad := range []string{"", "MB", "ten", "1.5"} {
+		if _, err := parseByteSize(bad); err == nil {
+			t.Errorf("parseByteSize(%q) accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/hex"
+	"hash/fnv"
+	"time"
+)
+
+// 写入去重：Agent 和其它采集端失败后会重试，同一条日志可能写入两次。
+// 带幂等键（日志的 id 字段或请求头 Idempotency-Key）的日志在时间窗口内只保存一次；
+// 开启 content 后没有幂等键的日志按 Timestamp/Server/Message 的哈希去重。
+// 重复的日志照常返回成功，只是不保存。
+type DedupConfig struct {
+	Window  time.Duration `yaml:"window"`   // 去重窗口（0 表示关闭）
+	Content bool          `yaml:"content"`  // 没有幂等键时按内容哈希去重（同一秒内内容相同的日志只保留一条）
+	MaxKeys int           `yaml:"max_keys"` // 窗口内最多记住的键，超出时最早的先过期
+}
+
+// 窗口内见过的键（由 LogStorage.bufferMu 保护）
+type dedupSet struct {
+	cfg   DedupConfig
+	seen  map[string]time.Time
+	order []dedupKey // 按记录时间排序，用于过期
+}
+
+type dedupKey struct {
+	key string
+	at  time.Time
+}
+
+func newDedupSet(cfg DedupConfig) *dedupSet {
+	return &dedupSet{cfg: cfg, seen: make(map[string]time.Time)}
+}
+
+// 日志的去重键；不参与去重时返回空
+func (d *dedupSet) keyOf(log LogEntry) string {
+	if d.cfg.Window <= 0 {
+		return ""
+	}
+	if log.ID != "" {
+		return "id:" + log.ID
+	}
+	if !d.cfg.Content {
+		return ""
+	}
+	h := fnv.New128a()
+	for _, part := range []string{log.Timestamp, log.Server, log.Message} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return "h:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// 记录一条日志；窗口内已经见过时返回 true
+func (d *dedupSet) check(log LogEntry, now time.Time) bool {
+	key := d.keyOf(log)
+	if key == "" {
+		return false
+	}
+	d.expire(now)
+	if _, ok := d.seen[key]; ok {
+		return true
+	}
+	d.seen[key] = now
+	d.order = append(d.order, dedupKey{key: key, at: now})
+	return false
+}
+
+func (d *dedupSet) expire(now time.Time) {
+	cutoff := now.Add(-d.cfg.Window)
+	n := 0
+	for n < len(d.order) && (d.order[n].at.Before(cutoff) || len(d.order)-n >= d.cfg.MaxKeys) {
+		delete(d.seen, d.order[n].key)
+		n++
+	}
+	if n > 0 {
+		// 前面过期的部分较多时才重新分配，避免 order 无限增长
+		d.order = d.order[n:]
+		if cap(d.order) > 2*len(d.order)+1024 {
+			d.order = append([]dedupKey(nil), d.order...)
+		}
+	}
+}
+
+// 热加载：窗口变小时下次检查自然过期，关闭时清空
+func (d *dedupSet) reconfigure(cfg DedupConfig) {
+	d.cfg = cfg
+	if cfg.Window <= 0 {
+		d.seen = make(map[string]time.Time)
+		d.order = nil
+	}
+}
+
+func (d *dedupSet) size() int {
+	return len(d.seen)
+}
+
+// 窗口内是否已经见过（只检查，不记录）
+func (d *dedupSet) has(log LogEntry, now time.Time) bool {
+	key := d.keyOf(log)
+	if key == "" {
+		return false
+	}
+	at, ok := d.seen[key]
+	return ok && !at.Before(now.Add(-d.cfg.Window))
+}
+
+// 写入接口提前判断重试的日志（日志本身由 Append 去重，这里用于跳过监控指标）
+func (s *LogStorage) IsDuplicate(log LogEntry) bool {
+	s.bufferMu.RLock()
+	defer s.bufferMu.RUnlock()
+	return s.dedup.has(log, time.Now())
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDedupSetExpire(t *testing.T) {
+	d := newDedupSet(DedupConfig{Window: time.Minute, MaxKeys: 3})
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+	entry := func(id string) LogEntry { return LogEntry{ID: id} }
+
+	if d.check(entry("a"), start) {
+		t.Fatal("first sighting reported as duplicate")
+	}
+	if !d.check(entry("a"), start.Add(30*time.Second)) {
+		t.Error("retry within the window not detected")
+	}
+	// 窗口过后同一个键重新计入
+	if d.check(entry("a"), start.Add(2*time.Minute)) {
+		t.Error("key did not expire after the window")
+	}
+
+	// 超过 max_keys 时最早的键先过期
+	now := start.Add(10 * time.Minute)
+	for i := 0; i < 5; i++ {
+		d.check(entry(fmt.Sprint("k", i)), now)
+	}
+	if d.size() > 3 {
+		t.Errorf("size = %d, want at most 3", d.size())
+	}
+	if d.has(entry("k0"), now) {
+		t.Error("oldest key kept beyond max_keys")
+	}
+	if !d.has(entry("k4"), now) {
+		t.Error("newest key evicted")
+	}
+}
+
+func TestDedupKeyOf(t *testing.T) {
+	log := LogEntry{Timestamp: "2024-05-01 10:00:00", Server: "web-01", Message: "hello"}
+	if key := newDedupSet(DedupConfig{Window: time.Minute}).keyOf(log); key != "" {
+		t.Errorf("content hashing off: key = %q, want empty", key)
+	}
+	if key := newDedupSet(DedupConfig{}).keyOf(LogEntry{ID: "x"}); key != "" {
+		t.Errorf("dedup disabled: key = %q, want empty", key)
+	}
+	d := newDedupSet(DedupConfig{Window: time.Minute, Content: true})
+	other := log
+	other.Message = "hello!"
+	if d.keyOf(log) == d.keyOf(other) || d.keyOf(log) != d.keyOf(log) {
+		t.Error("content keys must be stable and differ for different messages")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package main
+
+import "errors"
+
+// 其它平台不支持磁盘空间检查
+func diskSpace(path string) (total, free uint64, err error) {
+	return 0, 0, errors.New("disk space check not supported on this platform")
+}
@@ -2,4 +2,8 @@ module minilog
 
 go 1.20
 
-require github.com/pierrec/lz4/v4 v4.1.23 // indirect
+require (
+	github.com/klauspost/compress v1.17.4
+	github.com/pierrec/lz4/v4 v4.1.23
+	gopkg.in/yaml.v3 v3.0.1
+)
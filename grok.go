@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// 内置 grok 模式库（RE2 兼容的精简版）
+var grokPatterns = map[string]string{
+	"USERNAME":     `[a-zA-Z0-9._-]+`,
+	"USER":         `%{USERNAME}`,
+	"INT":          `(?:[+-]?[0-9]+)`,
+	"NUMBER":       `(?:[+-]?(?:[0-9]+(?:\.[0-9]*)?|\.[0-9]+))`,
+	"POSINT":       `\b[1-9][0-9]*\b`,
+	"WORD":         `\b\w+\b`,
+	"NOTSPACE":     `\S+`,
+	"SPACE":        `\s*`,
+	"DATA":         `.*?`,
+	"GREEDYDATA":   `.*`,
+	"QUOTEDSTRING": `"(?:[^"\\]|\\.)*"`,
+	"QS":           `%{QUOTEDSTRING}`,
+	"UUID":         `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+
+	"IPV4":         `(?:(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.){3}(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)`,
+	"IPV6":         `(?:[0-9A-Fa-f]{0,4}:){2,7}[0-9A-Fa-f]{0,4}`,
+	"IP":           `(?:%{IPV6}|%{IPV4})`,
+	"HOSTNAME":     `\b[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\.?`,
+	"IPORHOST":     `(?:%{IP}|%{HOSTNAME})`,
+	"HOSTPORT":     `%{IPORHOST}:%{POSINT}`,
+	"PATH":         `(?:/[^\s]*)+`,
+	"URIPATH":      `(?:/[^\s?#]*)+`,
+	"URIPARAM":     `\?[^\s#]*`,
+	"URIPATHPARAM": `%{URIPATH}(?:%{URIPARAM})?`,
+
+	"MONTH":             `(?:Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec)[a-z]*`,
+	"MONTHNUM":          `(?:0?[1-9]|1[0-2])`,
+	"MONTHDAY":          `(?:0[1-9]|[12][0-9]|3[01]|[1-9])`,
+	"DAY":               `(?:Mon|Tue|Wed|Thu|Fri|Sat|Sun)[a-z]*`,
+	"YEAR":              `(?:\d\d){1,2}`,
+	"HOUR":              `(?:2[0123]|[01]?[0-9])`,
+	"MINUTE":            `[0-5][0-9]`,
+	"SECOND":            `(?:[0-5]?[0-9]|60)(?:[:.,][0-9]+)?`,
+	"TIME":              `%{HOUR}:%{MINUTE}(?::%{SECOND})?`,
+	"ISO8601_TIMEZONE":  `(?:Z|[+-]%{HOUR}(?::?%{MINUTE}))`,
+	"TIMESTAMP_ISO8601": `%{YEAR}-%{MONTHNUM}-%{MONTHDAY}[T ]%{HOUR}:?%{MINUTE}(?::?%{SECOND})?%{ISO8601_TIMEZONE}?`,
+	"HTTPDATE":          `%{MONTHDAY}/%{MONTH}/%{YEAR}:%{TIME} %{INT}`,
+	"SYSLOGTIMESTAMP":   `%{MONTH} +%{MONTHDAY} %{TIME}`,
+
+	"LOGLEVEL": `(?:[Tt]race|TRACE|[Dd]ebug|DEBUG|[Nn]otice|NOTICE|[Ii]nfo|INFO|[Ww]arn(?:ing)?|WARN(?:ING)?|[Ee]rr(?:or)?|ERR(?:OR)?|[Cc]rit(?:ical)?|CRIT(?:ICAL)?|[Ff]atal|FATAL|[Ss]evere|SEVERE|[Aa]lert|ALERT|[Ee]merg(?:ency)?|EMERG(?:ENCY)?|PANIC|LOG)`,
+
+	// nginx / apache combined 访问日志
+	"NGINXACCESS":       `%{IPORHOST:client_ip} %{NOTSPACE:ident} %{NOTSPACE:auth} \[%{HTTPDATE:time_local}\] "(?:%{WORD:method} %{NOTSPACE:request}(?: HTTP/%{NUMBER:http_version})?|%{DATA:raw_request})" %{INT:status} (?:%{INT:bytes}|-)(?: %{QS:referrer} %{QS:user_agent})?`,
+	"COMBINEDAPACHELOG": `%{NGINXACCESS}`,
+
+	// postgres 默认 log_line_prefix（'%m [%p] '）
+	"POSTGRESQL": `%{TIMESTAMP_ISO8601:pg_timestamp}(?: %{WORD:pg_timezone})? \[%{INT:pg_pid}\] (?:%{NOTSPACE:pg_user}@%{NOTSPACE:pg_database} )?%{WORD:pg_level}:\s+%{GREEDYDATA:pg_message}`,
+}
+
+var grokRef = regexp.MustCompile(`%\{(\w+)(?::([\w.@-]+))?(?::\w+)?\}`)
+
+// 编译后的 grok 表达式（Go 的分组名不允许 "." 等字符，所以用 g0/g1... 再映射回字段名）
+type grokExpr struct {
+	re     *regexp.Regexp
+	fields map[string]string // 分组名 -> 字段名
+}
+
+func compileGrok(pattern string, custom map[string]string) (*grokExpr, error) {
+	g := &grokExpr{fields: make(map[string]string)}
+
+	expanded, err := g.expand(pattern, custom, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	re, err := regexp.Compile(expanded)
+	if err != nil {
+		return nil, fmt.Errorf("grok %q: %w", pattern, err)
+	}
+	g.re = re
+	return g, nil
+}
+
+func (g *grokExpr) expand(pattern string, custom map[string]string, depth int) (string, error) {
+	if depth > 20 {
+		return "", fmt.Errorf("grok 模式嵌套过深: %s", pattern)
+	}
+
+	var expandErr error
+	result := grokRef.ReplaceAllStringFunc(pattern, func(ref string) string {
+		m := grokRef.FindStringSubmatch(ref)
+		name, field := m[1], m[2]
+
+		def, ok := custom[name]
+		if !ok {
+			def, ok = grokPatterns[name]
+		}
+		if !ok {
+			expandErr = fmt.Errorf("未知 grok 模式: %s", name)
+			return ""
+		}
+
+		inner, err := g.expand(def, custom, depth+1)
+		if err != nil {
+			expandErr = err
+			return ""
+		}
+
+		if field == "" {
+			return "(?:" + inner + ")"
+		}
+		group := fmt.Sprintf("g%d", len(g.fields))
+		g.fields[group] = field
+		return "(?P<" + group + ">" + inner + ")"
+	})
+
+	return result, expandErr
+}
+
+// 匹配成功返回提取出的字段（空值不输出）
+func (g *grokExpr) match(text string) (map[string]string, bool) {
+	m := g.re.FindStringSubmatch(text)
+	if m == nil {
+		return nil, false
+	}
+
+	fields := make(map[string]string)
+	for i, group := range g.re.SubexpNames() {
+		if field, ok := g.fields[group]; ok && m[i] != "" {
+			fields[field] = strings.Trim(m[i], `"`)
+		}
+	}
+	return fields, true
+}
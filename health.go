@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// 健康检查配置
+type HealthConfig struct {
+	MaxDiskUsedPercent float64       `yaml:"max_disk_used_percent"` // 数据目录所在磁盘使用率超过该值时不再就绪
+	MaxBufferFactor    float64       `yaml:"max_buffer_factor"`     // 缓冲条数超过刷盘阈值的多少倍视为刷盘队列饱和
+	FlushStuckAfter    time.Duration `yaml:"flush_stuck_after"`     // 单次刷盘超过该时长视为刷盘任务卡住
+}
+
+// 单项检查结果
+type CheckResult struct {
+	Status  string `json:"status"` // ok / fail / unknown
+	Message string `json:"message,omitempty"`
+}
+
+// 健康检查：/readyz 检查能否接收流量，/healthz 检查进程是否需要重启
+type HealthChecker struct {
+	configs *ConfigManager
+	tenants *TenantManager
+}
+
+func NewHealthChecker(configs *ConfigManager, tenants *TenantManager) *HealthChecker {
+	return &HealthChecker{configs: configs, tenants: tenants}
+}
+
+// 就绪检查：WAL 重放、索引重建、数据目录可写、磁盘空间、刷盘队列
+func (h *HealthChecker) Readiness() map[string]CheckResult {
+	cfg := h.configs.Current()
+	checks := make(map[string]CheckResult)
+
+	// WAL 重放
+	replaying := 0
+	for _, t := range h.tenants.List() {
+		if t.Logs.replaying.Load() {
+			replaying++
+		}
+	}
+	if replaying > 0 {
+		checks["wal_replay"] = CheckResult{Status: "fail", Message: fmt.Sprintf("%d tenant(s) replaying WAL", replaying)}
+	} else {
+		checks["wal_replay"] = CheckResult{Status: "ok"}
+	}
+
+	// 段索引重建
+	indexing := 0
+	for _, t := range h.tenants.List() {
+		if t.Logs.indexing.Load() {
+			indexing++
+		}
+	}
+	if indexing > 0 {
+		checks["index_rebuild"] = CheckResult{Status: "fail", Message: fmt.Sprintf("%d tenant(s) rebuilding segment indexes", indexing)}
+	} else {
+		checks["index_rebuild"] = CheckResult{Status: "ok"}
+	}
+
+	// 数据目录可写
+	if err := checkWritable(cfg.DataDir); err != nil {
+		checks["data_dir_writable"] = CheckResult{Status: "fail", Message: err.Error()}
+	} else {
+		checks["data_dir_writable"] = CheckResult{Status: "ok"}
+	}
+
+	// 磁盘使用率
+	total, free, err := diskSpace(cfg.DataDir)
+	switch {
+	case err != nil:
+		checks["disk_space"] = CheckResult{Status: "unknown", Message: err.Error()}
+	case total == 0:
+		checks["disk_space"] = CheckResult{Status: "unknown", Message: "disk size unavailable"}
+	default:
+		used := 100 * float64(total-free) / float64(total)
+		message := fmt.Sprintf("%.1f%% used (limit %.0f%%)", used, cfg.Health.MaxDiskUsedPercent)
+		if used > cfg.Health.MaxDiskUsedPercent {
+			checks["disk_space"] = CheckResult{Status: "fail", Message: message}
+		} else {
+			checks["disk_space"] = CheckResult{Status: "ok", Message: message}
+		}
+	}
+
+	// 刷盘队列：缓冲积压超过阈值的若干倍，说明刷盘跟不上写入
+	saturated := 0
+	for _, t := range h.tenants.List() {
+		// 重放期间持有 bufferMu，已经由 wal_replay 报告
+		if t.Logs.replaying.Load() {
+			continue
+		}
+		t.Logs.bufferMu.RLock()
+		buffered, limit := len(t.Logs.memoryBuffer), t.Logs.maxBufferSize
+		t.Logs.bufferMu.RUnlock()
+		if float64(buffered) >= cfg.Health.MaxBufferFactor*float64(limit) {
+			saturated++
+		}
+	}
+	if saturated > 0 {
+		checks["flush_queue"] = CheckResult{Status: "fail", Message: fmt.Sprintf("%d tenant(s) buffering more than %.0fx the flush threshold", saturated, cfg.Health.MaxBufferFactor)}
+	} else {
+		checks["flush_queue"] = CheckResult{Status: "ok"}
+	}
+
+	return checks
+}
+
+// 存活检查：刷盘任务是否卡住
+func (h *HealthChecker) Liveness() map[string]CheckResult {
+	cfg := h.configs.Current()
+	now := time.Now()
+
+	stuck := 0
+	for _, t := range h.tenants.List() {
+		logs := t.Logs
+		if started := logs.flushStarted.Load(); started != 0 && now.Sub(time.Unix(0, started)) > cfg.Health.FlushStuckAfter {
+			stuck++
+			continue
+		}
+		// 后台任务每个刷盘间隔至少跳一次心跳（重放期间缓冲被锁住，跳过）
+		if logs.replaying.Load() {
+			continue
+		}
+		logs.bufferMu.RLock()
+		interval := logs.flushInterval
+		logs.bufferMu.RUnlock()
+		if now.Sub(time.Unix(0, logs.flusherBeat.Load())) > interval+cfg.Health.FlushStuckAfter {
+			stuck++
+		}
+	}
+
+	checks := make(map[string]CheckResult)
+	if stuck > 0 {
+		checks["flusher"] = CheckResult{Status: "fail", Message: fmt.Sprintf("%d tenant(s) with a stuck flusher", stuck)}
+	} else {
+		checks["flusher"] = CheckResult{Status: "ok"}
+	}
+	return checks
+}
+
+// 在目录中创建并删除一个临时文件
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".health-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	_, err = f.Write([]byte("ok"))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	os.Remove(name)
+	return err
+}
+
+// 输出检查结果：任一项 fail 时返回 503（unknown 不影响结果）
+func writeHealth(w http.ResponseWriter, checks map[string]CheckResult) {
+	status := "ok"
+	for _, c := range checks {
+		if c.Status == "fail" {
+			status = "fail"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+// API: 存活检查（不需要认证，供编排系统探测）
+func (h *HealthChecker) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, h.Liveness())
+}
+
+// API: 就绪检查（不需要认证，供编排系统探测）
+func (h *HealthChecker) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, h.Readiness())
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// 写入链路配置
+type IngestConfig struct {
+	Multiline       []MultilineRule
+	Pipelines       []PipelineConfig
+	Rules           []IngestRule
+	Redaction       []RedactionRule
+	RedactionMode   string
+	RedactionKey    []byte
+	SummaryInterval time.Duration // 规则丢弃摘要的输出间隔
+}
+
+// 由同一份配置生成的各个阶段（热加载时整体替换）
+type ingestStages struct {
+	multiline *MultilineAggregator
+	pipelines *PipelineSet
+	rules     *RuleSet
+	redactor  *Redactor
+	interval  time.Duration
+}
+
+// 写入链路：多行合并 → 字段提取管道 → 丢弃/采样/限流 → 脱敏 → LogStorage.Append
+type Ingester struct {
+	storage *LogStorage
+	stages  atomic.Pointer[ingestStages]
+	counts  *counterVec // source + 结果 -> 条数（/metrics 导出）
+}
+
+func NewIngester(storage *LogStorage, cfg IngestConfig) (*Ingester, error) {
+	ing := &Ingester{storage: storage, counts: newCounterVec(500)}
+
+	stages, err := ing.buildStages(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ing.stages.Store(stages)
+
+	// 定期输出规则丢弃摘要
+	go ing.summaryReporter()
+
+	return ing, nil
+}
+
+// 按配置生成新的阶段（不影响当前正在使用的阶段）
+func (i *Ingester) buildStages(cfg IngestConfig) (*ingestStages, error) {
+	stages := &ingestStages{interval: cfg.SummaryInterval}
+	if stages.interval <= 0 {
+		stages.interval = 60 * time.Second
+	}
+
+	var err error
+	if stages.pipelines, err = NewPipelineSet(cfg.Pipelines); err != nil {
+		return nil, err
+	}
+	if stages.rules, err = NewRuleSet(cfg.Rules); err != nil {
+		return nil, err
+	}
+	if stages.redactor, err = NewRedactor(cfg.Redaction, cfg.RedactionMode, cfg.RedactionKey); err != nil {
+		return nil, err
+	}
+	// 多行事件用生成它的那组阶段处理：热加载时旧聚合器输出的事件仍按旧配置处理
+	sink := func(entry LogEntry) { i.process(stages, entry) }
+	if stages.multiline, err = NewMultilineAggregator(cfg.Multiline, sink); err != nil {
+		return nil, err
+	}
+	return stages, nil
+}
+
+// 替换为新的阶段：旧的多行聚合器输出拼装中的事件，旧规则的丢弃摘要写入存储
+func (i *Ingester) swapStages(stages *ingestStages) {
+	old := i.stages.Swap(stages)
+	old.multiline.Close()
+	// 旧聚合器输出的事件可能被旧规则丢弃，摘要放在最后
+	if summary, ok := old.rules.Summary(old.interval); ok {
+		i.storage.Append(summary)
+	}
+}
+
+// 接收一条日志
+func (i *Ingester) Ingest(entry LogEntry) {
+	i.stages.Load().multiline.Add(entry)
+}
+
+// 多行合并完成后的处理
+func (i *Ingester) process(stages *ingestStages, entry LogEntry) {
+	stages.pipelines.Run(&entry)
+
+	if !stages.rules.Allow(entry) {
+		i.Count(entry, "dropped_rule")
+		return
+	}
+	if !stages.redactor.Apply(&entry) {
+		i.Count(entry, "dropped_redaction")
+		return
+	}
+
+	// 按结果计数：stored / duplicate / too_old / too_new（后三种不保存）
+	i.Count(entry, i.storage.Append(entry))
+}
+
+// 按来源和结果计数（写入接口拒绝的请求也记在这里）
+func (i *Ingester) Count(entry LogEntry, status string) {
+	source := entry.Source
+	if source == "" {
+		source = "unknown"
+	}
+	i.counts.Add(1, source, status)
+}
+
+// 摘要直接写入存储（不经过规则，避免被自己限流）
+func (i *Ingester) summaryReporter() {
+	interval := i.stages.Load().interval
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		stages := i.stages.Load()
+		if summary, ok := stages.rules.Summary(interval); ok {
+			i.storage.Append(summary)
+		}
+		// 热加载修改了间隔
+		if stages.interval != interval {
+			interval = stages.interval
+			ticker.Reset(interval)
+		}
+	}
+}
+
+// 停止多行聚合器并输出拼装中的事件（退出前调用；之后的日志直接进入后续阶段）
+func (i *Ingester) Close() {
+	i.stages.Load().multiline.Close()
+}
+
+func (i *Ingester) GetStats() map[string]interface{} {
+	stages := i.stages.Load()
+	combined := make(map[string]interface{})
+	for _, stats := range []map[string]interface{}{
+		stages.multiline.GetStats(),
+		stages.pipelines.GetStats(),
+		stages.rules.GetStats(),
+		stages.redactor.GetStats(),
+	} {
+		for k, v := range stats {
+			combined[k] = v
+		}
+	}
+	return combined
+}
+
+// API: 接收日志（实时写入内存）；带幂等键的重试请求照常返回成功，但只保存一次
+func handleIngest(cfg *Config, replication *ReplicationManager, cluster *Cluster) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenant := tenantFrom(r)
+		if r.Method != "POST" {
+			http.Error(w, "只接受POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if replication.Following() {
+			http.Error(w, "只读副本：请写入 leader "+cfg.Replication.Leader+"，或先提升为 leader", http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		var log LogEntry
+
+		if err := json.Unmarshal(body, &log); err != nil {
+			log = LogEntry{
+				Timestamp: time.Now().Format("2006-01-02 15:04:05"),
+				Message:   string(body),
+			}
+		}
+
+		if log.Timestamp == "" {
+			log.Timestamp = time.Now().Format("2006-01-02 15:04:05")
+		}
+
+		// 幂等键：日志自带的 id 优先，否则使用请求头（整个请求作为一批）
+		if log.ID == "" {
+			log.ID = r.Header.Get("Idempotency-Key")
+		}
+
+		// 绑定了服务器的 key 只能以该名称写入
+		if principal := principalFrom(r); principal != nil && principal.Server != "" {
+			if log.Server == "" {
+				log.Server = principal.Server
+			} else if log.Server != principal.Server {
+				tenant.Ingester.Count(log, "forbidden")
+				http.Error(w, "该 API Key 只能写入服务器 "+principal.Server, http.StatusForbidden)
+				return
+			}
+		}
+
+		// mTLS：客户端证书的 CN/SAN 是可信的服务器身份
+		if identities := clientCertIdentities(r); identities != nil {
+			if log.Server == "" && len(identities) > 0 {
+				log.Server = identities[0]
+			} else if !containsString(identities, log.Server) {
+				tenant.Ingester.Count(log, "forbidden")
+				http.Error(w, "客户端证书不允许写入服务器 "+log.Server, http.StatusForbidden)
+				return
+			}
+		}
+
+		// 集群：不属于本节点的分片转发给所属节点（配额由所属节点检查）
+		if owner := cluster.Owner(tenant.ID, log.Server); owner != nil && !cluster.Forwarded(r) {
+			cluster.Forward(w, r, owner, tenant.ID, log)
+			return
+		}
+
+		// 时间太早（超过 max_past 或保留时长）或太晚（超过 max_future）的日志拒绝，Agent 不必重试
+		if status := tenant.Logs.CheckTime(log.Timestamp); status != "" {
+			tenant.Ingester.Count(log, status)
+			http.Error(w, "日志时间 "+log.Timestamp+" 超出允许范围（storage.late / storage.retention）", http.StatusUnprocessableEntity)
+			return
+		}
+
+		// 租户配额（写入速率 / 磁盘占用）和管理员暂停
+		if code := tenant.admit(); code != 0 {
+			switch code {
+			case http.StatusServiceUnavailable:
+				tenant.Ingester.Count(log, "paused")
+				http.Error(w, "租户 "+tenant.ID+" 已暂停写入", code)
+				return
+			case http.StatusTooManyRequests:
+				tenant.Ingester.Count(log, "rate_limited")
+			default:
+				tenant.Ingester.Count(log, "disk_full")
+			}
+			http.Error(w, "租户 "+tenant.ID+" 超出配额", code)
+			return
+		}
+
+		// 经过写入链路后追加到内存（重试的日志由去重窗口丢弃，监控指标同样不再记录）
+		duplicate := tenant.Logs.IsDuplicate(log)
+		tenant.Ingester.Ingest(log)
+
+		// 如果包含监控指标，存储到 metricsStorage
+		// 任何带 server 的日志都会更新服务器状态（基于最后推送时间）
+		if log.Metrics != nil && log.Server != "" && !duplicate {
+			metricsEntry := MetricsEntry{
+				Timestamp: log.Timestamp,
+				Server:    log.Server,
+				Metrics:   *log.Metrics,
+			}
+			tenant.Metrics.Append(metricsEntry)
+		}
+
+		fmt.Fprintf(w, "✓ Received")
+	}
+}
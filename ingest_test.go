@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRepeatedPostStoredOnce(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.DataDir = t.TempDir()
+	tenants, err := NewTenantManager(cfg, cfg.IngestConfig([]byte("test-key")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replication := NewReplicationManager(cfg.Replication, tenants, cfg.DataDir)
+	handler := tenants.Resolve(handleIngest(cfg, replication, NewCluster(cfg.Cluster)))
+	tenant, _ := tenants.Get(defaultTenant)
+	waitReplayed(t, tenant.Logs)
+
+	now := time.Now().Format(columnTimeLayout)
+	post := func(body, idempotencyKey string) {
+		t.Helper()
+		r := httptest.NewRequest("POST", "/api/logs", strings.NewReader(body))
+		if idempotencyKey != "" {
+			r.Header.Set("Idempotency-Key", idempotencyKey)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("POST %s: %d %s", body, w.Code, w.Body.String())
+		}
+	}
+
+	// Agent 重试：同一条日志带着相同的 id 发送两次
+	agent := `{"id":"a1","timestamp":"` + now + `","level":"METRICS","server":"web-01","message":"System Metrics","metrics":{"cpu_percent":12.5}}`
+	post(agent, "")
+	post(agent, "")
+	// 其它采集端：请求头里的幂等键
+	plain := `{"timestamp":"` + now + `","level":"INFO","server":"web-02","message":"hello"}`
+	post(plain, "req-1")
+	post(plain, "req-1")
+
+	if got := len(tenant.Logs.Query("", "web-01", "", "", "", 10, nil)); got != 1 {
+		t.Errorf("web-01: %d entries stored, want 1", got)
+	}
+	if got := len(tenant.Logs.Query("", "web-02", "", "", "", 10, nil)); got != 1 {
+		t.Errorf("web-02: %d entries stored, want 1", got)
+	}
+	if got := len(tenant.Metrics.Query("web-01", "", 10, nil)); got != 1 {
+		t.Errorf("web-01: %d metric points stored, want 1", got)
+	}
+	if got := tenant.Logs.GetStats()["duplicates_suppressed"]; got != int64(2) {
+		t.Errorf("duplicates_suppressed = %v, want 2", got)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// 迟到和乱序的日志：刷盘时每条日志按自身时间写入所在小时的段（重连的 Agent 补发的日志回到原来的小时），
+// 块内按时间排序；写回已经结束的小时时该小时的热层段会重新出现（补写段），
+// 之后由压缩重新排序、由转存追加到温层或冷层已有的段后面。
+// 太早（超过 max_past 或保留时长）和太晚（超过 max_future）的日志直接拒绝。
+type LateConfig struct {
+	MaxPast   time.Duration `yaml:"max_past"`   // 早于 now - max_past 的日志拒绝（0 表示只受 retention 限制）
+	MaxFuture time.Duration `yaml:"max_future"` // 晚于 now + max_future 的日志拒绝（0 表示不限制）
+}
+
+// Append 的结果（也是写入计数的 status）
+const (
+	appendStored    = "stored"
+	appendDuplicate = "duplicate"
+	appendTooOld    = "too_old"
+	appendTooNew    = "too_new"
+)
+
+// 日志的事件时间；无法解析的时间戳（管道提取的其它格式）按到达时间处理
+func eventTime(timestamp string, now time.Time) (time.Time, bool) {
+	t, err := time.ParseInLocation(columnTimeLayout, timestamp, time.Local)
+	if err != nil {
+		return now, false
+	}
+	return t, true
+}
+
+// 检查事件时间是否在允许范围内，返回拒绝原因（允许时为空）；
+// 所在小时已经超过保留时长的日志写入后也会马上被删除，同样拒绝
+func checkEventTime(timestamp string, now time.Time, late LateConfig, retention time.Duration) string {
+	t, ok := eventTime(timestamp, now)
+	if !ok {
+		return ""
+	}
+	if late.MaxFuture > 0 && t.After(now.Add(late.MaxFuture)) {
+		return appendTooNew
+	}
+	if late.MaxPast > 0 && t.Before(now.Add(-late.MaxPast)) {
+		return appendTooOld
+	}
+	if retention > 0 && !t.Truncate(time.Hour).Add(time.Hour).After(now.Add(-retention)) {
+		return appendTooOld
+	}
+	return ""
+}
+
+// 写入接口提前检查（管道可能改写时间戳，Append 会再检查一次）
+func (s *LogStorage) CheckTime(timestamp string) string {
+	s.bufferMu.RLock()
+	late, retention := s.late, s.retention
+	s.bufferMu.RUnlock()
+	return checkEventTime(timestamp, time.Now(), late, retention)
+}
+
+// 同一小时的日志（按时间排序）
+type hourGroup struct {
+	hour string
+	logs []LogEntry
+}
+
+// 按事件时间所在的小时分组，小时从旧到新，组内按时间排序（相同时间保持到达顺序）
+func groupByHour(logs []LogEntry, now time.Time) []hourGroup {
+	byHour := make(map[string][]LogEntry)
+	for _, log := range logs {
+		t, _ := eventTime(log.Timestamp, now)
+		hour := t.Format("2006-01-02-15")
+		byHour[hour] = append(byHour[hour], log)
+	}
+	groups := make([]hourGroup, 0, len(byHour))
+	for hour, entries := range byHour {
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].Timestamp < entries[j].Timestamp })
+		groups = append(groups, hourGroup{hour: hour, logs: entries})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].hour < groups[j].hour })
+	return groups
+}
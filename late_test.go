@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckEventTime(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.Local)
+	at := func(d time.Duration) string { return now.Add(d).Format(columnTimeLayout) }
+	cases := []struct {
+		name      string
+		timestamp string
+		late      LateConfig
+		retention time.Duration
+		want      string
+	}{
+		{"no limits", at(-72 * time.Hour), LateConfig{}, 0, ""},
+		{"future, no limit by default", at(time.Hour), LateConfig{}, 0, ""},
+		{"within max_future", at(5 * time.Minute), LateConfig{MaxFuture: 10 * time.Minute}, 0, ""},
+		{"beyond max_future", at(11 * time.Minute), LateConfig{MaxFuture: 10 * time.Minute}, 0, appendTooNew},
+		{"within max_past", at(-time.Hour), LateConfig{MaxPast: 2 * time.Hour}, 0, ""},
+		{"beyond max_past", at(-3 * time.Hour), LateConfig{MaxPast: 2 * time.Hour}, 0, appendTooOld},
+		// 按整个小时判断：所在小时的末尾晚于 now - retention 时接受
+		{"hour still retained", "2024-04-30 12:00:00", LateConfig{}, 24 * time.Hour, ""},
+		{"hour already removed", "2024-04-30 11:59:59", LateConfig{}, 24 * time.Hour, appendTooOld},
+		{"unparseable uses arrival time", "01/May/2024:10:00:00", LateConfig{MaxPast: time.Minute}, time.Hour, ""},
+	}
+	for _, c := range cases {
+		if got := checkEventTime(c.timestamp, now, c.late, c.retention); got != c.want {
+			t.Errorf("%s: checkEventTime(%q) = %q, want %q", c.name, c.timestamp, got, c.want)
+		}
+	}
+}
+
+func TestGroupByHour(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.Local)
+	logs := []LogEntry{
+		{Timestamp: "2024-05-01 12:10:00", Message: "c"},
+		{Timestamp: "2024-05-01 10:59:59", Message: "a"},
+		{Timestamp: "2024-05-01 12:05:00", Message: "b1"},
+		{Timestamp: "not a time", Message: "arrival"},
+		{Timestamp: "2024-05-01 12:05:00", Message: "b2"},
+	}
+	groups := groupByHour(logs, now)
+
+	want := map[string][]string{
+		"2024-05-01-10": {"a"},
+		"2024-05-01-12": {"b1", "b2", "c", "arrival"}, // 无法解析的时间按到达时间分组，排在最后
+	}
+	if len(groups) != 2 || groups[0].hour != "2024-05-01-10" || groups[1].hour != "2024-05-01-12" {
+		t.Fatalf("groups not ordered oldest first: %+v", groups)
+	}
+	for _, g := range groups {
+		var messages []string
+		for _, log := range g.logs {
+			messages = append(messages, log.Message)
+		}
+		if len(messages) != len(want[g.hour]) {
+			t.Errorf("%s: %v, want %v", g.hour, messages, want[g.hour])
+			continue
+		}
+		for i := range messages {
+			if messages[i] != want[g.hour][i] {
+				t.Errorf("%s: %v, want %v (sorted by time, ties in arrival order)", g.hour, messages, want[g.hour])
+				break
+			}
+		}
+	}
+}
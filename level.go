@@ -0,0 +1,192 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// 日志级别（规范化后的严重程度，数值越大越严重）
+type Severity int
+
+const (
+	SeverityUnknown Severity = iota
+	SeverityTrace
+	SeverityDebug
+	SeverityInfo
+	SeverityNotice
+	SeverityWarn
+	SeverityError
+	SeverityCritical
+	SeverityFatal
+
+	// 监控指标不是严重程度，单独成类，不参与 >= / <= 比较
+	SeverityMetrics Severity = 100
+)
+
+var severityNames = map[Severity]string{
+	SeverityUnknown:  "UNKNOWN",
+	SeverityTrace:    "TRACE",
+	SeverityDebug:    "DEBUG",
+	SeverityInfo:     "INFO",
+	SeverityNotice:   "NOTICE",
+	SeverityWarn:     "WARN",
+	SeverityError:    "ERROR",
+	SeverityCritical: "CRITICAL",
+	SeverityFatal:    "FATAL",
+	SeverityMetrics:  "METRICS",
+}
+
+// 级别别名表（统一转成小写后查找）
+var severityAliases = map[string]Severity{
+	"trace": SeverityTrace, "trc": SeverityTrace, "t": SeverityTrace, "finest": SeverityTrace, "verbose": SeverityTrace,
+	"debug": SeverityDebug, "dbg": SeverityDebug, "d": SeverityDebug, "fine": SeverityDebug,
+	"info": SeverityInfo, "inf": SeverityInfo, "i": SeverityInfo, "information": SeverityInfo, "informational": SeverityInfo,
+	"notice": SeverityNotice, "n": SeverityNotice,
+	"warn": SeverityWarn, "warning": SeverityWarn, "wrn": SeverityWarn, "w": SeverityWarn,
+	"error": SeverityError, "err": SeverityError, "e": SeverityError, "severe": SeverityError,
+	"critical": SeverityCritical, "crit": SeverityCritical, "c": SeverityCritical, "alert": SeverityCritical,
+	"fatal": SeverityFatal, "ftl": SeverityFatal, "f": SeverityFatal, "panic": SeverityFatal, "emerg": SeverityFatal, "emergency": SeverityFatal,
+	"metrics": SeverityMetrics, "metric": SeverityMetrics,
+}
+
+// syslog 数字级别（RFC 5424）
+var syslogSeverities = []Severity{
+	0: SeverityFatal,    // emerg
+	1: SeverityCritical, // alert
+	2: SeverityCritical, // crit
+	3: SeverityError,    // err
+	4: SeverityWarn,     // warning
+	5: SeverityNotice,   // notice
+	6: SeverityInfo,     // info
+	7: SeverityDebug,    // debug
+}
+
+func (s Severity) String() string {
+	if name, ok := severityNames[s]; ok {
+		return name
+	}
+	return "UNKNOWN"
+}
+
+// 是否参与大小比较（UNKNOWN 和 METRICS 不参与）
+func (s Severity) ordered() bool {
+	return s > SeverityUnknown && s <= SeverityFatal
+}
+
+// 把任意写法的级别转成规范级别
+func ParseSeverity(level string) Severity {
+	level = strings.ToLower(strings.TrimSpace(level))
+	if level == "" {
+		return SeverityUnknown
+	}
+	if sev, ok := severityAliases[level]; ok {
+		return sev
+	}
+	if n, err := strconv.Atoi(level); err == nil && n >= 0 && n < len(syslogSeverities) {
+		return syslogSeverities[n]
+	}
+	return SeverityUnknown
+}
+
+// 规范化级别名（用于存储和统计）
+func normalizeLevel(level string) string {
+	return ParseSeverity(level).String()
+}
+
+// 级别筛选条件：支持 ERROR / err / level>=WARN / <INFO / !=DEBUG
+type levelFilter struct {
+	op  string   // "", "=", "!=", ">=", ">", "<=", "<"
+	sev Severity // 规范级别（未知别名时为 SeverityUnknown）
+	raw string   // 原始值（小写），未知别名时退化为精确匹配
+}
+
+func parseLevelFilter(expr string) levelFilter {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return levelFilter{}
+	}
+
+	// 允许 "level>=WARN" 或 ">=WARN" 两种写法
+	lower := strings.ToLower(expr)
+	if strings.HasPrefix(lower, "level") {
+		rest := strings.TrimSpace(expr[len("level"):])
+		if rest != "" && strings.ContainsAny(rest[:1], "<>=!") {
+			expr = rest
+		}
+	}
+
+	op := "="
+	for _, candidate := range []string{">=", "<=", "!=", ">", "<", "="} {
+		if strings.HasPrefix(expr, candidate) {
+			op = candidate
+			expr = strings.TrimSpace(expr[len(candidate):])
+			break
+		}
+	}
+
+	return levelFilter{
+		op:  op,
+		sev: ParseSeverity(expr),
+		raw: strings.ToLower(expr),
+	}
+}
+
+// 直接写在 URL 里的比较表达式（?level>=WARN、?level>WARN、?level!=DEBUG）。
+// 标准解析会把 "level>WARN" 当成没有值的 key、把 "level>=WARN" 拆成 key "level>"，严格比较会丢失，这里按原始查询串解析
+func levelFromRawQuery(rawQuery string) (string, bool) {
+	for _, part := range strings.Split(rawQuery, "&") {
+		expr, err := url.QueryUnescape(part)
+		if err != nil || !strings.HasPrefix(strings.ToLower(expr), "level") || len(expr) <= len("level") {
+			continue
+		}
+		if strings.ContainsAny(expr[len("level"):len("level")+1], "<>!") {
+			return expr, true
+		}
+	}
+	return "", false
+}
+
+func (f levelFilter) empty() bool {
+	return f.op == ""
+}
+
+func (f levelFilter) match(level string) bool {
+	if f.empty() {
+		return true
+	}
+
+	sev := ParseSeverity(level)
+
+	// 未知别名：保持旧行为，按原始级别精确比较
+	if f.sev == SeverityUnknown {
+		equal := strings.ToLower(level) == f.raw
+		if f.op == "!=" {
+			return !equal
+		}
+		return f.op == "=" && equal
+	}
+
+	switch f.op {
+	case "=":
+		return sev == f.sev
+	case "!=":
+		return sev != f.sev
+	}
+
+	// 范围比较只对有序级别生效
+	if !sev.ordered() || !f.sev.ordered() {
+		return false
+	}
+	switch f.op {
+	case ">=":
+		return sev >= f.sev
+	case ">":
+		return sev > f.sev
+	case "<=":
+		return sev <= f.sev
+	case "<":
+		return sev < f.sev
+	}
+	return false
+}
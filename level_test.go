@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestParseLevelFilter(t *testing.T) {
+	cases := []struct {
+		expr  string
+		level string
+		want  bool
+	}{
+		{"", "DEBUG", true},
+		{"ERROR", "error", true},
+		{"err", "ERROR", true},
+		{"ERROR", "WARN", false},
+		{">=WARN", "ERROR", true},
+		{">=WARN", "WARN", true},
+		{">=WARN", "INFO", false},
+		{">WARN", "WARN", false},
+		{">WARN", "FATAL", true},
+		{"<WARN", "WARN", false},
+		{"<=WARN", "WARN", true},
+		{"!=DEBUG", "DEBUG", false},
+		{"!=DEBUG", "INFO", true},
+		{"level>=warning", "ERROR", true},
+		{"level<INFO", "DEBUG", true},
+		{"custom", "CUSTOM", true},
+		{"custom", "other", false},
+		{"!=custom", "other", true},
+		{">=WARN", "custom", false},
+	}
+	for _, c := range cases {
+		if got := parseLevelFilter(c.expr).match(c.level); got != c.want {
+			t.Errorf("parseLevelFilter(%q).match(%q) = %v, want %v", c.expr, c.level, got, c.want)
+		}
+	}
+}
+
+func TestLevelFromRawQuery(t *testing.T) {
+	cases := []struct {
+		raw  string
+		want string
+		ok   bool
+	}{
+		{"level>=WARN", "level>=WARN", true},
+		{"server=web&level>WARN", "level>WARN", true},
+		{"level<WARN&keyword=x", "level<WARN", true},
+		{"level!=DEBUG", "level!=DEBUG", true},
+		{"level%3E%3DERROR", "level>=ERROR", true},
+		{"level=WARN", "", false},
+		{"levels=x", "", false},
+		{"", "", false},
+	}
+	for _, c := range cases {
+		got, ok := levelFromRawQuery(c.raw)
+		if got != c.want || ok != c.ok {
+			t.Errorf("levelFromRawQuery(%q) = %q, %v, want %q, %v", c.raw, got, ok, c.want, c.ok)
+		}
+	}
+	// 严格比较不能退化为 >=
+	expr, _ := levelFromRawQuery("level>WARN")
+	if parseLevelFilter(expr).match("WARN") {
+		t.Errorf("level>WARN matched WARN")
+	}
+}
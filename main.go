@@ -1,25 +1,32 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
-
-	"github.com/pierrec/lz4/v4"
 )
 
 type LogEntry struct {
-	Timestamp string   `json:"timestamp"`
-	Level     string   `json:"level"`
-	Server    string   `json:"server"`
-	Message   string   `json:"message"`
-	Metrics   *Metrics `json:"metrics,omitempty"` // 可选的监控指标
+	Timestamp string            `json:"timestamp"`
+	Level     string            `json:"level"`
+	NormLevel string            `json:"norm_level,omitempty"` // 规范化后的级别（ERROR/WARN/INFO...）
+	Server    string            `json:"server"`
+	Source    string            `json:"source,omitempty"` // 日志来源（文件名、应用名等）
+	Message   string            `json:"message"`
+	Fields    map[string]string `json:"fields,omitempty"`  // 结构化字段（由 ingest 管道提取）
+	Metrics   *Metrics          `json:"metrics,omitempty"` // 可选的监控指标
+	ID        string            `json:"id,omitempty"`      // 可选的幂等键（去重用，只写入 WAL，不保存到段）
 }
 
 // 日志存储引擎（核心）
@@ -31,7 +38,15 @@ type LogStorage struct {
 	// 配置参数
 	maxBufferSize   int           // 最大缓冲条数
 	maxBufferMemory int64         // 最大缓冲内存（字节）
+	bufferBytes     int64         // 当前缓冲的大致内存占用
 	flushInterval   time.Duration // 刷盘间隔
+	flushReset      chan struct{} // 热加载修改了刷盘间隔（后台任务重新读取 flushInterval）
+	retention       time.Duration // 磁盘文件保留时长（0 表示永久保留）
+	late            LateConfig    // 迟到日志的时间范围（late.go）
+	compaction      CompactionConfig // 后台压缩
+	compression     CompressionConfig // 刷盘编码和转冷策略
+	codecs          *codecSet
+	codecTotals     codecStatsCache // /api/stats 的编码统计，按段缓存
 	dataDir         string
 	
 	// 统计信息
@@ -39,141 +54,433 @@ type LogStorage struct {
 		TotalReceived   int64
 		TotalCompressed int64
 		CompressionRatio float64
+		LevelCounts      map[string]int64 // 按规范级别统计
+		Flushes           int64
+		UncompressedBytes int64
+		CompressedBytes   int64
+		ChunksScanned     int64 // 查询解压过的块数
+		Duplicates        int64 // 去重丢弃的重试日志
+		LateEntries       int64 // 写回已经结束的小时的日志
+	}
+	
+	// 写入去重（dedup.go）
+	dedup *dedupSet
+	
+	// 延迟直方图（/metrics 导出）
+	flushLatency *histogram
+	queryLatency *histogram
+	
+	// 预写日志和刷盘状态（/healthz、/readyz 使用）
+	wal          *writeAheadLog
+	replaying    atomic.Bool  // 正在重放 WAL
+	flushMu      sync.Mutex   // 刷盘串行执行，保证 WAL 按顺序删除
+	flushPending atomic.Bool  // 已经有排队的条件触发刷盘
+	flushStarted atomic.Int64 // 正在进行的刷盘开始时间（UnixNano，0 表示空闲）
+	flusherBeat  atomic.Int64 // 后台刷盘任务的心跳（UnixNano）
+	indexing     atomic.Bool  // 正在重建段索引
+	
+	// 压缩：同一时间只有一个压缩任务；替换段文件时查询等待，保证段和索引一致
+	compactMu sync.Mutex
+	swapMu    sync.RWMutex
+	
+	// 分层存储：温层和冷层（未配置时为 nil），配置只在启动时读取
+	warm         SegmentStore
+	warmAfter    time.Duration
+	remote       SegmentStore
+	offloadAfter time.Duration
+	cache        *chunkCache // 冷层块缓存
+	tierStats    struct {
+		toWarm       atomic.Int64
+		toCold       atomic.Int64
+		fetches      atomic.Int64 // 冷层下载次数（索引、整段或范围读取，不含缓存命中）
+		fetchedBytes atomic.Int64
 	}
+	
+	// 主从复制（replication.go）：follower 的存储只接收 leader 的数据，不刷盘、不压缩、不转存、不执行保留策略
+	replica atomic.Bool
+	
+	// 保留策略删除段时记录审计（retention.go）
+	audit  *AuditLog
+	tenant string
+	repl    replicationState
 }
 
-func NewLogStorage(dataDir string) *LogStorage {
+func NewLogStorage(dataDir string, cfg StorageConfig, audit *AuditLog, tenant string) *LogStorage {
 	os.MkdirAll(dataDir, 0755)
 	
 	storage := &LogStorage{
-		memoryBuffer:    make([]LogEntry, 0, 1000),
-		maxBufferSize:   1000,                 // 攒够1000条就压缩
-		maxBufferMemory: 10 * 1024 * 1024,     // 或者超过10MB就压缩
-		flushInterval:   60 * time.Second,     // 或者超过60秒就压缩
+		memoryBuffer:    make([]LogEntry, 0, cfg.BufferSize),
+		maxBufferSize:   cfg.BufferSize,            // 攒够多少条就压缩（默认1000）
+		maxBufferMemory: int64(cfg.BufferMemory),   // 或者超过多少内存就压缩（默认10MB）
+		flushInterval:   cfg.FlushInterval,         // 或者超过多久就压缩（默认60秒）
+		flushReset:      make(chan struct{}, 1),
+		retention:       cfg.Retention,
+		late:            cfg.Late,
+		compaction:      cfg.Compaction,
+		compression:     cfg.Compression,
+		codecs:          newCodecSet(dataDir),
 		dataDir:         dataDir,
+		flushLatency:    newHistogram(latencyBuckets),
+		queryLatency:    newHistogram(latencyBuckets),
+		repl:            newReplicationState(),
+		dedup:           newDedupSet(cfg.Dedup),
+		audit:           audit,
+		tenant:          tenant,
+	}
+	storage.stats.LevelCounts = make(map[string]int64)
+	storage.flusherBeat.Store(time.Now().UnixNano())
+	
+	// 分层存储（配置已校验，这里失败只可能是环境问题，退回只用本地磁盘）
+	if cfg.Tiers.WarmDir != "" {
+		storage.warm = &localStore{dir: cfg.Tiers.WarmDir}
+		storage.warmAfter = cfg.Tiers.WarmAfter
+	}
+	if remote, err := newSegmentStore(cfg.Remote); err != nil {
+		fmt.Println("⚠️  Remote segment store disabled:", err)
+	} else if remote != nil {
+		storage.remote = remote
+		storage.offloadAfter = cfg.Remote.OffloadAfter
+		if cfg.Tiers.CacheSize > 0 {
+			cacheDir := cfg.Tiers.CacheDir
+			if cacheDir == "" {
+				cacheDir = filepath.Join(dataDir, "cache")
+			}
+			storage.cache = newChunkCache(cacheDir, int64(cfg.Tiers.CacheSize))
+		}
 	}
 	
+	// 打开 WAL；有未刷盘的旧文件时后台重放（重放期间持有 bufferMu，写入和查询等待）
+	wal, pending, err := openWAL(dataDir)
+	if err != nil {
+		fmt.Println("⚠️  WAL disabled:", err)
+	} else {
+		storage.wal = wal
+		go storage.walSyncer()
+		if len(pending) > 0 {
+			storage.replaying.Store(true)
+			storage.bufferMu.Lock()
+			go storage.replayWAL(pending)
+		}
+	}
+	
+	// 检查段索引，缺失或失效的在后台重建（重建期间 /readyz 未就绪）
+	storage.indexing.Store(true)
+	go storage.RebuildIndexes(false)
+	
 	// 启动后台定时压缩任务
 	go storage.backgroundFlusher()
+	go storage.retentionJanitor()
+	go storage.compactor()
 	
 	return storage
 }
 
 // 接收日志（实时写入内存）
-func (s *LogStorage) Append(log LogEntry) {
+func (s *LogStorage) Append(log LogEntry) string {
 	s.bufferMu.Lock()
 	defer s.bufferMu.Unlock()
 	
-	// 添加到内存缓冲
+	// 时间超出允许范围的日志拒绝（管道可能改写了时间戳，写入接口检查过的这里再检查一次）
+	now := time.Now()
+	if status := checkEventTime(log.Timestamp, now, s.late, s.retention); status != "" {
+		return status
+	}
+	
+	// 去重窗口内见过的重试日志不再保存
+	if s.dedup.check(log, now) {
+		s.stats.Duplicates++
+		return appendDuplicate
+	}
+	
+	// 规范化级别（保留原始级别）
+	log.NormLevel = normalizeLevel(log.Level)
+	
+	// 先写 WAL（带幂等键，重放后仍能去重），再添加到内存缓冲
+	if s.wal != nil {
+		if err := s.wal.append(formatLogLine(log)); err != nil {
+			fmt.Println("⚠️  WAL write failed:", err)
+		}
+	}
+	log.ID = ""
 	s.memoryBuffer = append(s.memoryBuffer, log)
+	s.bufferBytes += entrySize(log)
 	s.stats.TotalReceived++
+	s.stats.LevelCounts[log.NormLevel]++
+	s.repl.wake()
 	
-	// 检查是否需要立即压缩（条件触发）
-	if len(s.memoryBuffer) >= s.maxBufferSize {
-		go s.flushToDisk() // 异步压缩，不阻塞接收
+	// 检查是否需要立即压缩（条件触发：条数或内存）
+	if len(s.memoryBuffer) >= s.maxBufferSize || s.bufferBytes >= s.maxBufferMemory {
+		// 异步压缩，不阻塞接收；已经有排队的刷盘时不再重复触发
+		if s.flushPending.CompareAndSwap(false, true) {
+			go func() {
+				s.flushToDisk()
+				s.flushPending.Store(false)
+			}()
+		}
 	}
+	return appendStored
 }
 
 // 后台定时任务（定时压缩）
 func (s *LogStorage) backgroundFlusher() {
 	ticker := time.NewTicker(s.flushInterval)
-	for range ticker.C {
-		s.flushToDisk()
+	for {
+		s.flusherBeat.Store(time.Now().UnixNano())
+		select {
+		case <-ticker.C:
+			s.flushToDisk()
+		case <-s.flushReset:
+			s.bufferMu.RLock()
+			interval := s.flushInterval
+			s.bufferMu.RUnlock()
+			ticker.Reset(interval)
+		}
+	}
+}
+
+// 热加载：更新缓冲阈值、刷盘间隔、保留时长、压缩和编码策略（缓冲中的日志不受影响）
+func (s *LogStorage) Reconfigure(cfg StorageConfig) {
+	s.bufferMu.Lock()
+	s.maxBufferSize = cfg.BufferSize
+	s.maxBufferMemory = int64(cfg.BufferMemory)
+	s.retention = cfg.Retention
+	s.late = cfg.Late
+	s.compaction = cfg.Compaction
+	s.compression = cfg.Compression
+	s.dedup.reconfigure(cfg.Dedup)
+	changed := s.flushInterval != cfg.FlushInterval
+	s.flushInterval = cfg.FlushInterval
+	s.bufferMu.Unlock()
+	
+	// 不阻塞：已经有未处理的通知时后台任务会读到最新的间隔（刷盘期间多次热加载也不会卡住）
+	if changed {
+		select {
+		case s.flushReset <- struct{}{}:
+		default:
+		}
 	}
 }
 
-// 压缩并写入磁盘
-func (s *LogStorage) flushToDisk() {
+// 刷盘结果（/api/admin/flush）
+type FlushResult struct {
+	Entries           int     `json:"entries"`
+	Segment           string  `json:"segment"`
+	Segments          []string `json:"segments,omitempty"` // 写入了多个小时的段时（迟到的日志）列出全部
+	UncompressedBytes int     `json:"uncompressed_bytes"`
+	CompressedBytes   int     `json:"compressed_bytes"`
+	DurationMs        float64 `json:"duration_ms"`
+}
+
+// 压缩并写入磁盘（缓冲区为空时返回 nil）
+func (s *LogStorage) flushToDisk() (*FlushResult, error) {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	
 	s.bufferMu.Lock()
 	
-	// 如果缓冲区为空，直接返回
-	if len(s.memoryBuffer) == 0 {
+	// 如果缓冲区为空，直接返回（follower 的缓冲由段同步清理）
+	if len(s.memoryBuffer) == 0 || s.replica.Load() {
 		s.bufferMu.Unlock()
-		return
+		return nil, nil
 	}
 	
+	start := time.Now()
+	s.flushStarted.Store(start.UnixNano())
+	defer s.flushStarted.Store(0)
+	
 	// 取出缓冲区数据（快速释放锁）
 	logsToCompress := make([]LogEntry, len(s.memoryBuffer))
 	copy(logsToCompress, s.memoryBuffer)
 	s.memoryBuffer = s.memoryBuffer[:0] // 清空缓冲区
+	s.bufferBytes = 0
+	hotCodec := s.compression.Hot
+	flushedSeq := s.repl.base + int64(len(logsToCompress)) - 1
+	s.repl.base = flushedSeq + 1
 	
-	s.bufferMu.Unlock()
-	
-	// 下面的操作不持有锁，不影响新日志写入
-	
-	// 1. 序列化为文本
-	var plainText bytes.Buffer
-	for _, log := range logsToCompress {
-		line := fmt.Sprintf("[%s] [%s] [%s] %s\n",
-			log.Timestamp, log.Level, log.Server, log.Message)
-		plainText.WriteString(line)
+	// 切换 WAL 文件：之后的新日志写入新文件，旧文件在压缩块落盘后删除
+	var walSeq int64
+	if s.wal != nil {
+		seq, err := s.wal.rotate()
+		if err != nil {
+			fmt.Println("⚠️  WAL rotate failed:", err)
+		}
+		walSeq = seq
 	}
 	
-	// 2. LZ4压缩
-	var compressed bytes.Buffer
-	writer := lz4.NewWriter(&compressed)
-	writer.Write(plainText.Bytes())
-	writer.Close()
+	s.bufferMu.Unlock()
 	
-	// 3. 写入文件（按小时分片）
-	hour := time.Now().Format("2006-01-02-15")
-	filename := fmt.Sprintf("%s/logs-%s.lz4", s.dataDir, hour)
+	// 下面的操作不持有锁，不影响新日志写入
 	
-	f, _ := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	// 1. 按日志自身的时间分到所在小时（迟到的日志写回原来的小时），每个小时的日志按时间排序
+	now := time.Now()
+	current := now.Format("2006-01-02-15")
+	groups := groupByHour(logsToCompress, now)
 	
-	// 写入分隔符（方便后续分块读取）
-	separator := fmt.Sprintf("===CHUNK_%d===\n", time.Now().Unix())
-	f.WriteString(separator)
-	f.Write(compressed.Bytes())
-	f.Close()
+	// 2-3. 每个小时按列编码为一个块（默认 LZ4，分隔符记录编码和布局，方便后续分块读取），追加到该小时的段
+	codec, err := s.codecs.get(hotCodec)
+	if err != nil {
+		s.restoreBuffer(logsToCompress)
+		fmt.Println("❌ Flush failed, entries returned to buffer:", err)
+		return nil, err
+	}
+	var filename string
+	var segments []string
+	var written []segmentMark
+	var backfills []hourGroup
+	originalSize, compressedSize, late := 0, 0, 0
+	for _, group := range groups {
+		filename = segmentPath(s.dataDir, group.hour)
+		mark := markSegment(filename)
+		rawSize, chunkSize, err := s.appendChunk(filename, codec, group.logs, now)
+		if err != nil {
+			// 全部写入或全部不写：撤销已经写入其它小时的块，日志放回缓冲等下次刷盘重试；
+			// 这一批的 WAL 不删除，下次刷盘成功后才随之删除，期间崩溃重启时重放
+			rollbackSegments(append(written, mark))
+			s.restoreBuffer(logsToCompress)
+			fmt.Println("❌ Flush failed, entries returned to buffer:", err)
+			return nil, err
+		}
+		written = append(written, mark)
+		originalSize += int(rawSize)
+		compressedSize += chunkSize
+		segments = append(segments, filepath.Base(filename))
+		if group.hour < current {
+			late += len(group.logs)
+			backfills = append(backfills, group)
+		}
+	}
+	for _, group := range backfills {
+		fmt.Printf("⏪ [Backfill] %d late logs written to %s\n", len(group.logs), segmentKey(group.hour))
+	}
+	if walSeq > 0 {
+		s.wal.removeBefore(walSeq)
+	}
 	
 	// 4. 更新统计
-	s.stats.TotalCompressed += int64(len(logsToCompress))
-	originalSize := plainText.Len()
-	compressedSize := compressed.Len()
 	ratio := float64(originalSize) / float64(compressedSize)
+	s.bufferMu.Lock()
+	s.stats.LateEntries += int64(late)
+	s.stats.TotalCompressed += int64(len(logsToCompress))
 	s.stats.CompressionRatio = ratio
+	s.stats.Flushes++
+	s.stats.UncompressedBytes += int64(originalSize)
+	s.stats.CompressedBytes += int64(compressedSize)
+	s.repl.flushed = flushedSeq
+	s.repl.wake()
+	s.bufferMu.Unlock()
+	s.flushLatency.ObserveSince(start)
 	
 	fmt.Printf("💾 [Compressed] %d logs | %d B → %d B | Ratio %.1f:1 | File: %s\n",
 		len(logsToCompress), originalSize, compressedSize, ratio, filename)
+	
+	result := &FlushResult{
+		Entries:           len(logsToCompress),
+		Segment:           filepath.Base(filename),
+		UncompressedBytes: originalSize,
+		CompressedBytes:   compressedSize,
+		DurationMs:        float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if len(segments) > 1 {
+		result.Segments = segments
+	}
+	return result, nil
+}
+	
+// 刷盘失败：把取出的日志放回缓冲头部（保持顺序和复制序号），等下次刷盘
+func (s *LogStorage) restoreBuffer(logs []LogEntry) {
+	s.bufferMu.Lock()
+	defer s.bufferMu.Unlock()
+	s.memoryBuffer = append(logs, s.memoryBuffer...)
+	for _, log := range logs {
+		s.bufferBytes += entrySize(log)
+	}
+	s.repl.base -= int64(len(logs))
+}
+	
+// 把一组日志编码为一个块追加到段文件，并追加索引；返回压缩前大小和块大小
+func (s *LogStorage) appendChunk(filename string, codec Codec, logs []LogEntry, created time.Time) (int64, int, error) {
+	chunk, rawSize, err := encodeChunk(codec, logs, created)
+	if err != nil {
+		return 0, 0, err
+	}
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, 0, err
+	}
+	
+	var offset int64
+	if info, err := f.Stat(); err == nil {
+		offset = info.Size()
+	}
+	_, err = f.Write(chunk)
+	if err == nil {
+		// 刷盘成功后会删除对应的 WAL，块必须先落到磁盘
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	
+	// 索引写入失败不影响数据，查询时退回扫描整个段
+	if err := appendChunkIndex(filename, newChunkIndex(logs, codec.Name(), offset, int64(len(chunk)), rawSize)); err != nil {
+		fmt.Println("⚠️  Segment index write failed:", err)
+	}
+	return rawSize, len(chunk), nil
 }
 
-// 查询日志（内存 + 磁盘）支持多维度筛选
-func (s *LogStorage) Query(keyword, server, level string, limit int) []LogEntry {
+// 查询日志（内存 + 磁盘）支持多维度筛选；since / until 按时间戳字符串比较（为空不限制）；
+// access 限制调用方可见的服务器、级别和字段
+func (s *LogStorage) Query(keyword, server, level, since, until string, limit int, access *AccessPolicy) []LogEntry {
+	defer s.queryLatency.ObserveSince(time.Now())
 	results := make([]LogEntry, 0)
 	keywordLower := strings.ToLower(keyword)
 	serverLower := strings.ToLower(server)
-	levelLower := strings.ToLower(level)
+	levelCond := parseLevelFilter(level)
 	
-	// 1. 先查内存（最新的未压缩数据）
+	// 1. 先查内存（最新的未压缩数据）；缓冲按到达顺序排列，迟到的日志可能排在更新的日志后面，全部匹配后按时间排序
 	s.bufferMu.RLock()
-	for i := len(s.memoryBuffer) - 1; i >= 0 && len(results) < limit; i-- {
+	for i := len(s.memoryBuffer) - 1; i >= 0; i-- {
 		log := s.memoryBuffer[i]
-		if s.matchLogWithFilters(log, keywordLower, serverLower, levelLower) {
+		if !inTimeRange(log.Timestamp, since, until) || !access.AllowEntry(log) {
+			continue
+		}
+		// 先掩码再匹配，避免通过关键字探测被隐藏的字段
+		if log = access.Mask(log); s.matchLogWithFilters(log, keywordLower, serverLower, levelCond) {
 			results = append(results, log)
 		}
 	}
 	s.bufferMu.RUnlock()
+	results = newestFirst(results, limit)
 	
-	// 如果内存中已经够了，直接返回
-	if len(results) >= limit {
-		return results
-	}
-	
-	// 2. 再查磁盘（压缩的历史数据）
-	diskResults := s.queryDisk(keywordLower, serverLower, levelLower, limit-len(results))
-	results = append(results, diskResults...)
+	// 2. 再查磁盘（压缩的历史数据）和内存结果合并；内存中已经够了时，不比它们新的块不会解压
+	return s.queryDisk(keywordLower, serverLower, levelCond, since, until, limit, access, results)
+}
 	
-	return results
+// 按时间从新到旧排序（时间相同时保持原顺序），保留前 limit 条
+func newestFirst(logs []LogEntry, limit int) []LogEntry {
+	sort.SliceStable(logs, func(i, j int) bool { return logs[i].Timestamp > logs[j].Timestamp })
+	if len(logs) > limit {
+		logs = logs[:limit]
+	}
+	return logs
 }
 
 // 多维度匹配（支持关键字、服务器、级别筛选）
-func (s *LogStorage) matchLogWithFilters(log LogEntry, keyword, server, level string) bool {
+func (s *LogStorage) matchLogWithFilters(log LogEntry, keyword, server string, level levelFilter) bool {
 	// 关键字匹配
 	if keyword != "" {
 		matchKeyword := strings.Contains(strings.ToLower(log.Message), keyword) ||
 			strings.Contains(strings.ToLower(log.Level), keyword) ||
 			strings.Contains(strings.ToLower(log.Server), keyword)
+		for _, value := range log.Fields {
+			if matchKeyword {
+				break
+			}
+			matchKeyword = strings.Contains(strings.ToLower(value), keyword)
+		}
 		if !matchKeyword {
 			return false
 		}
@@ -184,81 +491,171 @@ func (s *LogStorage) matchLogWithFilters(log LogEntry, keyword, server, level st
 		return false
 	}
 	
-	// 级别匹配
-	if level != "" && strings.ToLower(log.Level) != level {
+	// 级别匹配（别名规范化，支持 >= / <= 比较）
+	if !level.match(log.Level) {
 		return false
 	}
 	
 	return true
 }
 
-func (s *LogStorage) queryDisk(keyword, server, level string, limit int) []LogEntry {
-	results := make([]LogEntry, 0)
-	
-	// 读取当前小时的压缩文件
-	hour := time.Now().Format("2006-01-02-15")
-	filename := fmt.Sprintf("%s/logs-%s.lz4", s.dataDir, hour)
+// 时间范围（包含两端）
+func inTimeRange(timestamp, since, until string) bool {
+	return (since == "" || timestamp >= since) && (until == "" || timestamp <= until)
+}
+
+// 在 results（已按时间从新到旧排序）的基础上合并磁盘中的日志
+func (s *LogStorage) queryDisk(keyword, server string, level levelFilter, since, until string, limit int, access *AccessPolicy, results []LogEntry) []LogEntry {
+	scanned := 0
+	defer func() {
+		s.bufferMu.Lock()
+		s.stats.ChunksScanned += int64(scanned)
+		s.bufferMu.Unlock()
+	}()
 	
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		return results
+	// 按小时从新到旧读取段（默认只读当前小时）；索引有效时只解压可能包含该服务器、级别和时间范围的块。
+	// 日志按自身时间写入所在小时的段，已经有 limit 条且都不早于这个小时的末尾时，更早的段不会有更新的日志
+	for _, seg := range s.querySegments(since, until) {
+		if end := seg.end(); end != "" && len(results) >= limit && results[limit-1].Timestamp >= end {
+			break
+		}
+		chunks := s.queryChunks(seg, server, level, since, until)
+		results = s.scanChunks(chunks, keyword, server, level, since, until, limit, access, results, &scanned)
 	}
 	
-	// 分块解压（按===CHUNK===分隔）
-	chunks := bytes.Split(data, []byte("===CHUNK_"))
-	
+	return results
+}
+
+// 在块中查找匹配的日志并合并到 results，保留最新的 limit 条。
+// 同一个段的块时间范围可能交错（迟到的日志追加在后面），按块的最新时间从新到旧读取，
+// 已经有 limit 条且都不早于块的最新时间时，剩下的块不用解压（没有索引的块时间未知，最先读取）
+func (s *LogStorage) scanChunks(chunks []rawChunk, keyword, server string, level levelFilter, since, until string, limit int, access *AccessPolicy, results []LogEntry, scanned *int) []LogEntry {
+	sort.SliceStable(chunks, func(i, j int) bool {
+		if (chunks[i].maxTime == "") != (chunks[j].maxTime == "") {
+			return chunks[i].maxTime == ""
+		}
+		return chunks[i].maxTime > chunks[j].maxTime
+	})
 	for _, chunk := range chunks {
-		if len(chunk) == 0 {
-			continue
+		if len(results) >= limit && chunk.maxTime != "" && chunk.maxTime <= results[limit-1].Timestamp {
+			break
 		}
+		*scanned++
 		
-		// 跳过时间戳行
-		idx := bytes.Index(chunk, []byte("\n"))
-		if idx == -1 {
-			continue
+		// 列式块先只解压时间、级别、服务器列；行式块（旧数据）整体解压
+		columns, logs, err := openChunk(s.codecs, chunk.data)
+		if err == nil && columns != nil {
+			logs, err = columns.headers()
 		}
-		chunk = chunk[idx+1:]
-		
-		// 解压
-		reader := lz4.NewReader(bytes.NewReader(chunk))
-		decompressed, err := io.ReadAll(reader)
 		if err != nil {
 			continue
 		}
 		
-		// 解析日志行
-		lines := strings.Split(string(decompressed), "\n")
-		for i := len(lines) - 1; i >= 0 && len(results) < limit; i-- {
-			line := lines[i]
-			if line == "" {
+		// 时间、服务器、级别和权限在小列上筛选出候选行（从新到旧）
+		candidates := make([]int, 0)
+		for i := len(logs) - 1; i >= 0; i-- {
+			log := logs[i]
+			if !inTimeRange(log.Timestamp, since, until) || (server != "" && strings.ToLower(log.Server) != server) ||
+				!level.match(log.Level) || !access.AllowEntry(log) {
 				continue
 			}
-			
-			// 解析日志
-			log := parseLogLine(line)
-			
-			// 多维度筛选
-			if s.matchLogWithFilters(log, keyword, server, level) {
-				results = append(results, log)
+			candidates = append(candidates, i)
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+		
+		// 有候选行时才解压消息和字段列
+		if columns != nil {
+			if err := columns.fill(logs, candidates); err != nil {
+				continue
 			}
 		}
 		
-		if len(results) >= limit {
-			break
+		// 关键字筛选（先掩码再匹配）
+		for _, i := range candidates {
+			if log := access.Mask(logs[i]); s.matchLogWithFilters(log, keyword, server, level) {
+				results = append(results, log)
+			}
 		}
+		results = newestFirst(results, limit)
 	}
 	
 	return results
 }
 
+// /metrics 使用的计数快照
+type storageMetrics struct {
+	received          int64
+	flushed           int64
+	flushes           int64
+	uncompressedBytes int64
+	compressedBytes   int64
+	ratio             float64
+	chunksScanned     int64
+	duplicates        int64
+	late              int64
+}
+
+func (s *LogStorage) metricsSnapshot() storageMetrics {
+	s.bufferMu.RLock()
+	defer s.bufferMu.RUnlock()
+	return storageMetrics{
+		received:          s.stats.TotalReceived,
+		flushed:           s.stats.TotalCompressed,
+		flushes:           s.stats.Flushes,
+		uncompressedBytes: s.stats.UncompressedBytes,
+		compressedBytes:   s.stats.CompressedBytes,
+		ratio:             s.stats.CompressionRatio,
+		chunksScanned:     s.stats.ChunksScanned,
+		duplicates:        s.stats.Duplicates,
+		late:              s.stats.LateEntries,
+	}
+}
+
+// 估算一条日志在内存中的大小（字符串内容 + 固定开销）
+func entrySize(log LogEntry) int64 {
+	size := len(log.Timestamp) + len(log.Level) + len(log.NormLevel) + len(log.Server) + len(log.Source) + len(log.Message) + 128
+	for k, v := range log.Fields {
+		size += len(k) + len(v) + 16
+	}
+	return int64(size)
+}
+
+// 磁盘行格式：JSON（监控指标单独存储，规范级别读取时重新计算）
+func formatLogLine(log LogEntry) []byte {
+	log.Metrics = nil
+	log.NormLevel = ""
+	line, _ := json.Marshal(log)
+	return line
+}
+
 func parseLogLine(line string) LogEntry {
-	// 简单解析 [时间] [级别] [服务器] 消息
-	// 生产环境应该更健壮
+	if strings.HasPrefix(line, "{") {
+		var log LogEntry
+		if err := json.Unmarshal([]byte(line), &log); err == nil {
+			log.NormLevel = normalizeLevel(log.Level)
+			return log
+		}
+	}
+	
+	// 旧格式：[时间] [级别] [服务器] 消息
+	level := extractBracket(line, 1)
+	message := line
+	if idx := strings.Index(line, "] ["); idx != -1 {
+		if end := strings.Index(line[idx+3:], "] ["); end != -1 {
+			rest := line[idx+3+end+3:]
+			if close := strings.Index(rest, "] "); close != -1 {
+				message = rest[close+2:]
+			}
+		}
+	}
 	return LogEntry{
 		Timestamp: extractBracket(line, 0),
-		Level:     extractBracket(line, 1),
+		Level:     level,
+		NormLevel: normalizeLevel(level),
 		Server:    extractBracket(line, 2),
-		Message:   line,
+		Message:   message,
 	}
 }
 
@@ -280,6 +677,9 @@ func extractBracket(s string, index int) string {
 
 // 获取统计信息
 func (s *LogStorage) GetStats() map[string]interface{} {
+	// 各编码的压缩率（读取段索引，不持有缓冲锁）
+	codecs := s.codecStats()
+	
 	s.bufferMu.RLock()
 	defer s.bufferMu.RUnlock()
 	
@@ -296,65 +696,184 @@ func (s *LogStorage) GetStats() map[string]interface{} {
 		serverList = append(serverList, server)
 	}
 	
-	return map[string]interface{}{
+	levelCounts := make(map[string]int64, len(s.stats.LevelCounts))
+	for level, count := range s.stats.LevelCounts {
+		levelCounts[level] = count
+	}
+	
+	stats := map[string]interface{}{
 		"total_received":    s.stats.TotalReceived,
 		"total_compressed":  s.stats.TotalCompressed,
 		"in_memory":         len(s.memoryBuffer),
 		"compression_ratio": fmt.Sprintf("%.1f:1", s.stats.CompressionRatio),
 		"servers":           serverList,
+		"level_counts":      levelCounts,
+		"codecs":            codecs,
+		"late_entries":      s.stats.LateEntries,
 	}
+	if s.dedup.cfg.Window > 0 {
+		stats["duplicates_suppressed"] = s.stats.Duplicates
+		stats["dedup_keys"] = s.dedup.size()
+	}
+	if tiers := s.tierStatsSnapshot(); tiers != nil {
+		stats["tiers"] = tiers
+	}
+	if s.cache != nil {
+		stats["cache"] = s.cache.stats()
+	}
+	return stats
 }
 
 func main() {
-	storage := NewLogStorage("data")
-	metricsStorage := NewMetricsStorage("data", 120) // 每台服务器保留120个数据点（1小时）
+	// 子命令：minilog backup -o FILE / minilog restore -i FILE -data-dir DIR
+	if len(os.Args) > 1 && (os.Args[1] == "backup" || os.Args[1] == "restore") {
+		run := runBackupCommand
+		if os.Args[1] == "restore" {
+			run = runRestoreCommand
+		}
+		if err := run(os.Args[2:]); err != nil {
+			fmt.Printf("❌ %s failed: %v\n", os.Args[1], err)
+			os.Exit(1)
+		}
+		return
+	}
 	
-	// API: 接收日志（实时写入内存）
-	http.HandleFunc("/api/logs", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "POST" {
-			http.Error(w, "只接受POST", http.StatusMethodNotAllowed)
-			return
+	loadServerConfig := registerConfigFlags(flag.CommandLine)
+	verifyAudit := flag.String("audit-verify", "", "离线校验审计目录（如 data/audit）的哈希链后退出")
+	flag.Parse()
+	
+	if *verifyAudit != "" {
+		count, head, err := VerifyAuditLog(*verifyAudit)
+		if err != nil {
+			fmt.Printf("❌ Audit log tampered or corrupt after %d valid events: %v\n", count, err)
+			os.Exit(1)
 		}
+		fmt.Printf("✅ Audit log intact: %d events, head %s\n", count, head)
+		return
+	}
+	
+	// 配置：默认值 < 配置文件 < MINILOG_* 环境变量 < 命令行参数
+	cfg, configPath, err := loadServerConfig()
+	if err != nil {
+		fmt.Println("❌ Invalid configuration:", err)
+		os.Exit(1)
+	}
+	if configPath != "" {
+		fmt.Println("⚙️  Config loaded from", configPath)
+	}
+	
+	// 审计日志：查询、管理操作、认证失败（data/audit，哈希链）
+	audit, err := NewAuditLog(cfg.DataDir)
+	if err != nil {
+		fmt.Println("❌ Cannot open audit log:", err)
+		os.Exit(1)
+	}
+	
+	// API Key 认证（首次启动自动创建管理员 key）
+	auth, err := NewAuthStore(cfg.DataDir)
+	if err != nil {
+		fmt.Println("❌ Cannot load API keys:", err)
+		os.Exit(1)
+	}
+	auth.Audit = audit
+	adminKey, err := auth.Bootstrap()
+	if err != nil {
+		fmt.Println("❌ Cannot create admin key:", err)
+		os.Exit(1)
+	}
+	if adminKey != "" {
+		fmt.Println("🔑 Admin API key (shown only once, store it safely):", adminKey)
+	}
+	
+	// 写入链路：多行合并 → 字段提取 → 丢弃/采样/限流 → 脱敏 → 存储
+	redactKey, err := loadRedactionKey(cfg.DataDir)
+	if err != nil {
+		fmt.Println("❌ Cannot load redaction key:", err)
+		os.Exit(1)
+	}
+	
+	// 多租户：每个租户独立的 LogStorage / MetricsStorage / 写入链路（data/tenants/<id>）
+	tenants, err := NewTenantManager(cfg, cfg.IngestConfig(redactKey), audit)
+	if err != nil {
+		fmt.Println("❌ Invalid ingest configuration:", err)
+		os.Exit(1)
+	}
+	
+	// 热加载：SIGHUP 或 POST /api/config/reload 重新读取配置
+	configs := NewConfigManager(cfg, loadServerConfig, tenants, redactKey, audit)
+	
+	// 主从复制：配置了 replication.leader 时作为只读 follower 同步 leader 的数据
+	replication := NewReplicationManager(cfg.Replication, tenants, cfg.DataDir)
+	
+	// 集群分片：日志按服务器（或租户）分配到节点，查询分发到所有节点后合并
+	cluster := NewCluster(cfg.Cluster)
+	
+	// API: 接收日志（实时写入内存）
+	http.HandleFunc("/api/logs", auth.Require(ScopeIngest, tenants.Resolve(handleIngest(cfg, replication, cluster))))
+	
+	// API: 查询日志（内存+磁盘，支持多维度筛选）
+	http.HandleFunc("/api/query", auth.Require(ScopeQuery, tenants.Resolve(func(w http.ResponseWriter, r *http.Request) {
+		tenant := tenantFrom(r)
+		start := time.Now()
+		keyword := r.URL.Query().Get("keyword")
+		server := r.URL.Query().Get("server")
+		level := r.URL.Query().Get("level")
+		since := r.URL.Query().Get("since")
+		until := r.URL.Query().Get("until")
 		
-		body, _ := io.ReadAll(r.Body)
-		var log LogEntry
+		// 兼容直接写在 URL 里的比较表达式：?level>=WARN / ?level>WARN（严格比较不会变成 >=）
+		if expr, ok := levelFromRawQuery(r.URL.RawQuery); ok {
+			level = expr
+		}
 		
-		if err := json.Unmarshal(body, &log); err != nil {
-			log = LogEntry{
-				Timestamp: time.Now().Format("2006-01-02 15:04:05"),
-				Message:   string(body),
+		// 默认返回最新的1000条（内存+磁盘）
+		limit := 1000
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 10000 {
+				http.Error(w, "limit 需要 1-10000 之间的整数", http.StatusBadRequest)
+				return
 			}
+			limit = n
 		}
 		
-		if log.Timestamp == "" {
-			log.Timestamp = time.Now().Format("2006-01-02 15:04:05")
+		// 集群：分发到所有节点后按时间合并（scope=local 只查本节点，协调者分发时使用）
+		details := map[string]string{"target": "logs", "keyword": keyword, "server": server, "level": level, "since": since, "until": until}
+		var results []LogEntry
+		if cluster.Enabled() && r.URL.Query().Get("scope") != "local" {
+			gathered := cluster.Query(r.Context(), tenant, queryParams{keyword, server, level, since, until, limit}, accessFrom(r))
+			gathered.setHeaders(w)
+			results = gathered.Entries
+			details["nodes"] = strconv.Itoa(gathered.Nodes)
+			if len(gathered.Failed) > 0 {
+				details["partial"] = "true"
+				details["failed"] = w.Header().Get("X-MiniLog-Failed-Nodes")
+			}
+		} else {
+			results = tenant.Logs.Query(keyword, server, level, since, until, limit, accessFrom(r))
 		}
 		
-		// 实时追加日志到内存
-		storage.Append(log)
-		
-		// 如果包含监控指标，存储到 metricsStorage
-		// 任何带 server 的日志都会更新服务器状态（基于最后推送时间）
-		if log.Metrics != nil && log.Server != "" {
-			metricsEntry := MetricsEntry{
-				Timestamp: log.Timestamp,
-				Server:    log.Server,
-				Metrics:   *log.Metrics,
+		// 审计：谁查了什么、覆盖的时间范围、结果条数和耗时
+		for _, log := range results {
+			if details["from"] == "" || log.Timestamp < details["from"] {
+				details["from"] = log.Timestamp
+			}
+			if log.Timestamp > details["to"] {
+				details["to"] = log.Timestamp
 			}
-			metricsStorage.Append(metricsEntry)
 		}
+		audit.RecordRequest(r, AuditQuery, http.StatusOK, AuditEvent{
+			Details:    details,
+			Results:    len(results),
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+		})
 		
-		fmt.Fprintf(w, "✓ Received")
-	})
-	
-	// API: 查询日志（内存+磁盘，支持多维度筛选）
-	http.HandleFunc("/api/query", func(w http.ResponseWriter, r *http.Request) {
-		keyword := r.URL.Query().Get("keyword")
-		server := r.URL.Query().Get("server")
-		level := r.URL.Query().Get("level")
-		
-		// 查询最新的1000条（内存+磁盘）
-		results := storage.Query(keyword, server, level, 1000)
+		// format=json 返回完整结构（包含提取出的字段）
+		if r.URL.Query().Get("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(results)
+			return
+		}
 		
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		
@@ -363,29 +882,63 @@ func main() {
 			fmt.Fprintf(w, "[%s] [%s] [%s] %s\n",
 				log.Timestamp, log.Level, log.Server, log.Message)
 		}
-	})
+	})))
 	
 	// API: 统计信息
-	http.HandleFunc("/api/stats", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/api/stats", auth.Require(ScopeQuery, tenants.Resolve(func(w http.ResponseWriter, r *http.Request) {
+		tenant := tenantFrom(r)
 		w.Header().Set("Content-Type", "application/json")
 		
 		// 合并日志和监控统计
-		logStats := storage.GetStats()
-		metricsStats := metricsStorage.GetStats()
+		logStats := tenant.Logs.GetStats()
+		metricsStats := tenant.Metrics.GetStats()
+		
+		// 受角色限制的 key 只返回可见的服务器列表：级别计数、写入总量、编码和分层统计
+		// 都包含其它服务器的日志，没法按角色拆分，直接省略
+		if access := accessFrom(r); access != nil {
+			visible := make([]string, 0)
+			for _, server := range logStats["servers"].([]string) {
+				if access.AllowServer(server) {
+					visible = append(visible, server)
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"tenant":        tenant.ID,
+				"servers":       visible,
+				"total_servers": len(visible),
+			})
+			return
+		}
 		
 		combined := make(map[string]interface{})
 		for k, v := range logStats {
 			combined[k] = v
 		}
+		for k, v := range tenant.Ingester.GetStats() {
+			combined[k] = v
+		}
+		for k, v := range tenant.GetStats() {
+			combined[k] = v
+		}
 		for k, v := range metricsStats {
 			combined[k] = v
 		}
+		if principal := principalFrom(r); principal != nil && principal.isAdmin() {
+			for k, v := range audit.GetStats() {
+				combined[k] = v
+			}
+			for k, v := range configs.GetStats() {
+				combined[k] = v
+			}
+		}
 		
 		json.NewEncoder(w).Encode(combined)
-	})
+	})))
 	
 	// API: 查询监控数据
-	http.HandleFunc("/api/metrics", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/api/metrics", auth.Require(ScopeQuery, tenants.Resolve(func(w http.ResponseWriter, r *http.Request) {
+		tenant := tenantFrom(r)
+		start := time.Now()
 		server := r.URL.Query().Get("server")
 		metricName := r.URL.Query().Get("metric")
 		
@@ -394,56 +947,180 @@ func main() {
 		
 		w.Header().Set("Content-Type", "application/json")
 		
+		var results []MetricsEntry
 		if server == "" {
 			// 返回所有服务器的最新数据
-			results := metricsStorage.Query("", metricName, 1)
-			json.NewEncoder(w).Encode(results)
+			results = tenant.Metrics.Query("", metricName, 1, accessFrom(r))
 		} else {
 			// 返回指定服务器的时序数据
-			results := metricsStorage.Query(server, metricName, limit)
-			json.NewEncoder(w).Encode(results)
+			results = tenant.Metrics.Query(server, metricName, limit, accessFrom(r))
 		}
-	})
+		audit.RecordRequest(r, AuditQuery, http.StatusOK, AuditEvent{
+			Details:    map[string]string{"target": "metrics", "server": server, "metric": metricName},
+			Results:    len(results),
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+		})
+		json.NewEncoder(w).Encode(results)
+	})))
 	
 	// API: 服务器状态
-	http.HandleFunc("/api/servers", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/api/servers", auth.Require(ScopeQuery, tenants.Resolve(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		tenant := tenantFrom(r)
 		w.Header().Set("Content-Type", "application/json")
-		servers := metricsStorage.GetServerStatus()
+		servers := tenant.Metrics.GetServerStatus(accessFrom(r))
+		audit.RecordRequest(r, AuditQuery, http.StatusOK, AuditEvent{
+			Details:    map[string]string{"target": "servers"},
+			Results:    len(servers),
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+		})
 		json.NewEncoder(w).Encode(servers)
-	})
+	})))
 	
 	// API: 服务器聚合统计
-	http.HandleFunc("/api/metrics/summary", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/api/metrics/summary", auth.Require(ScopeQuery, tenants.Resolve(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		tenant := tenantFrom(r)
 		server := r.URL.Query().Get("server")
 		
 		w.Header().Set("Content-Type", "application/json")
 		
 		if server == "" {
 			// 返回所有服务器的摘要
-			servers := metricsStorage.GetServerStatus()
+			servers := tenant.Metrics.GetServerStatus(accessFrom(r))
 			summaries := make([]map[string]interface{}, 0)
 			for _, s := range servers {
-				if summary := metricsStorage.GetAggregatedStats(s.Server); summary != nil {
+				if summary := tenant.Metrics.GetAggregatedStats(s.Server, accessFrom(r)); summary != nil {
 					summaries = append(summaries, summary)
 				}
 			}
+			audit.RecordRequest(r, AuditQuery, http.StatusOK, AuditEvent{
+				Details:    map[string]string{"target": "metrics_summary"},
+				Results:    len(summaries),
+				DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+			})
 			json.NewEncoder(w).Encode(summaries)
 		} else {
 			// 返回指定服务器的摘要
-			summary := metricsStorage.GetAggregatedStats(server)
+			summary := tenant.Metrics.GetAggregatedStats(server, accessFrom(r))
+			results := 0
+			if summary != nil {
+				results = 1
+			}
+			audit.RecordRequest(r, AuditQuery, http.StatusOK, AuditEvent{
+				Details:    map[string]string{"target": "metrics_summary", "server": server},
+				Results:    results,
+				DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+			})
 			json.NewEncoder(w).Encode(summary)
 		}
-	})
+	})))
+	
+	// API: Key 管理（仅管理员）
+	http.HandleFunc("/api/keys", auth.Require(ScopeAdmin, audit.Admin(auth.handleKeys)))
+	
+	// API: 角色管理（仅管理员）
+	http.HandleFunc("/api/roles", auth.Require(ScopeAdmin, audit.Admin(auth.Roles.handleRoles)))
+	
+	// API: 租户列表与配额（仅管理员）
+	http.HandleFunc("/api/tenants", auth.Require(ScopeAdmin, audit.Admin(tenants.handleTenants)))
+	
+	// API: 当前生效的配置和热加载（仅管理员，隐藏密钥）
+	http.HandleFunc("/api/config", auth.Require(ScopeAdmin, configs.handleConfig))
+	http.HandleFunc("/api/config/reload", auth.Require(ScopeAdmin, configs.handleReload))
+	
+	// API: 审计记录（仅管理员）
+	http.HandleFunc("/api/audit", auth.Require(ScopeAdmin, audit.handleAudit))
+	
+	// Prometheus 指标（MiniLog 自身的写入、缓冲、刷盘、查询和运行时状态）
+	http.HandleFunc("/metrics", auth.Require(ScopeQuery, handlePrometheus(tenants, replication)))
+	
+	// API: 存储运维（仅管理员，X-Tenant 选择租户）：刷盘、段列表与删除、压缩、重建索引、暂停写入
+	http.HandleFunc("/api/admin/", auth.Require(ScopeAdmin, audit.Admin(tenants.Resolve(handleStorageAdmin))))
+	
+	// API: 备份整个实例（tar 流，minilog backup 使用）
+	http.HandleFunc("/api/backup", auth.Require(ScopeAdmin, audit.Admin(tenants.handleBackup)))
+	
+	// API: 主从复制（仅管理员）：follower 拉取日志和段、查看延迟；提升 follower 记录审计
+	http.HandleFunc("/api/replication/", auth.Require(ScopeAdmin, tenants.Resolve(replication.handleReplication)))
+	http.HandleFunc("/api/replication/promote", auth.Require(ScopeAdmin, audit.Admin(replication.handlePromote)))
+	http.HandleFunc("/api/cluster", auth.Require(ScopeAdmin, cluster.handleCluster))
+	
+	// 健康检查（不需要认证）：/healthz 存活，/readyz 就绪
+	health := NewHealthChecker(configs, tenants)
+	http.HandleFunc("/healthz", health.handleHealthz)
+	http.HandleFunc("/readyz", health.handleReadyz)
 	
 	// 静态文件服务（前端页面）
 	http.Handle("/", http.FileServer(http.Dir("static")))
 	
 	fmt.Println("🚀 MiniLog Lightweight Monitoring Version Started!")
-	fmt.Println("📊 Web UI: http://localhost:8080")
-	fmt.Println("📡 Receive Logs: POST http://localhost:8080/api/logs")
+	fmt.Printf("📊 Web UI: http://localhost%s\n", cfg.Addr)
+	fmt.Printf("📡 Receive Logs: POST http://localhost%s/api/logs\n", cfg.Addr)
 	fmt.Println("📈 Lightweight Metrics: CPU, Memory, Disk, Load (~50 bytes per push)")
-	fmt.Println("💾 Smart Compression: Triggers at 1000 logs or 1 minute")
+	fmt.Printf("💾 Smart Compression: Triggers at %d logs, %s or %s\n",
+		cfg.Storage.BufferSize, cfg.Storage.BufferMemory, cfg.Storage.FlushInterval)
 	fmt.Println("🔍 Query Strategy: Memory first → Disk fallback")
 	fmt.Println("📉 Monitoring: No heartbeat, status based on log push time")
-	http.ListenAndServe(":8080", nil)
+	
+	// HTTPS：证书在 SIGHUP 时重新加载，不中断已有连接
+	var certs *certReloader
+	if cfg.TLS.Enabled() {
+		certs, err = newCertReloader(cfg.TLS)
+		if err != nil {
+			fmt.Println("❌ Invalid TLS configuration:", err)
+			os.Exit(1)
+		}
+	}
+	
+	// SIGHUP：重新加载配置（以及 TLS 证书），内存缓冲和连接不受影响
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			configs.ReloadFromSignal()
+			if certs == nil {
+				continue
+			}
+			if err := certs.Reload(); err != nil {
+				fmt.Println("⚠️  TLS reload failed, keeping old certificate:", err)
+			} else {
+				fmt.Println("🔐 TLS certificate reloaded")
+			}
+		}
+	}()
+	
+	// SIGINT / SIGTERM：输出拼装中的多行事件并刷盘后退出
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-term
+		fmt.Println("🛑 Shutting down, flushing buffers...")
+		tenants.Shutdown()
+		os.Exit(0)
+	}()
+	
+	if certs == nil {
+		if err := http.ListenAndServe(cfg.Addr, nil); err != nil {
+			fmt.Println("❌ Server stopped:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	
+	fmt.Printf("🔐 TLS enabled (client certificates: %t)\n", cfg.TLS.ClientCA != "")
+	server := &http.Server{Addr: cfg.Addr, TLSConfig: certs.TLSConfig()}
+	if err := server.ListenAndServeTLS("", ""); err != nil {
+		fmt.Println("❌ Server stopped:", err)
+		os.Exit(1)
+	}
+}
+
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
\ No newline at end of file
@@ -5,6 +5,7 @@ import (
 	"os"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -43,8 +44,8 @@ type MetricsStorage struct {
 	statusMu     sync.RWMutex
 	
 	// 配置
-	maxPointsPerServer int           // 每台服务器最多保留多少个数据点
-	offlineThreshold   time.Duration // 多久未收到数据算离线
+	maxPointsPerServer int          // 每台服务器最多保留多少个数据点
+	offlineThreshold   atomic.Int64 // 多久未收到数据算离线（time.Duration，热加载时原子替换）
 	dataDir            string
 	
 	// 统计
@@ -55,14 +56,14 @@ type MetricsStorage struct {
 }
 
 // 创建监控存储引擎
-func NewMetricsStorage(dataDir string, maxPoints int) *MetricsStorage {
+func NewMetricsStorage(dataDir string, cfg MetricsConfig) *MetricsStorage {
 	storage := &MetricsStorage{
 		recentMetrics:      make(map[string][]MetricsEntry),
 		serverStatus:       make(map[string]*ServerStatus),
-		maxPointsPerServer: maxPoints,
-		offlineThreshold:   90 * time.Second, // 90秒未推送视为离线
+		maxPointsPerServer: cfg.MaxPoints, // 默认120个点（1小时）
 		dataDir:            dataDir,
 	}
+	storage.offlineThreshold.Store(int64(cfg.OfflineThreshold)) // 默认90秒未推送视为离线
 	
 	// 启动后台任务：定期持久化数据（每小时）
 	go storage.persistMetrics()
@@ -118,23 +119,29 @@ func (m *MetricsStorage) updateServerStatus(server string, entry MetricsEntry) {
 	}
 }
 
-// 计算服务器状态（按需调用，无后台任务）
-func (m *MetricsStorage) calculateServerStatus(server string) string {
-	m.statusMu.RLock()
-	status, exists := m.serverStatus[server]
-	m.statusMu.RUnlock()
-	
-	if !exists {
-		return "unknown"
-	}
-	
+// 热加载：更新保留点数和离线阈值
+func (m *MetricsStorage) Reconfigure(cfg MetricsConfig) {
+	m.metricsMu.Lock()
+	m.maxPointsPerServer = cfg.MaxPoints
+	m.metricsMu.Unlock()
+	m.offlineThreshold.Store(int64(cfg.OfflineThreshold))
+}
+
+// 离线阈值不走 metricsMu：Append 持有 metricsMu 再拿 statusMu，
+// 这里如果在 statusMu 下再拿 metricsMu 就会锁序颠倒
+func (m *MetricsStorage) getOfflineThreshold() time.Duration {
+	return time.Duration(m.offlineThreshold.Load())
+}
+
+// 计算服务器状态（按需调用，无后台任务；调用方持有 statusMu）
+func calculateServerStatus(status *ServerStatus, threshold time.Duration) string {
 	lastSeen, err := time.Parse("2006-01-02 15:04:05", status.LastSeen)
 	if err != nil {
 		return "unknown"
 	}
 	
 	elapsed := time.Since(lastSeen)
-	if elapsed > m.offlineThreshold {
+	if elapsed > threshold {
 		return "offline"
 	} else if elapsed > 60*time.Second {
 		return "timeout"
@@ -143,16 +150,16 @@ func (m *MetricsStorage) calculateServerStatus(server string) string {
 	return "online"
 }
 
-// 查询指定服务器的监控数据
-func (m *MetricsStorage) Query(server string, metricName string, limit int) []MetricsEntry {
+// 查询指定服务器的监控数据（access 限制可见的服务器）
+func (m *MetricsStorage) Query(server string, metricName string, limit int, access *AccessPolicy) []MetricsEntry {
 	m.metricsMu.RLock()
 	defer m.metricsMu.RUnlock()
 	
 	if server == "" {
 		// 返回所有服务器的最新数据点
 		result := make([]MetricsEntry, 0)
-		for _, entries := range m.recentMetrics {
-			if len(entries) > 0 {
+		for name, entries := range m.recentMetrics {
+			if len(entries) > 0 && access.AllowServer(name) {
 				result = append(result, entries[len(entries)-1])
 			}
 		}
@@ -160,7 +167,7 @@ func (m *MetricsStorage) Query(server string, metricName string, limit int) []Me
 	}
 	
 	entries, exists := m.recentMetrics[server]
-	if !exists || len(entries) == 0 {
+	if !exists || len(entries) == 0 || !access.AllowServer(server) {
 		return []MetricsEntry{}
 	}
 	
@@ -177,14 +184,19 @@ func (m *MetricsStorage) Query(server string, metricName string, limit int) []Me
 }
 
 // 获取所有服务器状态（实时计算状态）
-func (m *MetricsStorage) GetServerStatus() []ServerStatus {
+func (m *MetricsStorage) GetServerStatus(access *AccessPolicy) []ServerStatus {
+	threshold := m.getOfflineThreshold()
 	m.statusMu.RLock()
 	defer m.statusMu.RUnlock()
 	
 	result := make([]ServerStatus, 0, len(m.serverStatus))
 	for server, status := range m.serverStatus {
+		if !access.AllowServer(server) {
+			continue
+		}
+		
 		// 实时计算状态
-		currentStatus := m.calculateServerStatus(server)
+		currentStatus := calculateServerStatus(status, threshold)
 		
 		statusCopy := *status
 		statusCopy.Status = currentStatus
@@ -266,12 +278,12 @@ func (m *MetricsStorage) persistMetrics() {
 }
 
 // 获取指定服务器的聚合统计
-func (m *MetricsStorage) GetAggregatedStats(server string) map[string]interface{} {
+func (m *MetricsStorage) GetAggregatedStats(server string, access *AccessPolicy) map[string]interface{} {
 	m.metricsMu.RLock()
 	defer m.metricsMu.RUnlock()
 	
 	entries, exists := m.recentMetrics[server]
-	if !exists || len(entries) == 0 {
+	if !exists || len(entries) == 0 || !access.AllowServer(server) {
 		return nil
 	}
 	
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Append（metricsMu → statusMu）与 GetServerStatus / Reconfigure 并发时不能死锁
+func TestConcurrentAppendAndServerStatus(t *testing.T) {
+	m := NewMetricsStorage(t.TempDir(), MetricsConfig{MaxPoints: 10, OfflineThreshold: 90 * time.Second})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for w := 0; w < 4; w++ {
+			wg.Add(3)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < 500; i++ {
+					m.Append(MetricsEntry{Server: fmt.Sprintf("web-%d", i%5), Metrics: Metrics{CPUPercent: float64(w)}})
+				}
+			}(w)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 500; i++ {
+					m.GetServerStatus(nil)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 100; i++ {
+					m.Reconfigure(MetricsConfig{MaxPoints: 10, OfflineThreshold: time.Duration(60+i) * time.Second})
+				}
+			}()
+		}
+		wg.Wait()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Append / GetServerStatus deadlocked")
+	}
+
+	statuses := m.GetServerStatus(nil)
+	if len(statuses) != 5 {
+		t.Fatalf("got %d servers, want 5", len(statuses))
+	}
+	for _, s := range statuses {
+		if s.Status != "online" {
+			t.Errorf("%s status = %q, want online", s.Server, s.Status)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// 多行合并规则（按服务器 / 来源匹配，空表示任意）
+type MultilineRule struct {
+	Server              string        `yaml:"server,omitempty"`
+	Source              string        `yaml:"source,omitempty"`
+	StartPattern        string        `yaml:"start_pattern,omitempty"`        // 事件起始行：不匹配的行视为续行
+	ContinuationPattern string        `yaml:"continuation_pattern,omitempty"` // 续行：匹配的行合并到上一条
+	Timeout             time.Duration `yaml:"timeout,omitempty"`              // 超过该时间没有新续行就输出
+	MaxLines            int           `yaml:"max_lines,omitempty"`            // 单个事件最多合并多少行
+
+	start        *regexp.Regexp
+	continuation *regexp.Regexp
+}
+
+func (r *MultilineRule) compile() error {
+	if r.StartPattern == "" && r.ContinuationPattern == "" {
+		return fmt.Errorf("multiline rule (server=%q source=%q) 需要 start_pattern 或 continuation_pattern", r.Server, r.Source)
+	}
+	var err error
+	if r.StartPattern != "" {
+		if r.start, err = regexp.Compile(r.StartPattern); err != nil {
+			return fmt.Errorf("start_pattern: %w", err)
+		}
+	}
+	if r.ContinuationPattern != "" {
+		if r.continuation, err = regexp.Compile(r.ContinuationPattern); err != nil {
+			return fmt.Errorf("continuation_pattern: %w", err)
+		}
+	}
+	if r.Timeout <= 0 {
+		r.Timeout = 2 * time.Second
+	}
+	if r.MaxLines <= 0 {
+		r.MaxLines = 500
+	}
+	return nil
+}
+
+func (r *MultilineRule) matches(entry LogEntry) bool {
+	return (r.Server == "" || r.Server == entry.Server) &&
+		(r.Source == "" || r.Source == entry.Source)
+}
+
+// 判断一行是否为上一条事件的续行
+func (r *MultilineRule) isContinuation(line string) bool {
+	if r.continuation != nil && r.continuation.MatchString(line) {
+		return true
+	}
+	if r.start != nil && !r.start.MatchString(line) {
+		return true
+	}
+	return false
+}
+
+// 正在拼装中的事件
+type pendingEvent struct {
+	entry    LogEntry
+	rule     *MultilineRule
+	lines    int
+	lastSeen time.Time
+}
+
+// 多行聚合器：在写入 LogStorage 之前把续行合并到前一条日志
+type MultilineAggregator struct {
+	rules   []*MultilineRule
+	pending map[string]*pendingEvent // server + source -> 拼装中的事件
+	mu      sync.Mutex
+
+	sink func(LogEntry) // 合并完成后的输出（通常是 LogStorage.Append）
+
+	stop   chan struct{}
+	closed bool
+
+	stats struct {
+		EventsMerged int64 // 产生的多行事件数
+		LinesMerged  int64 // 被合并掉的续行数
+	}
+}
+
+func NewMultilineAggregator(rules []MultilineRule, sink func(LogEntry)) (*MultilineAggregator, error) {
+	agg := &MultilineAggregator{
+		pending: make(map[string]*pendingEvent),
+		sink:    sink,
+		stop:    make(chan struct{}),
+	}
+
+	tick := time.Second
+	for i := range rules {
+		rule := rules[i]
+		if err := rule.compile(); err != nil {
+			return nil, err
+		}
+		agg.rules = append(agg.rules, &rule)
+		if rule.Timeout/2 < tick {
+			tick = rule.Timeout / 2
+		}
+	}
+	if tick < 100*time.Millisecond {
+		tick = 100 * time.Millisecond
+	}
+
+	// 后台定时输出超时的事件
+	if len(agg.rules) > 0 {
+		go agg.timeoutFlusher(tick)
+	}
+
+	return agg, nil
+}
+
+// 接收一条日志：续行合并，否则输出上一条事件并开始新事件
+func (a *MultilineAggregator) Add(entry LogEntry) {
+	rule := a.ruleFor(entry)
+	if rule == nil {
+		a.sink(entry)
+		return
+	}
+
+	key := entry.Server + "\x00" + entry.Source
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// 已关闭（热加载替换）时直接输出
+	if a.closed {
+		a.sink(entry)
+		return
+	}
+
+	p, exists := a.pending[key]
+	if exists && rule.isContinuation(entry.Message) {
+		p.entry.Message += "\n" + entry.Message
+		p.lines++
+		p.lastSeen = time.Now()
+		a.stats.LinesMerged++
+		if p.lines == 2 {
+			a.stats.EventsMerged++
+		}
+
+		// 超过最大行数，强制输出
+		if p.lines >= rule.MaxLines {
+			delete(a.pending, key)
+			a.sink(p.entry)
+		}
+		return
+	}
+
+	if exists {
+		a.sink(p.entry)
+	}
+	a.pending[key] = &pendingEvent{
+		entry:    entry,
+		rule:     rule,
+		lines:    1,
+		lastSeen: time.Now(),
+	}
+}
+
+func (a *MultilineAggregator) ruleFor(entry LogEntry) *MultilineRule {
+	for _, rule := range a.rules {
+		if rule.matches(entry) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// 输出所有等待超时的事件
+func (a *MultilineAggregator) timeoutFlusher(tick time.Duration) {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.flushExpired(time.Now())
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// 停止后台任务并输出所有拼装中的事件
+func (a *MultilineAggregator) Close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return
+	}
+	a.closed = true
+	close(a.stop)
+	for key, p := range a.pending {
+		delete(a.pending, key)
+		a.sink(p.entry)
+	}
+}
+
+func (a *MultilineAggregator) flushExpired(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for key, p := range a.pending {
+		if now.Sub(p.lastSeen) >= p.rule.Timeout {
+			delete(a.pending, key)
+			a.sink(p.entry)
+		}
+	}
+}
+
+func (a *MultilineAggregator) GetStats() map[string]interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return map[string]interface{}{
+		"multiline_events":  a.stats.EventsMerged,
+		"multiline_lines":   a.stats.LinesMerged,
+		"multiline_pending": len(a.pending),
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 收集聚合器输出的日志
+type collector struct {
+	mu      sync.Mutex
+	entries []LogEntry
+}
+
+func (c *collector) add(entry LogEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = append(c.entries, entry)
+}
+
+func (c *collector) messages() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := make([]string, len(c.entries))
+	for i, entry := range c.entries {
+		result[i] = entry.Message
+	}
+	return result
+}
+
+func newTestAggregator(t *testing.T, rules []MultilineRule) (*MultilineAggregator, *collector) {
+	t.Helper()
+	out := &collector{}
+	agg, err := NewMultilineAggregator(rules, out.add)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(agg.Close)
+	return agg, out
+}
+
+func TestMultilineNoRulesPassesThrough(t *testing.T) {
+	agg, out := newTestAggregator(t, nil)
+	agg.Add(LogEntry{Server: "web", Message: "error"})
+	agg.Add(LogEntry{Server: "web", Message: "\tat com.foo.Bar"})
+	if got := out.messages(); len(got) != 2 {
+		t.Fatalf("without rules every line is its own entry, got %q", got)
+	}
+}
+
+func TestMultilineContinuation(t *testing.T) {
+	agg, out := newTestAggregator(t, []MultilineRule{{
+		Server:              "app",
+		ContinuationPattern: `^\s+\S`,
+		Timeout:             time.Hour,
+	}})
+	agg.Add(LogEntry{Server: "app", Message: "java.lang.NullPointerException"})
+	agg.Add(LogEntry{Server: "app", Message: "\tat com.foo.Bar(Bar.java:10)"})
+	agg.Add(LogEntry{Server: "other", Message: "  indented but not scoped"})
+	agg.Add(LogEntry{Server: "app", Message: "\tat com.foo.Main(Main.java:3)"})
+	agg.Add(LogEntry{Server: "app", Message: "next event"})
+
+	got := out.messages()
+	want := []string{
+		"  indented but not scoped",
+		"java.lang.NullPointerException\n\tat com.foo.Bar(Bar.java:10)\n\tat com.foo.Main(Main.java:3)",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	// Close 输出拼装中的事件
+	agg.Close()
+	if got := out.messages(); got[len(got)-1] != "next event" {
+		t.Fatalf("pending event not flushed on close: %q", got)
+	}
+	// 关闭后直接输出
+	agg.Add(LogEntry{Server: "app", Message: "\tafter close"})
+	if got := out.messages(); got[len(got)-1] != "\tafter close" {
+		t.Fatalf("entry after close not passed through: %q", got)
+	}
+}
+
+func TestMultilineStartPatternAndMaxLines(t *testing.T) {
+	agg, out := newTestAggregator(t, []MultilineRule{{
+		StartPattern: `^\d{4}-`,
+		Timeout:      time.Hour,
+		MaxLines:     3,
+	}})
+	for _, line := range []string{"2024-01-01 a", "b", "c", "d", "2024-01-02 e"} {
+		agg.Add(LogEntry{Message: line})
+	}
+	got := out.messages()
+	// 达到 max_lines 时立即输出，之后的续行没有可合并的事件，作为新事件开始
+	want := []string{"2024-01-01 a\nb\nc", "d"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestMultilineTimeout(t *testing.T) {
+	agg, out := newTestAggregator(t, []MultilineRule{{
+		ContinuationPattern: `^\s`,
+		Timeout:             time.Second,
+	}})
+	agg.Add(LogEntry{Message: "event"})
+	agg.flushExpired(time.Now())
+	if len(out.messages()) != 0 {
+		t.Fatal("event emitted before timeout")
+	}
+	agg.flushExpired(time.Now().Add(2 * time.Second))
+	if got := out.messages(); len(got) != 1 || got[0] != "event" {
+		t.Fatalf("event not emitted after timeout: %q", got)
+	}
+}
+
+func TestMultilineRuleNeedsPattern(t *testing.T) {
+	if _, err := NewMultilineAggregator([]MultilineRule{{Server: "x"}}, func(LogEntry) {}); err == nil {
+		t.Fatal("rule without patterns accepted")
+	}
+}
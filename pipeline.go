@@ -0,0 +1,492 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 处理器配置（按 Type 使用不同字段）
+type ProcessorConfig struct {
+	Type      string            `yaml:"type,omitempty"`      // grok / regex / json / kv / rename / drop / level
+	Field     string            `yaml:"field,omitempty"`     // 输入字段（默认 message）
+	Pattern   string            `yaml:"pattern,omitempty"`   // grok / regex 模式
+	Prefix    string            `yaml:"prefix,omitempty"`    // json / kv 提取结果的字段前缀
+	Separator string            `yaml:"separator,omitempty"` // kv：键值对之间的分隔符（默认空白）
+	Delimiter string            `yaml:"delimiter,omitempty"` // kv：键和值之间的分隔符（默认 "="）
+	Rename    map[string]string `yaml:"rename,omitempty"`    // rename：旧字段 -> 新字段
+	Fields    []string          `yaml:"fields,omitempty"`    // drop：要删除的字段
+	Mapping   map[string]string `yaml:"mapping,omitempty"`   // level：原值 -> 级别（支持 5xx 这种前缀写法）
+}
+
+// 管道配置：按服务器 / 来源匹配（空表示任意），命中的第一条管道生效
+type PipelineConfig struct {
+	Name       string            `yaml:"name,omitempty"`
+	Server     string            `yaml:"server,omitempty"`
+	Source     string            `yaml:"source,omitempty"`
+	Patterns   map[string]string `yaml:"patterns,omitempty"` // 自定义 grok 模式
+	Processors []ProcessorConfig `yaml:"processors,omitempty"`
+}
+
+// 默认管道：nginx 访问日志、postgres 日志
+func defaultPipelines() []PipelineConfig {
+	return []PipelineConfig{
+		{
+			Name:   "nginx",
+			Source: "nginx",
+			Processors: []ProcessorConfig{
+				{Type: "grok", Pattern: "%{NGINXACCESS}"},
+				{Type: "level", Field: "status", Mapping: map[string]string{"5xx": "ERROR", "4xx": "WARN", "3xx": "INFO", "2xx": "INFO"}},
+			},
+		},
+		{
+			Name:   "postgres",
+			Source: "postgres",
+			Processors: []ProcessorConfig{
+				{Type: "grok", Pattern: "%{POSTGRESQL}"},
+				{Type: "level", Field: "pg_level", Mapping: map[string]string{"LOG": "INFO", "STATEMENT": "INFO", "DETAIL": "INFO", "HINT": "INFO"}},
+				{Type: "drop", Fields: []string{"pg_level"}},
+			},
+		},
+	}
+}
+
+// 单个处理器：失败时返回错误，日志继续交给后续处理器
+type Processor interface {
+	Process(entry *LogEntry) error
+}
+
+// 处理器计数
+type processorStats struct {
+	Processed int64 `json:"processed"`
+	Failed    int64 `json:"failed"`
+}
+
+type pipelineStep struct {
+	name      string // pipeline[index]:type，用于统计
+	processor Processor
+	stats     *processorStats
+}
+
+type Pipeline struct {
+	name   string
+	server string
+	source string
+	steps  []pipelineStep
+}
+
+// 所有管道（位于 /api/logs 和 LogStorage.Append 之间）
+type PipelineSet struct {
+	pipelines []*Pipeline
+	mu        sync.Mutex // 保护统计
+}
+
+func NewPipelineSet(configs []PipelineConfig) (*PipelineSet, error) {
+	set := &PipelineSet{}
+
+	for i, cfg := range configs {
+		name := cfg.Name
+		if name == "" {
+			name = fmt.Sprintf("pipeline-%d", i)
+		}
+		p := &Pipeline{name: name, server: cfg.Server, source: cfg.Source}
+
+		for j, pc := range cfg.Processors {
+			proc, err := newProcessor(pc, cfg.Patterns)
+			if err != nil {
+				return nil, fmt.Errorf("pipeline %s processor #%d (%s): %w", name, j, pc.Type, err)
+			}
+			p.steps = append(p.steps, pipelineStep{
+				name:      fmt.Sprintf("%s[%d]:%s", name, j, pc.Type),
+				processor: proc,
+				stats:     &processorStats{},
+			})
+		}
+		set.pipelines = append(set.pipelines, p)
+	}
+
+	return set, nil
+}
+
+// 对一条日志执行匹配的管道
+func (s *PipelineSet) Run(entry *LogEntry) {
+	p := s.pipelineFor(*entry)
+	if p == nil {
+		return
+	}
+
+	for _, step := range p.steps {
+		err := step.processor.Process(entry)
+
+		s.mu.Lock()
+		step.stats.Processed++
+		if err != nil {
+			step.stats.Failed++
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *PipelineSet) pipelineFor(entry LogEntry) *Pipeline {
+	for _, p := range s.pipelines {
+		if (p.server == "" || p.server == entry.Server) && (p.source == "" || p.source == entry.Source) {
+			return p
+		}
+	}
+	return nil
+}
+
+func (s *PipelineSet) GetStats() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	processors := make(map[string]processorStats)
+	for _, p := range s.pipelines {
+		for _, step := range p.steps {
+			processors[step.name] = *step.stats
+		}
+	}
+
+	return map[string]interface{}{
+		"pipeline_processors": processors,
+	}
+}
+
+func newProcessor(cfg ProcessorConfig, patterns map[string]string) (Processor, error) {
+	field := cfg.Field
+	if field == "" {
+		field = "message"
+	}
+
+	switch cfg.Type {
+	case "grok":
+		expr, err := compileGrok(cfg.Pattern, patterns)
+		if err != nil {
+			return nil, err
+		}
+		return &grokProcessor{field: field, expr: expr}, nil
+
+	case "regex":
+		re, err := regexp.Compile(cfg.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		return &regexProcessor{field: field, re: re}, nil
+
+	case "json":
+		return &jsonProcessor{field: field, prefix: cfg.Prefix}, nil
+
+	case "kv":
+		delimiter := cfg.Delimiter
+		if delimiter == "" {
+			delimiter = "="
+		}
+		return &kvProcessor{field: field, prefix: cfg.Prefix, separator: cfg.Separator, delimiter: delimiter}, nil
+
+	case "rename":
+		if len(cfg.Rename) == 0 {
+			return nil, fmt.Errorf("rename 需要至少一个字段")
+		}
+		return &renameProcessor{rename: cfg.Rename}, nil
+
+	case "drop":
+		if len(cfg.Fields) == 0 {
+			return nil, fmt.Errorf("drop 需要至少一个字段")
+		}
+		return &dropProcessor{fields: cfg.Fields}, nil
+
+	case "level":
+		if cfg.Field == "" {
+			field = "level"
+		}
+		return newLevelProcessor(field, cfg.Mapping), nil
+	}
+
+	return nil, fmt.Errorf("未知处理器类型: %q", cfg.Type)
+}
+
+// ============ 字段读写（内置字段 + Fields） ============
+
+func getField(entry *LogEntry, name string) (string, bool) {
+	switch name {
+	case "message":
+		return entry.Message, true
+	case "level":
+		return entry.Level, entry.Level != ""
+	case "server":
+		return entry.Server, entry.Server != ""
+	case "source":
+		return entry.Source, entry.Source != ""
+	case "timestamp":
+		return entry.Timestamp, entry.Timestamp != ""
+	}
+	value, ok := entry.Fields[name]
+	return value, ok
+}
+
+func setField(entry *LogEntry, name, value string) {
+	switch name {
+	case "message":
+		entry.Message = value
+	case "level":
+		entry.Level = value
+	case "server":
+		entry.Server = value
+	case "source":
+		entry.Source = value
+	case "timestamp":
+		entry.Timestamp = value
+	default:
+		if entry.Fields == nil {
+			entry.Fields = make(map[string]string)
+		}
+		entry.Fields[name] = value
+	}
+}
+
+func deleteField(entry *LogEntry, name string) {
+	switch name {
+	case "message", "level", "server", "source", "timestamp":
+		setField(entry, name, "")
+	default:
+		delete(entry.Fields, name)
+	}
+}
+
+// ============ 处理器实现 ============
+
+type grokProcessor struct {
+	field string
+	expr  *grokExpr
+}
+
+func (p *grokProcessor) Process(entry *LogEntry) error {
+	text, _ := getField(entry, p.field)
+	fields, ok := p.expr.match(text)
+	if !ok {
+		return fmt.Errorf("grok: 不匹配")
+	}
+	for k, v := range fields {
+		setField(entry, k, v)
+	}
+	return nil
+}
+
+type regexProcessor struct {
+	field string
+	re    *regexp.Regexp
+}
+
+func (p *regexProcessor) Process(entry *LogEntry) error {
+	text, _ := getField(entry, p.field)
+	m := p.re.FindStringSubmatch(text)
+	if m == nil {
+		return fmt.Errorf("regex: 不匹配")
+	}
+	for i, name := range p.re.SubexpNames() {
+		if name != "" && m[i] != "" {
+			setField(entry, name, m[i])
+		}
+	}
+	return nil
+}
+
+type jsonProcessor struct {
+	field  string
+	prefix string
+}
+
+func (p *jsonProcessor) Process(entry *LogEntry) error {
+	text, _ := getField(entry, p.field)
+	text = strings.TrimSpace(text)
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(text), &obj); err != nil {
+		return fmt.Errorf("json: %w", err)
+	}
+	flattenJSON(entry, p.prefix, obj)
+	return nil
+}
+
+// 嵌套对象展开成 a.b.c 形式的字段
+func flattenJSON(entry *LogEntry, prefix string, obj map[string]interface{}) {
+	for k, v := range obj {
+		key := prefix + k
+		switch val := v.(type) {
+		case map[string]interface{}:
+			flattenJSON(entry, key+".", val)
+		case string:
+			setField(entry, key, val)
+		case float64:
+			setField(entry, key, strconv.FormatFloat(val, 'f', -1, 64))
+		case bool:
+			setField(entry, key, strconv.FormatBool(val))
+		case nil:
+			continue
+		default:
+			raw, _ := json.Marshal(val)
+			setField(entry, key, string(raw))
+		}
+	}
+}
+
+type kvProcessor struct {
+	field     string
+	prefix    string
+	separator string
+	delimiter string
+}
+
+func (p *kvProcessor) Process(entry *LogEntry) error {
+	text, _ := getField(entry, p.field)
+
+	var pairs []string
+	if p.separator == "" {
+		pairs = splitQuoted(text)
+	} else {
+		pairs = strings.Split(text, p.separator)
+	}
+
+	found := 0
+	for _, pair := range pairs {
+		idx := strings.Index(pair, p.delimiter)
+		if idx <= 0 {
+			continue
+		}
+		key := strings.TrimSpace(pair[:idx])
+		value := strings.Trim(strings.TrimSpace(pair[idx+len(p.delimiter):]), `"'`)
+		if key == "" || strings.ContainsAny(key, " \t") {
+			continue
+		}
+		setField(entry, p.prefix+key, value)
+		found++
+	}
+
+	if found == 0 {
+		return fmt.Errorf("kv: 没有找到键值对")
+	}
+	return nil
+}
+
+// 按空白切分，但保留引号内的空格（msg="hello world"）
+func splitQuoted(text string) []string {
+	var parts []string
+	var current strings.Builder
+	var quote rune
+
+	for _, c := range text {
+		switch {
+		case quote != 0:
+			current.WriteRune(c)
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			current.WriteRune(c)
+		case c == ' ' || c == '\t':
+			if current.Len() > 0 {
+				parts = append(parts, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(c)
+		}
+	}
+	if current.Len() > 0 {
+		parts = append(parts, current.String())
+	}
+	return parts
+}
+
+type renameProcessor struct {
+	rename map[string]string
+}
+
+func (p *renameProcessor) Process(entry *LogEntry) error {
+	missing := 0
+	for from, to := range p.rename {
+		value, ok := getField(entry, from)
+		if !ok {
+			missing++
+			continue
+		}
+		deleteField(entry, from)
+		setField(entry, to, value)
+	}
+	if missing == len(p.rename) {
+		return fmt.Errorf("rename: 字段不存在")
+	}
+	return nil
+}
+
+type dropProcessor struct {
+	fields []string
+}
+
+func (p *dropProcessor) Process(entry *LogEntry) error {
+	for _, name := range p.fields {
+		deleteField(entry, name)
+	}
+	return nil
+}
+
+// 级别映射：先查 Mapping 的精确值，再按顺序匹配 5xx 这种前缀写法，否则按别名规范化
+type levelProcessor struct {
+	field    string
+	exact    map[string]string
+	prefixes []levelPrefix // 长前缀在前（50xx 优先于 5xxx），同样长度按字母序，结果不随 map 遍历顺序变化
+}
+
+type levelPrefix struct {
+	prefix string
+	length int // 原值的长度（5xx 只匹配三位的值）
+	level  string
+}
+
+func newLevelProcessor(field string, mapping map[string]string) *levelProcessor {
+	p := &levelProcessor{field: field, exact: make(map[string]string)}
+	for pattern, level := range mapping {
+		p.exact[pattern] = level
+		if strings.HasSuffix(pattern, "xx") {
+			p.prefixes = append(p.prefixes, levelPrefix{prefix: strings.TrimRight(pattern, "x"), length: len(pattern), level: level})
+		}
+	}
+	sort.Slice(p.prefixes, func(i, j int) bool {
+		a, b := p.prefixes[i], p.prefixes[j]
+		if len(a.prefix) != len(b.prefix) {
+			return len(a.prefix) > len(b.prefix)
+		}
+		return a.prefix < b.prefix
+	})
+	return p
+}
+
+func (p *levelProcessor) Process(entry *LogEntry) error {
+	value, ok := getField(entry, p.field)
+	if !ok || value == "" {
+		return fmt.Errorf("level: 字段 %s 不存在", p.field)
+	}
+
+	if level, ok := p.exact[value]; ok {
+		entry.Level = level
+		return nil
+	}
+	for _, m := range p.prefixes {
+		if m.length == len(value) && strings.HasPrefix(value, m.prefix) {
+			entry.Level = m.level
+			return nil
+		}
+	}
+
+	sev := ParseSeverity(value)
+	if sev == SeverityUnknown {
+		return fmt.Errorf("level: 无法识别的级别 %q", value)
+	}
+	entry.Level = sev.String()
+	return nil
+}
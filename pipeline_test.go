@@ -0,0 +1,250 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProcessors(t *testing.T) {
+	cases := []struct {
+		name    string
+		cfg     ProcessorConfig
+		entry   LogEntry
+		want    LogEntry
+		wantErr bool
+	}{
+		{
+			name:  "grok",
+			cfg:   ProcessorConfig{Type: "grok", Pattern: `%{IP:client} %{WORD:method} %{URIPATHPARAM:path} %{INT:status}`},
+			entry: LogEntry{Message: "10.0.0.1 GET /api?id=1 200"},
+			want:  LogEntry{Message: "10.0.0.1 GET /api?id=1 200", Fields: map[string]string{"client": "10.0.0.1", "method": "GET", "path": "/api?id=1", "status": "200"}},
+		},
+		{
+			name:  "grok into builtin field",
+			cfg:   ProcessorConfig{Type: "grok", Pattern: `^%{LOGLEVEL:level}: %{GREEDYDATA:message}`},
+			entry: LogEntry{Message: "WARN: disk 91%"},
+			want:  LogEntry{Level: "WARN", Message: "disk 91%"},
+		},
+		{
+			name:    "grok no match",
+			cfg:     ProcessorConfig{Type: "grok", Pattern: `^%{INT:n}$`},
+			entry:   LogEntry{Message: "abc"},
+			want:    LogEntry{Message: "abc"},
+			wantErr: true,
+		},
+		{
+			name:  "regex",
+			cfg:   ProcessorConfig{Type: "regex", Pattern: `user=(?P<user>\w+)(?: id=(?P<id>\d+))?`},
+			entry: LogEntry{Message: "login user=alice"},
+			want:  LogEntry{Message: "login user=alice", Fields: map[string]string{"user": "alice"}},
+		},
+		{
+			name:  "json nested",
+			cfg:   ProcessorConfig{Type: "json", Prefix: "j."},
+			entry: LogEntry{Message: ` {"a":{"b":1.5},"ok":true,"tags":["x"],"none":null,"s":"v"}`},
+			want: LogEntry{Message: ` {"a":{"b":1.5},"ok":true,"tags":["x"],"none":null,"s":"v"}`, Fields: map[string]string{
+				"j.a.b": "1.5", "j.ok": "true", "j.tags": `["x"]`, "j.s": "v",
+			}},
+		},
+		{
+			name:    "json invalid",
+			cfg:     ProcessorConfig{Type: "json"},
+			entry:   LogEntry{Message: "not json"},
+			want:    LogEntry{Message: "not json"},
+			wantErr: true,
+		},
+		{
+			name:  "kv quoted",
+			cfg:   ProcessorConfig{Type: "kv", Prefix: "kv_"},
+			entry: LogEntry{Message: `a=1 msg="hello world" b='x' junk =bad`},
+			want:  LogEntry{Message: `a=1 msg="hello world" b='x' junk =bad`, Fields: map[string]string{"kv_a": "1", "kv_msg": "hello world", "kv_b": "x"}},
+		},
+		{
+			name:  "kv separator and delimiter",
+			cfg:   ProcessorConfig{Type: "kv", Field: "raw", Separator: ";", Delimiter: ":"},
+			entry: LogEntry{Fields: map[string]string{"raw": "host: db-1;port:5432"}},
+			want:  LogEntry{Fields: map[string]string{"raw": "host: db-1;port:5432", "host": "db-1", "port": "5432"}},
+		},
+		{
+			name:    "kv nothing found",
+			cfg:     ProcessorConfig{Type: "kv"},
+			entry:   LogEntry{Message: "plain text"},
+			want:    LogEntry{Message: "plain text"},
+			wantErr: true,
+		},
+		{
+			name:  "rename into builtin field",
+			cfg:   ProcessorConfig{Type: "rename", Rename: map[string]string{"severity": "level", "missing": "other"}},
+			entry: LogEntry{Message: "m", Fields: map[string]string{"severity": "ERROR"}},
+			want:  LogEntry{Message: "m", Level: "ERROR", Fields: map[string]string{}},
+		},
+		{
+			name:    "rename all missing",
+			cfg:     ProcessorConfig{Type: "rename", Rename: map[string]string{"missing": "other"}},
+			entry:   LogEntry{Message: "m"},
+			want:    LogEntry{Message: "m"},
+			wantErr: true,
+		},
+		{
+			name:  "drop",
+			cfg:   ProcessorConfig{Type: "drop", Fields: []string{"secret", "source", "absent"}},
+			entry: LogEntry{Message: "m", Source: "app", Fields: map[string]string{"secret": "x", "keep": "y"}},
+			want:  LogEntry{Message: "m", Fields: map[string]string{"keep": "y"}},
+		},
+		{
+			name:  "level alias",
+			cfg:   ProcessorConfig{Type: "level"},
+			entry: LogEntry{Level: "warning"},
+			want:  LogEntry{Level: "WARN"},
+		},
+		{
+			name:  "level exact beats prefix",
+			cfg:   ProcessorConfig{Type: "level", Field: "status", Mapping: map[string]string{"503": "WARN", "5xx": "ERROR"}},
+			entry: LogEntry{Fields: map[string]string{"status": "503"}},
+			want:  LogEntry{Level: "WARN", Fields: map[string]string{"status": "503"}},
+		},
+		{
+			name:  "level prefix length must match",
+			cfg:   ProcessorConfig{Type: "level", Field: "status", Mapping: map[string]string{"5xx": "ERROR"}},
+			entry: LogEntry{Fields: map[string]string{"status": "5000"}},
+			want:  LogEntry{Fields: map[string]string{"status": "5000"}},
+			// 5000 既不匹配 5xx 也不是级别名
+			wantErr: true,
+		},
+		{
+			name:    "level missing field",
+			cfg:     ProcessorConfig{Type: "level", Field: "status"},
+			entry:   LogEntry{Level: "INFO"},
+			want:    LogEntry{Level: "INFO"},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		p, err := newProcessor(c.cfg, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		entry := c.entry
+		err = p.Process(&entry)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", c.name, err, c.wantErr)
+		}
+		if !reflect.DeepEqual(entry, c.want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", c.name, entry, c.want)
+		}
+	}
+}
+
+func TestNewProcessorErrors(t *testing.T) {
+	for _, cfg := range []ProcessorConfig{
+		{Type: "unknown"},
+		{Type: "grok", Pattern: "%{NOSUCHPATTERN}"},
+		{Type: "regex", Pattern: "("},
+		{Type: "rename"},
+		{Type: "drop"},
+	} {
+		if _, err := newProcessor(cfg, nil); err == nil {
+			t.Errorf("%+v: expected error", cfg)
+		}
+	}
+	if _, err := compileGrok("%{LOOP}", map[string]string{"LOOP": "%{LOOP}"}); err == nil {
+		t.Error("recursive grok pattern accepted")
+	}
+}
+
+// 重叠的前缀按固定顺序匹配：长前缀优先，多次构造结果一致
+func TestLevelPrefixOrder(t *testing.T) {
+	mapping := map[string]string{"5xxx": "ERROR", "50xx": "WARN", "51xx": "INFO", "4xxx": "DEBUG"}
+	for i := 0; i < 50; i++ {
+		p := newLevelProcessor("code", mapping)
+		for value, want := range map[string]string{"5003": "WARN", "5100": "INFO", "5900": "ERROR", "4004": "DEBUG"} {
+			entry := LogEntry{Fields: map[string]string{"code": value}}
+			if err := p.Process(&entry); err != nil || entry.Level != want {
+				t.Fatalf("%s: level %q (%v), want %s", value, entry.Level, err, want)
+			}
+		}
+	}
+}
+
+func TestCustomGrokPatterns(t *testing.T) {
+	set, err := NewPipelineSet([]PipelineConfig{{
+		Server:     "app-1",
+		Patterns:   map[string]string{"REQID": `req-[0-9a-f]+`},
+		Processors: []ProcessorConfig{{Type: "grok", Pattern: `%{REQID:request_id} %{GREEDYDATA:message}`}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := LogEntry{Server: "app-1", Message: "req-1f done"}
+	set.Run(&entry)
+	if entry.Fields["request_id"] != "req-1f" || entry.Message != "done" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+}
+
+// 默认管道：nginx 访问日志按状态码映射级别，postgres 日志提取字段并删除原始级别
+func TestDefaultPipelines(t *testing.T) {
+	set, err := NewPipelineSet(defaultPipelines())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		entry  LogEntry
+		level  string
+		fields map[string]string
+	}{
+		{
+			entry: LogEntry{Source: "nginx", Message: `192.168.1.5 - - [01/May/2024:10:00:00 +0800] "GET /index.html?x=1 HTTP/1.1" 502 157 "-" "curl/8.0"`},
+			level: "ERROR",
+			fields: map[string]string{
+				"client_ip": "192.168.1.5", "ident": "-", "auth": "-", "time_local": "01/May/2024:10:00:00 +0800",
+				"method": "GET", "request": "/index.html?x=1", "http_version": "1.1", "status": "502", "bytes": "157",
+				"referrer": "-", "user_agent": "curl/8.0",
+			},
+		},
+		{
+			entry:  LogEntry{Source: "nginx", Message: `10.0.0.1 - bob [01/May/2024:10:00:00 +0000] "POST /login HTTP/2.0" 404 - "https://a/" "Mozilla"`},
+			level:  "WARN",
+			fields: map[string]string{"client_ip": "10.0.0.1", "ident": "-", "auth": "bob", "time_local": "01/May/2024:10:00:00 +0000", "method": "POST", "request": "/login", "http_version": "2.0", "status": "404", "referrer": "https://a/", "user_agent": "Mozilla"},
+		},
+		{
+			entry:  LogEntry{Source: "nginx", Message: `::1 - - [01/May/2024:10:00:00 +0000] "\x16\x03" 400 0`},
+			level:  "WARN",
+			fields: map[string]string{"client_ip": "::1", "ident": "-", "auth": "-", "time_local": "01/May/2024:10:00:00 +0000", "raw_request": `\x16\x03`, "status": "400", "bytes": "0"},
+		},
+		{
+			entry:  LogEntry{Source: "postgres", Message: "2024-05-01 10:00:00.123 UTC [4242] app@shop ERROR:  relation \"x\" does not exist"},
+			level:  "ERROR",
+			fields: map[string]string{"pg_timestamp": "2024-05-01 10:00:00.123", "pg_timezone": "UTC", "pg_pid": "4242", "pg_user": "app", "pg_database": "shop", "pg_message": `relation "x" does not exist`},
+		},
+		{
+			entry:  LogEntry{Source: "postgres", Message: "2024-05-01 10:00:00 CEST [17] LOG:  checkpoint starting: time"},
+			level:  "INFO",
+			fields: map[string]string{"pg_timestamp": "2024-05-01 10:00:00", "pg_timezone": "CEST", "pg_pid": "17", "pg_message": "checkpoint starting: time"},
+		},
+	}
+	for _, c := range cases {
+		entry := c.entry
+		set.Run(&entry)
+		if entry.Level != c.level || !reflect.DeepEqual(entry.Fields, c.fields) {
+			t.Errorf("%s: got level %q fields %v\nwant level %q fields %v", c.entry.Message, entry.Level, entry.Fields, c.level, c.fields)
+		}
+	}
+
+	// 没有匹配的管道时原样保留
+	entry := LogEntry{Source: "app", Level: "INFO", Message: "hello"}
+	set.Run(&entry)
+	if entry.Fields != nil || entry.Level != "INFO" {
+		t.Errorf("unmatched entry modified: %+v", entry)
+	}
+
+	stats := set.GetStats()["pipeline_processors"].(map[string]processorStats)
+	if got := stats["nginx[0]:grok"]; got.Processed != 3 || got.Failed != 0 {
+		t.Errorf("nginx grok stats = %+v", got)
+	}
+	if got := stats["postgres[2]:drop"]; got.Processed != 2 {
+		t.Errorf("postgres drop stats = %+v", got)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prometheus 文本格式导出（不引入 client_golang）
+
+// 延迟直方图的默认桶（秒）
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64 // 每个桶（非累计），最后一个是 +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) Observe(v float64) {
+	idx := sort.SearchFloat64s(h.bounds, v)
+	h.mu.Lock()
+	h.counts[idx]++
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+func (h *histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// 带标签的计数器（标签值按顺序拼接作为 key）
+type counterVec struct {
+	mu     sync.Mutex
+	values map[string]float64
+	labels map[string][]string
+	limit  int // 不同标签组合的上限，超过后归入 other，防止客户端传入的值撑爆内存
+}
+
+func newCounterVec(limit int) *counterVec {
+	return &counterVec{values: make(map[string]float64), labels: make(map[string][]string), limit: limit}
+}
+
+func (c *counterVec) Add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\x00")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.values[key]; !ok {
+		if c.limit > 0 && len(c.values) >= c.limit {
+			other := make([]string, len(labelValues))
+			for i := range other {
+				other[i] = "other"
+			}
+			other[len(other)-1] = labelValues[len(labelValues)-1]
+			labelValues = other
+			key = strings.Join(labelValues, "\x00")
+		}
+		c.labels[key] = labelValues
+	}
+	c.values[key] += v
+}
+
+// 导出时使用的写入器
+type promWriter struct {
+	w       io.Writer
+	written map[string]bool // 已经输出过 HELP/TYPE 的指标
+}
+
+func newPromWriter(w io.Writer) *promWriter {
+	return &promWriter{w: w, written: make(map[string]bool)}
+}
+
+func (p *promWriter) header(name, kind, help string) {
+	if p.written[name] {
+		return
+	}
+	p.written[name] = true
+	fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// labels 为 key1, value1, key2, value2 ...
+func (p *promWriter) sample(name string, value float64, labels ...string) {
+	fmt.Fprintf(p.w, "%s%s %s\n", name, formatLabels(labels), formatFloat(value))
+}
+
+func (p *promWriter) gauge(name, help string, value float64, labels ...string) {
+	p.header(name, "gauge", help)
+	p.sample(name, value, labels...)
+}
+
+func (p *promWriter) counter(name, help string, value float64, labels ...string) {
+	p.header(name, "counter", help)
+	p.sample(name, value, labels...)
+}
+
+// 输出样本（HELP/TYPE 由调用方输出）
+func (p *promWriter) counterVec(name string, c *counterVec, labelNames []string, labels ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		all := append([]string{}, labels...)
+		for i, v := range c.labels[key] {
+			all = append(all, labelNames[i], v)
+		}
+		p.sample(name, c.values[key], all...)
+	}
+}
+
+func (p *promWriter) histogram(name string, h *histogram, labels ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		p.sample(name+"_bucket", float64(cumulative), append(labels, "le", formatFloat(bound))...)
+	}
+	p.sample(name+"_bucket", float64(h.count), append(labels, "le", "+Inf")...)
+	p.sample(name+"_sum", h.sum, labels...)
+	p.sample(name+"_count", float64(h.count), labels...)
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		parts = append(parts, labels[i]+`="`+escapeLabel(labels[i+1])+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var processStart = time.Now()
+
+// API: Prometheus 指标（绑定租户的 key 只能看到自己的租户）
+func handlePrometheus(tenants *TenantManager, replication *ReplicationManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		p := newPromWriter(w)
+
+		principal := principalFrom(r)
+		visible := make([]*Tenant, 0)
+		for _, t := range tenants.List() {
+			if principal == nil || principal.Tenant == "" || principal.Tenant == t.ID {
+				visible = append(visible, t)
+			}
+		}
+		writeTenantMetrics(p, visible)
+		if principal == nil || principal.Tenant == "" {
+			replication.writeMetrics(p)
+		}
+
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		p.gauge("go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+		p.gauge("go_memstats_heap_alloc_bytes", "Number of heap bytes allocated and still in use.", float64(mem.HeapAlloc))
+		p.gauge("go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", float64(mem.HeapInuse))
+		p.gauge("go_memstats_heap_objects", "Number of allocated objects.", float64(mem.HeapObjects))
+		p.gauge("go_memstats_sys_bytes", "Number of bytes obtained from system.", float64(mem.Sys))
+		p.counter("go_gc_cycles_total", "Number of completed GC cycles.", float64(mem.NumGC))
+		p.gauge("process_start_time_seconds", "Start time of the process since unix epoch in seconds.", float64(processStart.Unix()))
+	}
+}
+
+// 租户级别的快照（同一个指标的所有样本必须连续输出，所以先取快照再按指标输出）
+type tenantSnapshot struct {
+	tenant        *Tenant
+	labels        []string
+	storage       storageMetrics
+	buffered      int
+	bufferBytes   int64
+	capacity      int
+	capacityBytes int64
+}
+
+func writeTenantMetrics(p *promWriter, tenants []*Tenant) {
+	snapshots := make([]tenantSnapshot, 0, len(tenants))
+	for _, t := range tenants {
+		logs := t.Logs
+		snap := tenantSnapshot{tenant: t, labels: []string{"tenant", t.ID}, storage: logs.metricsSnapshot()}
+		logs.bufferMu.RLock()
+		snap.buffered = len(logs.memoryBuffer)
+		snap.bufferBytes = logs.bufferBytes
+		snap.capacity = logs.maxBufferSize
+		snap.capacityBytes = logs.maxBufferMemory
+		logs.bufferMu.RUnlock()
+		snapshots = append(snapshots, snap)
+	}
+
+	family := func(name, kind, help string, fn func(s *tenantSnapshot)) {
+		if len(snapshots) == 0 {
+			return
+		}
+		p.header(name, kind, help)
+		for i := range snapshots {
+			fn(&snapshots[i])
+		}
+	}
+	gauge := func(name, help string, value func(s *tenantSnapshot) float64) {
+		family(name, "gauge", help, func(s *tenantSnapshot) { p.sample(name, value(s), s.labels...) })
+	}
+	counter := func(name, help string, value func(s *tenantSnapshot) float64) {
+		family(name, "counter", help, func(s *tenantSnapshot) { p.sample(name, value(s), s.labels...) })
+	}
+
+	family("minilog_ingest_entries_total", "counter", "Log entries by source and outcome (stored, dropped_rule, dropped_redaction, rate_limited, disk_full, paused, forbidden).",
+		func(s *tenantSnapshot) {
+			p.counterVec("minilog_ingest_entries_total", s.tenant.Ingester.counts, []string{"source", "status"}, s.labels...)
+		})
+
+	gauge("minilog_buffer_entries", "Entries in the in-memory buffer waiting to be flushed.",
+		func(s *tenantSnapshot) float64 { return float64(s.buffered) })
+	gauge("minilog_buffer_capacity_entries", "Buffer size that triggers a flush.",
+		func(s *tenantSnapshot) float64 { return float64(s.capacity) })
+	gauge("minilog_buffer_bytes", "Estimated memory used by the buffer.",
+		func(s *tenantSnapshot) float64 { return float64(s.bufferBytes) })
+	gauge("minilog_buffer_capacity_bytes", "Buffer memory that triggers a flush.",
+		func(s *tenantSnapshot) float64 { return float64(s.capacityBytes) })
+
+	counter("minilog_received_entries_total", "Entries appended to storage.",
+		func(s *tenantSnapshot) float64 { return float64(s.storage.received) })
+	counter("minilog_duplicate_entries_total", "Retried entries suppressed by deduplication.",
+		func(s *tenantSnapshot) float64 { return float64(s.storage.duplicates) })
+	counter("minilog_late_entries_total", "Entries written back to an earlier hour's segment.",
+		func(s *tenantSnapshot) float64 { return float64(s.storage.late) })
+	counter("minilog_flushed_entries_total", "Entries compressed to disk.",
+		func(s *tenantSnapshot) float64 { return float64(s.storage.flushed) })
+	counter("minilog_flushes_total", "Completed flushes.",
+		func(s *tenantSnapshot) float64 { return float64(s.storage.flushes) })
+	counter("minilog_uncompressed_bytes_total", "Bytes written before compression.",
+		func(s *tenantSnapshot) float64 { return float64(s.storage.uncompressedBytes) })
+	counter("minilog_compressed_bytes_total", "Bytes written to disk after compression.",
+		func(s *tenantSnapshot) float64 { return float64(s.storage.compressedBytes) })
+	gauge("minilog_compression_ratio", "Compression ratio of the last flush.",
+		func(s *tenantSnapshot) float64 { return s.storage.ratio })
+	counter("minilog_query_chunks_scanned_total", "Compressed chunks decompressed by queries.",
+		func(s *tenantSnapshot) float64 { return float64(s.storage.chunksScanned) })
+
+	family("minilog_flush_duration_seconds", "histogram", "Time to compress and write one flush.",
+		func(s *tenantSnapshot) {
+			p.histogram("minilog_flush_duration_seconds", s.tenant.Logs.flushLatency, s.labels...)
+		})
+	family("minilog_query_duration_seconds", "histogram", "Log query latency (memory + disk).",
+		func(s *tenantSnapshot) {
+			p.histogram("minilog_query_duration_seconds", s.tenant.Logs.queryLatency, s.labels...)
+		})
+
+	gauge("minilog_tenant_disk_bytes", "Disk usage of the tenant data directory.",
+		func(s *tenantSnapshot) float64 { return float64(s.tenant.diskBytes()) })
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPromWriterGolden(t *testing.T) {
+	var out strings.Builder
+	p := newPromWriter(&out)
+
+	// 超过 2 种标签组合后归入 other，只保留最后一个标签（状态）
+	counts := newCounterVec(2)
+	counts.Add(1, "nginx", "stored")
+	counts.Add(2, "nginx", "stored")
+	counts.Add(1, "app \"x\"\n\\", "dropped")
+	counts.Add(5, "spam-1", "stored")
+	counts.Add(1, "spam-2", "paused")
+	counts.Add(1, "spam-3", "stored")
+	p.header("minilog_test_entries_total", "counter", "Entries by source and status.")
+	p.counterVec("minilog_test_entries_total", counts, []string{"source", "status"}, "tenant", "acme")
+
+	// 桶上限包含边界值；+Inf 桶等于总数
+	h := newHistogram([]float64{0.125, 1})
+	for _, v := range []float64{0.0625, 0.125, 0.5, 3} {
+		h.Observe(v)
+	}
+	p.header("minilog_test_duration_seconds", "histogram", "Test latency.")
+	p.histogram("minilog_test_duration_seconds", h, "tenant", "acme")
+
+	// 同一个指标的 HELP/TYPE 只输出一次
+	p.gauge("minilog_test_ratio", "Ratio.", math.Inf(1), "tenant", "acme")
+	p.gauge("minilog_test_ratio", "Ratio.", 1234567, "tenant", "b\\c")
+	p.counter("minilog_test_total", "Plain counter.", 0.5)
+
+	want := `# HELP minilog_test_entries_total Entries by source and status.
+# TYPE minilog_test_entries_total counter
+minilog_test_entries_total{tenant="acme",source="app \"x\"\n\\",status="dropped"} 1
+minilog_test_entries_total{tenant="acme",source="nginx",status="stored"} 3
+minilog_test_entries_total{tenant="acme",source="other",status="paused"} 1
+minilog_test_entries_total{tenant="acme",source="other",status="stored"} 6
+# HELP minilog_test_duration_seconds Test latency.
+# TYPE minilog_test_duration_seconds histogram
+minilog_test_duration_seconds_bucket{tenant="acme",le="0.125"} 2
+minilog_test_duration_seconds_bucket{tenant="acme",le="1"} 3
+minilog_test_duration_seconds_bucket{tenant="acme",le="+Inf"} 4
+minilog_test_duration_seconds_sum{tenant="acme"} 3.6875
+minilog_test_duration_seconds_count{tenant="acme"} 4
+# HELP minilog_test_ratio Ratio.
+# TYPE minilog_test_ratio gauge
+minilog_test_ratio{tenant="acme"} +Inf
+minilog_test_ratio{tenant="b\\c"} 1.234567e+06
+# HELP minilog_test_total Plain counter.
+# TYPE minilog_test_total counter
+minilog_test_total 0.5
+`
+	if out.String() != want {
+		t.Errorf("exposition mismatch\n got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+var (
+	promComment = regexp.MustCompile(`^# (HELP|TYPE) ([a-zA-Z_:][a-zA-Z0-9_:]*) (.+)$`)
+	promSample  = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\[\\"n])*"(?:,[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\[\\"n])*")*\})? (\S+)$`)
+)
+
+// 完整的 /metrics 输出：每行格式合法，样本前有 TYPE，同一指标的样本连续输出
+func TestPrometheusEndpointFormat(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.DataDir = t.TempDir()
+	tenants, err := NewTenantManager(cfg, cfg.IngestConfig([]byte("test-key")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tenants.Create("acme"); err != nil {
+		t.Fatal(err)
+	}
+	replication := NewReplicationManager(cfg.Replication, tenants, cfg.DataDir)
+	for _, id := range []string{defaultTenant, "acme"} {
+		tenant, _ := tenants.Get(id)
+		waitReplayed(t, tenant.Logs)
+		entry := LogEntry{Timestamp: time.Now().Format(columnTimeLayout), Server: "web-01", Source: "nginx", Level: "INFO", Message: "GET /"}
+		tenant.Ingester.Ingest(entry)
+		tenant.Logs.Query("", "", "", "", "", 10, nil)
+	}
+
+	scrape := func(principal *Principal) string {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		if principal != nil {
+			r = r.WithContext(context.WithValue(r.Context(), principalKey{}, principal))
+		}
+		w := httptest.NewRecorder()
+		handlePrometheus(tenants, replication)(w, r)
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+			t.Errorf("Content-Type %q", ct)
+		}
+		return w.Body.String()
+	}
+
+	body := scrape(nil)
+	types := make(map[string]string)
+	done := make(map[string]bool) // 已经结束的指标
+	current := ""
+	for i, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		if m := promComment.FindStringSubmatch(line); m != nil {
+			if m[1] == "TYPE" {
+				types[m[2]] = m[3]
+			}
+			continue
+		}
+		m := promSample.FindStringSubmatch(line)
+		if m == nil {
+			t.Errorf("line %d: invalid sample %q", i+1, line)
+			continue
+		}
+		family := m[1]
+		if types[family] == "" {
+			for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+				if base := strings.TrimSuffix(family, suffix); types[base] == "histogram" {
+					family = base
+				}
+			}
+		}
+		if types[family] == "" {
+			t.Errorf("line %d: %s has no TYPE", i+1, m[1])
+		}
+		if family != current {
+			if done[family] {
+				t.Errorf("line %d: samples of %s are not contiguous", i+1, family)
+			}
+			done[current] = true
+			current = family
+		}
+	}
+
+	for _, want := range []string{
+		`minilog_ingest_entries_total{tenant="acme",source="nginx",status="stored"} 1`,
+		`minilog_query_duration_seconds_count{tenant="default"} 1`,
+		`minilog_buffer_entries{tenant="acme"} 1`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("missing %q", want)
+		}
+	}
+
+	// 绑定租户的 key 只能看到自己的租户
+	bound := scrape(&Principal{Tenant: "acme", Scopes: []string{ScopeQuery}})
+	if strings.Contains(bound, `tenant="default"`) || !strings.Contains(bound, `tenant="acme"`) {
+		t.Error("tenant-bound scrape shows other tenants")
+	}
+}
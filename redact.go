@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// 脱敏规则
+type RedactionRule struct {
+	Name    string   `yaml:"name,omitempty"`
+	Pattern string   `yaml:"pattern,omitempty"` // 正则
+	Luhn    bool     `yaml:"luhn,omitempty"`    // 命中后再做 Luhn 校验（信用卡号）
+	Mode    string   `yaml:"mode,omitempty"`    // mask / hash / drop（空则使用默认模式）
+	Fields  []string `yaml:"fields,omitempty"`  // 作用字段（空表示 message 和所有结构化字段）
+
+	re       *regexp.Regexp
+	validate func(string) bool
+}
+
+// 内置检测器：邮箱、信用卡号、Bearer token、IPv4、IPv6
+// 邮箱和 IP 默认 hash（仍可按相同摘要关联），卡号和 token 直接掩码
+func defaultRedactionRules() []RedactionRule {
+	return []RedactionRule{
+		{Name: "email", Pattern: `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`, Mode: "hash"},
+		{Name: "credit_card", Pattern: `\b(?:\d[ -]?){12,18}\d\b`, Luhn: true, Mode: "mask"},
+		{Name: "bearer_token", Pattern: `(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*`, Mode: "mask"},
+		{Name: "ipv4", Pattern: `\b(?:\d{1,3}\.){3}\d{1,3}\b`, Mode: "hash"},
+		{Name: "ipv6", Pattern: `(?i)\b(?:[0-9a-f]{1,4})?(?::[0-9a-f]{0,4}){2,7}\b`, Mode: "hash"},
+	}
+}
+
+// 脱敏器：位于 ingest 管道之后、LogStorage.Append 之前，保证敏感数据不会写入 data/
+type Redactor struct {
+	rules       []*RedactionRule
+	defaultMode string
+	key         []byte // HMAC 密钥（hash 模式，相同值得到相同摘要，可用于关联查询）
+
+	mu    sync.Mutex
+	stats struct {
+		Redactions map[string]int64 // 规则 -> 替换次数
+		Dropped    int64            // drop 模式丢弃的日志条数
+	}
+}
+
+func NewRedactor(rules []RedactionRule, defaultMode string, key []byte) (*Redactor, error) {
+	if defaultMode == "" {
+		defaultMode = "mask"
+	}
+	if !validRedactionMode(defaultMode) {
+		return nil, fmt.Errorf("未知脱敏模式: %q", defaultMode)
+	}
+
+	r := &Redactor{defaultMode: defaultMode, key: key}
+	r.stats.Redactions = make(map[string]int64)
+
+	for i := range rules {
+		rule := rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i)
+		}
+		if rule.Mode == "" {
+			rule.Mode = defaultMode
+		}
+		if !validRedactionMode(rule.Mode) {
+			return nil, fmt.Errorf("redaction rule %s: 未知模式 %q", rule.Name, rule.Mode)
+		}
+		if rule.Mode == "hash" && len(key) == 0 {
+			return nil, fmt.Errorf("redaction rule %s: hash 模式需要密钥", rule.Name)
+		}
+
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redaction rule %s: %w", rule.Name, err)
+		}
+		rule.re = re
+
+		switch {
+		case rule.Luhn:
+			rule.validate = luhnValid
+		case rule.Name == "ipv4" || rule.Name == "ipv6":
+			// 排除 "10:00:00"、"std::vector" 这类看起来像 IP 的内容
+			rule.validate = func(s string) bool { return strings.Trim(s, ":") != "" && net.ParseIP(s) != nil }
+		}
+
+		r.rules = append(r.rules, &rule)
+	}
+
+	return r, nil
+}
+
+func validRedactionMode(mode string) bool {
+	return mode == "mask" || mode == "hash" || mode == "drop"
+}
+
+// 对日志脱敏；返回 false 表示命中 drop 规则，整条日志不应存储
+func (r *Redactor) Apply(entry *LogEntry) bool {
+	hits := make(map[string]int64)
+	drop := false
+
+	redactField := func(name, value string) string {
+		for _, rule := range r.rules {
+			if !rule.appliesTo(name) {
+				continue
+			}
+			value = rule.re.ReplaceAllStringFunc(value, func(match string) string {
+				if rule.validate != nil && !rule.validate(match) {
+					return match
+				}
+				hits[rule.Name]++
+				switch rule.Mode {
+				case "hash":
+					return "[" + rule.Name + ":" + r.hash(match) + "]"
+				case "drop":
+					drop = true
+				}
+				return "[REDACTED:" + rule.Name + "]"
+			})
+		}
+		return value
+	}
+
+	entry.Message = redactField("message", entry.Message)
+	for name, value := range entry.Fields {
+		entry.Fields[name] = redactField(name, value)
+	}
+
+	if len(hits) == 0 {
+		return true
+	}
+
+	r.mu.Lock()
+	for name, count := range hits {
+		r.stats.Redactions[name] += count
+	}
+	if drop {
+		r.stats.Dropped++
+	}
+	r.mu.Unlock()
+
+	return !drop
+}
+
+func (rule *RedactionRule) appliesTo(field string) bool {
+	if len(rule.Fields) == 0 {
+		return true
+	}
+	for _, f := range rule.Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// 带密钥的 HMAC（截断为 16 个十六进制字符）
+func (r *Redactor) hash(value string) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+func (r *Redactor) GetStats() map[string]interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	redactions := make(map[string]int64, len(r.stats.Redactions))
+	for name, count := range r.stats.Redactions {
+		redactions[name] = count
+	}
+
+	return map[string]interface{}{
+		"redactions":        redactions,
+		"redaction_dropped": r.stats.Dropped,
+	}
+}
+
+// Luhn 校验（忽略空格和连字符）
+func luhnValid(s string) bool {
+	digits := strings.Map(func(c rune) rune {
+		if c >= '0' && c <= '9' {
+			return c
+		}
+		return -1
+	}, s)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// 读取 HMAC 密钥：优先 MINILOG_REDACT_KEY，否则使用 dataDir/redact.key（不存在则生成）
+func loadRedactionKey(dataDir string) ([]byte, error) {
+	if key := os.Getenv("MINILOG_REDACT_KEY"); key != "" {
+		return []byte(key), nil
+	}
+
+	path := filepath.Join(dataDir, "redact.key")
+	if data, err := os.ReadFile(path); err == nil && len(data) > 0 {
+		return data, nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	encoded := []byte(hex.EncodeToString(key))
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, encoded, 0600); err != nil {
+		return nil, err
+	}
+	return encoded, nil
+}
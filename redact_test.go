@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactorDefaultRules(t *testing.T) {
+	r, err := NewRedactor(defaultRedactionRules(), "mask", []byte("test-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := LogEntry{
+		Message: "user bob@example.com paid with 4111 1111 1111 1111 from 10.0.0.7, Authorization: Bearer abc.def-123",
+		Fields:  map[string]string{"client_ip": "10.0.0.7", "order": "1234567890123"},
+	}
+	if !r.Apply(&entry) {
+		t.Fatal("mask/hash rules must not drop the entry")
+	}
+	for _, secret := range []string{"bob@example.com", "4111 1111 1111 1111", "abc.def-123", "10.0.0.7"} {
+		if strings.Contains(entry.Message, secret) {
+			t.Errorf("message still contains %q: %s", secret, entry.Message)
+		}
+	}
+	if !strings.Contains(entry.Message, "[REDACTED:credit_card]") || !strings.Contains(entry.Message, "[REDACTED:bearer_token]") {
+		t.Errorf("masked rules missing from %s", entry.Message)
+	}
+	// 相同的值得到相同的摘要，可以跨字段关联
+	ipHash := "[ipv4:" + r.hash("10.0.0.7") + "]"
+	if !strings.Contains(entry.Message, ipHash) || entry.Fields["client_ip"] != ipHash {
+		t.Errorf("ipv4 hash %s not applied consistently: %s / %s", ipHash, entry.Message, entry.Fields["client_ip"])
+	}
+	// 不满足 Luhn 校验的数字不是卡号
+	if entry.Fields["order"] != "1234567890123" {
+		t.Errorf("non-card number redacted: %s", entry.Fields["order"])
+	}
+
+	stats := r.GetStats()["redactions"].(map[string]int64)
+	if stats["ipv4"] != 2 || stats["email"] != 1 || stats["credit_card"] != 1 {
+		t.Errorf("unexpected redaction counts: %v", stats)
+	}
+}
+
+func TestRedactorFieldsAndDrop(t *testing.T) {
+	rules := []RedactionRule{
+		{Name: "ssn", Pattern: `\d{3}-\d{2}-\d{4}`, Mode: "drop", Fields: []string{"ssn"}},
+		{Name: "secret", Pattern: `secret=\S+`},
+	}
+	r, err := NewRedactor(rules, "mask", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 作用字段之外不处理
+	entry := LogEntry{Message: "id 123-45-6789 secret=hunter2"}
+	if !r.Apply(&entry) {
+		t.Error("drop rule scoped to the ssn field dropped a message match")
+	}
+	if entry.Message != "id 123-45-6789 [REDACTED:secret]" {
+		t.Errorf("message = %q", entry.Message)
+	}
+
+	entry = LogEntry{Message: "ok", Fields: map[string]string{"ssn": "123-45-6789"}}
+	if r.Apply(&entry) {
+		t.Error("drop rule did not drop the entry")
+	}
+	if got := r.GetStats()["redaction_dropped"]; got != int64(1) {
+		t.Errorf("redaction_dropped = %v, want 1", got)
+	}
+
+	if _, err := NewRedactor([]RedactionRule{{Name: "x", Pattern: "a", Mode: "hash"}}, "mask", nil); err == nil {
+		t.Error("hash mode without a key should be rejected")
+	}
+}
+
+func TestLuhnValid(t *testing.T) {
+	for s, want := range map[string]bool{
+		"4111 1111 1111 1111": true,
+		"4111-1111-1111-1112": false,
+		"79927398713":         false, // 太短
+		"5500000000000004":    true,
+	} {
+		if got := luhnValid(s); got != want {
+			t.Errorf("luhnValid(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 只在启动时生效的配置项，修改后需要重启
+var restartOnlySettings = []string{"data_dir", "addr", "tls", "storage.remote", "storage.tiers", "replication", "cluster"}
+
+// 配置管理：保存当前生效的配置，SIGHUP 或 API 触发时重新读取并热替换
+type ConfigManager struct {
+	load      func() (*Config, string, error)
+	tenants   *TenantManager
+	redactKey []byte
+	audit     *AuditLog
+
+	mu      sync.Mutex
+	current *Config
+	stats   struct {
+		Reloads    int64
+		Failures   int64
+		LastReload string
+		LastError  string
+	}
+}
+
+// 热加载结果
+type ReloadResult struct {
+	Changed         []string `json:"changed"`                    // 已生效的变更（配置路径）
+	RestartRequired []string `json:"restart_required,omitempty"` // 需要重启才能生效的变更
+}
+
+func NewConfigManager(cfg *Config, load func() (*Config, string, error), tenants *TenantManager, redactKey []byte, audit *AuditLog) *ConfigManager {
+	return &ConfigManager{
+		load:      load,
+		tenants:   tenants,
+		redactKey: redactKey,
+		audit:     audit,
+		current:   cfg,
+	}
+}
+
+func (m *ConfigManager) Current() *Config {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.current
+}
+
+// 重新读取配置（文件 + 环境变量 + 启动参数），校验通过后替换写入链路、存储阈值和保留策略；
+// 任何一步失败都保持原配置
+func (m *ConfigManager) Reload() (*ReloadResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result, err := m.reloadLocked()
+	m.stats.LastReload = time.Now().Format("2006-01-02 15:04:05")
+	if err != nil {
+		m.stats.Failures++
+		m.stats.LastError = err.Error()
+		return nil, err
+	}
+	m.stats.Reloads++
+	m.stats.LastError = ""
+	return result, nil
+}
+
+// 调用方持有 m.mu
+func (m *ConfigManager) reloadLocked() (*ReloadResult, error) {
+	next, _, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ReloadResult{Changed: make([]string, 0)}
+	for _, path := range diffConfig(m.current, next) {
+		if restartOnly(path) {
+			result.RestartRequired = append(result.RestartRequired, path)
+		} else {
+			result.Changed = append(result.Changed, path)
+		}
+	}
+
+	// 需要重启的项保持启动时的值，/api/config 始终反映实际生效的配置
+	next.DataDir = m.current.DataDir
+	next.Addr = m.current.Addr
+	next.TLS = m.current.TLS
+	next.Storage.Remote = m.current.Storage.Remote
+	next.Storage.Tiers = m.current.Storage.Tiers
+	next.Replication = m.current.Replication
+	next.Cluster = m.current.Cluster
+
+	if len(result.Changed) > 0 {
+		if err := m.tenants.Reconfigure(next, next.IngestConfig(m.redactKey)); err != nil {
+			return nil, err
+		}
+	}
+	m.current = next
+	return result, nil
+}
+
+func restartOnly(path string) bool {
+	for _, prefix := range restartOnlySettings {
+		if path == prefix || strings.HasPrefix(path, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// 比较两份配置，返回发生变化的配置路径（如 storage.flush_interval、ingest.rules）
+func diffConfig(a, b *Config) []string {
+	var left, right map[string]interface{}
+	toMap := func(c *Config, out *map[string]interface{}) {
+		data, _ := yaml.Marshal(c)
+		yaml.Unmarshal(data, out)
+	}
+	toMap(a, &left)
+	toMap(b, &right)
+
+	changed := make([]string, 0)
+	diffMaps("", left, right, &changed)
+	sort.Strings(changed)
+	return changed
+}
+
+// 逐层比较 map，列表整体比较（规则和管道按整段报告）
+func diffMaps(prefix string, a, b map[string]interface{}, changed *[]string) {
+	keys := make(map[string]bool)
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+
+	for k := range keys {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		subA, okA := a[k].(map[string]interface{})
+		subB, okB := b[k].(map[string]interface{})
+		if okA && okB {
+			diffMaps(path, subA, subB, changed)
+			continue
+		}
+		if !reflect.DeepEqual(a[k], b[k]) {
+			*changed = append(*changed, path)
+		}
+	}
+}
+
+// SIGHUP 触发的热加载（打印结果并写入审计日志）
+func (m *ConfigManager) ReloadFromSignal() {
+	result, err := m.Reload()
+	if err != nil {
+		fmt.Println("⚠️  Config reload failed, keeping current config:", err)
+		m.audit.Record(AuditEvent{Action: AuditAdmin, Path: "SIGHUP", Status: http.StatusBadRequest,
+			Details: map[string]string{"reload_error": err.Error()}})
+		return
+	}
+	m.printResult(result)
+	m.audit.Record(AuditEvent{Action: AuditAdmin, Path: "SIGHUP", Status: http.StatusOK, Details: result.details()})
+}
+
+func (m *ConfigManager) printResult(result *ReloadResult) {
+	if len(result.Changed) == 0 {
+		fmt.Println("⚙️  Config reloaded: no changes")
+	} else {
+		fmt.Println("⚙️  Config reloaded:", strings.Join(result.Changed, ", "))
+	}
+	if len(result.RestartRequired) > 0 {
+		fmt.Println("⚠️  Restart required to apply:", strings.Join(result.RestartRequired, ", "))
+	}
+}
+
+func (r *ReloadResult) details() map[string]string {
+	details := map[string]string{"changed": strings.Join(r.Changed, ",")}
+	if len(r.RestartRequired) > 0 {
+		details["restart_required"] = strings.Join(r.RestartRequired, ",")
+	}
+	return details
+}
+
+func (m *ConfigManager) GetStats() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return map[string]interface{}{
+		"config_reloads":         m.stats.Reloads,
+		"config_reload_failures": m.stats.Failures,
+		"config_last_reload":     m.stats.LastReload,
+		"config_last_error":      m.stats.LastError,
+	}
+}
+
+// API: 当前生效的配置（隐藏密钥）
+func (m *ConfigManager) handleConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml; charset=utf-8")
+	yaml.NewEncoder(w).Encode(m.Current().Redacted())
+}
+
+// API: 热加载配置（POST），返回生效的变更
+func (m *ConfigManager) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "只接受POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	result, err := m.Reload()
+	if err != nil {
+		m.audit.RecordRequest(r, AuditAdmin, http.StatusBadRequest, AuditEvent{
+			Details: map[string]string{"reload_error": err.Error()},
+		})
+		http.Error(w, "配置加载失败，保持原配置: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	m.printResult(result)
+	m.audit.RecordRequest(r, AuditAdmin, http.StatusOK, AuditEvent{Details: result.details()})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,1171 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 主从复制：follower 连接 leader，按租户拉取缓冲中的日志（WAL 记录）和已经刷盘的段，只读地提供查询；
+// leader 故障时把 follower 提升为 leader，开始接收写入。
+//
+// leader 给每个租户缓冲中的日志按顺序编号，follower 请求时带上的 offset 就是确认位置（offset-1 之前都已收到）；
+// 编号在 leader 重启或提升时重新开始（epoch 变化），follower 随之丢弃缓冲、重新同步。
+// 段按索引哈希比较：本地索引是 leader 索引的前缀时只下载新增的块，否则（被压缩改写）整段下载。
+//
+//	GET  /api/replication/wal?offset=N&wait=10s&follower=ID     从序号 N 开始的缓冲日志（没有时长轮询）
+//	GET  /api/replication/segments                              段清单（大小、索引哈希）和已经刷盘的序号
+//	GET  /api/replication/segment?hour=H&tier=T                 段索引
+//	GET  /api/replication/segment?hour=H&tier=T&from=O&chunks=N&sha=X   段数据（前 N 块的索引哈希必须是 X）
+//	GET  /api/replication/status                                复制状态和延迟（两端都可用）
+//	POST /api/replication/promote                               把 follower 提升为 leader
+const (
+	replicationWait       = 10 * time.Second // 长轮询时长
+	replicationBatchLimit = 1000             // 每次最多返回的日志条数
+	replicationMarker     = "replication.json"
+)
+
+var errSegmentChanged = errors.New("segment changed on leader")
+
+// 复制配置（只在启动时读取）
+type ReplicationConfig struct {
+	Leader   string        `yaml:"leader"`   // leader 地址（如 http://10.0.0.1:8080），为空表示不作为 follower
+	Key      string        `yaml:"key"`      // leader 上的管理员 API key（也可以用 MINILOG_REPLICATION_KEY）
+	ID       string        `yaml:"id"`       // follower 名称（默认主机名）
+	Interval time.Duration `yaml:"interval"` // 段同步间隔（leader 刷盘后也会立即同步）
+}
+
+func (c ReplicationConfig) nodeID() string {
+	if c.ID != "" {
+		return c.ID
+	}
+	if host, err := os.Hostname(); err == nil {
+		return host
+	}
+	return "follower"
+}
+
+// 单个租户存储的复制位置（由 bufferMu 保护）
+type replicationState struct {
+	epoch   string        // leader：重启或提升时变化
+	base    int64         // leader：memoryBuffer[0] 的序号
+	flushed int64         // 已经写入段文件的最大序号（follower 为已同步的段对应的序号）
+	notify  chan struct{} // leader：有新日志或刷盘后关闭，唤醒长轮询
+	seqs    []int64       // follower：缓冲中每条日志在 leader 上的序号
+	applied int64         // follower：已经收到的最大序号
+}
+
+func newReplicationState() replicationState {
+	return replicationState{epoch: strconv.FormatInt(time.Now().UnixNano(), 36), base: 1}
+}
+
+// 唤醒等待中的 follower（调用方持有 bufferMu）
+func (r *replicationState) wake() {
+	if r.notify != nil {
+		close(r.notify)
+		r.notify = nil
+	}
+}
+
+// 缓冲中的一条日志
+type replicationRecord struct {
+	Seq   int64    `json:"seq"`
+	Entry LogEntry `json:"entry"`
+}
+
+// /api/replication/wal 的响应
+type replicationBatch struct {
+	Epoch   string              `json:"epoch"`
+	Head    int64               `json:"head"`    // 最新日志的序号
+	Flushed int64               `json:"flushed"` // 已经刷盘的序号
+	Records []replicationRecord `json:"records"`
+}
+
+// leader：从 offset 开始的缓冲日志；没有新日志时返回一个在下次写入或刷盘时关闭的通道
+func (s *LogStorage) replicationBatch(offset int64, limit int) (replicationBatch, <-chan struct{}) {
+	s.bufferMu.Lock()
+	defer s.bufferMu.Unlock()
+
+	head := s.repl.base + int64(len(s.memoryBuffer)) - 1
+	batch := replicationBatch{Epoch: s.repl.epoch, Head: head, Flushed: s.repl.flushed, Records: make([]replicationRecord, 0)}
+	// 早于 base 的日志正在刷盘或已经在段里，follower 通过段同步拿到
+	seq := offset
+	if seq < s.repl.base {
+		seq = s.repl.base
+	}
+	for ; seq <= head && len(batch.Records) < limit; seq++ {
+		batch.Records = append(batch.Records, replicationRecord{Seq: seq, Entry: s.memoryBuffer[seq-s.repl.base]})
+	}
+	if len(batch.Records) > 0 {
+		return batch, nil
+	}
+	if s.repl.notify == nil {
+		s.repl.notify = make(chan struct{})
+	}
+	return batch, s.repl.notify
+}
+
+func (s *LogStorage) replicationHead() int64 {
+	s.bufferMu.RLock()
+	defer s.bufferMu.RUnlock()
+	return s.repl.base + int64(len(s.memoryBuffer)) - 1
+}
+
+// follower：追加从 leader 收到的日志（已经收到的和已经在段里的跳过），返回追加的条数
+func (s *LogStorage) applyReplicated(records []replicationRecord) int {
+	s.bufferMu.Lock()
+	defer s.bufferMu.Unlock()
+
+	applied := 0
+	for _, rec := range records {
+		if rec.Seq <= s.repl.applied || rec.Seq <= s.repl.flushed {
+			continue
+		}
+		log := rec.Entry
+		log.NormLevel = normalizeLevel(log.Level)
+		s.memoryBuffer = append(s.memoryBuffer, log)
+		s.repl.seqs = append(s.repl.seqs, rec.Seq)
+		s.bufferBytes += entrySize(log)
+		s.stats.TotalReceived++
+		s.stats.LevelCounts[log.NormLevel]++
+		s.repl.applied = rec.Seq
+		applied++
+	}
+	return applied
+}
+
+// follower：段已经同步到 leader 的 flushed，删除缓冲中已经在段里的日志
+func (s *LogStorage) trimReplicated(flushed int64) {
+	s.bufferMu.Lock()
+	defer s.bufferMu.Unlock()
+
+	if flushed <= s.repl.flushed {
+		return
+	}
+	s.repl.flushed = flushed
+	if s.repl.applied < flushed {
+		s.repl.applied = flushed
+	}
+	keep := 0
+	for i, seq := range s.repl.seqs {
+		if seq > flushed {
+			s.memoryBuffer[keep] = s.memoryBuffer[i]
+			s.repl.seqs[keep] = seq
+			keep++
+			continue
+		}
+		s.bufferBytes -= entrySize(s.memoryBuffer[i])
+		s.stats.TotalCompressed++
+	}
+	s.memoryBuffer = s.memoryBuffer[:keep]
+	s.repl.seqs = s.repl.seqs[:keep]
+}
+
+// follower：leader 的 epoch 变化，丢弃缓冲，从头同步（段文件保留，按索引哈希重新比较）
+func (s *LogStorage) resetReplica() {
+	s.bufferMu.Lock()
+	defer s.bufferMu.Unlock()
+	s.memoryBuffer = s.memoryBuffer[:0]
+	s.bufferBytes = 0
+	s.repl.seqs = nil
+	s.repl.applied = 0
+	s.repl.flushed = 0
+}
+
+// follower：已经收到的位置（缓冲或段里最大的序号）
+func (s *LogStorage) replicaPosition() int64 {
+	s.bufferMu.RLock()
+	defer s.bufferMu.RUnlock()
+	if s.repl.flushed > s.repl.applied {
+		return s.repl.flushed
+	}
+	return s.repl.applied
+}
+
+// 提升为 leader：缓冲中的日志补写本地 WAL，重新编号（新的 epoch），然后立即刷盘
+func (s *LogStorage) promoteReplica() {
+	s.bufferMu.Lock()
+	if !s.replica.Load() {
+		s.bufferMu.Unlock()
+		return
+	}
+	if s.wal != nil {
+		for _, log := range s.memoryBuffer {
+			if err := s.wal.append(formatLogLine(log)); err != nil {
+				fmt.Println("⚠️  WAL write failed:", err)
+				break
+			}
+		}
+	}
+	s.repl = newReplicationState()
+	s.replica.Store(false)
+	s.bufferMu.Unlock()
+
+	s.flushToDisk()
+}
+
+// 段清单中的一个段（冷层的段没有索引哈希，follower 不复制）
+type replicaSegment struct {
+	Hour   string `json:"hour"`
+	Tier   string `json:"tier"`
+	Bytes  int64  `json:"bytes"`
+	Chunks int    `json:"chunks"`
+	Index  string `json:"index,omitempty"` // 索引内容的 SHA-256
+}
+
+// /api/replication/segments 的响应：段文件和 flushed 在刷盘锁内一起读取，保持一致
+type replicationManifest struct {
+	Epoch    string           `json:"epoch"`
+	Flushed  int64            `json:"flushed"`
+	Segments []replicaSegment `json:"segments"`
+}
+
+func indexHash(chunks []chunkIndex) string {
+	sum := sha256.Sum256(encodeSegmentIndex(chunks))
+	return hex.EncodeToString(sum[:])
+}
+
+func chunksEnd(chunks []chunkIndex) int64 {
+	if len(chunks) == 0 {
+		return 0
+	}
+	last := chunks[len(chunks)-1]
+	return last.Offset + last.Length
+}
+
+// leader：列出热层和温层的段以及冷层的段名（索引失效的段没有哈希，重建后下一轮再同步）
+func (s *LogStorage) replicationSegments() (replicationManifest, error) {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.bufferMu.RLock()
+	manifest := replicationManifest{Epoch: s.repl.epoch, Flushed: s.repl.flushed, Segments: make([]replicaSegment, 0)}
+	s.bufferMu.RUnlock()
+
+	for _, path := range listSegments(s.dataDir) {
+		seg := replicaSegment{Hour: segmentHour(path), Tier: tierHot}
+		if chunks, err := s.replicationIndex(tierHot, seg.Hour); err == nil {
+			seg.Bytes, seg.Chunks, seg.Index = chunksEnd(chunks), len(chunks), indexHash(chunks)
+		}
+		manifest.Segments = append(manifest.Segments, seg)
+	}
+	for _, tier := range []string{tierWarm, tierCold} {
+		store := s.tierStore(tier)
+		if store == nil {
+			continue
+		}
+		hours, err := storeSegments(store)
+		if err != nil {
+			return manifest, fmt.Errorf("list %s segments: %v", tier, err)
+		}
+		for hour, size := range hours {
+			seg := replicaSegment{Hour: hour, Tier: tier, Bytes: size}
+			if tier == tierWarm {
+				if chunks, err := s.replicationIndex(tier, hour); err == nil {
+					seg.Chunks, seg.Index = len(chunks), indexHash(chunks)
+				}
+			}
+			manifest.Segments = append(manifest.Segments, seg)
+		}
+	}
+	sort.Slice(manifest.Segments, func(i, j int) bool {
+		return manifest.Segments[i].Hour < manifest.Segments[j].Hour
+	})
+	return manifest, nil
+}
+
+// leader：热层或温层段的有效索引
+func (s *LogStorage) replicationIndex(tier, hour string) ([]chunkIndex, error) {
+	switch tier {
+	case tierHot:
+		path := segmentPath(s.dataDir, hour)
+		s.swapMu.RLock()
+		defer s.swapMu.RUnlock()
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		chunks, ok := readSegmentIndex(path, info.Size())
+		if !ok {
+			return nil, fmt.Errorf("index of %s is stale", hour)
+		}
+		return chunks, nil
+	case tierWarm:
+		if s.warm == nil {
+			return nil, errObjectNotFound
+		}
+		chunks, ok := s.storeIndex(tierWarm, hour)
+		if !ok {
+			return nil, fmt.Errorf("index of %s is stale", hour)
+		}
+		return chunks, nil
+	}
+	return nil, fmt.Errorf("%s segments are not replicated", tier)
+}
+
+// leader：读取段的 [from, 第 chunks 块结尾)；前 chunks 块的索引哈希与 sha 不一致（段被改写）时返回 errSegmentChanged
+func (s *LogStorage) replicationSegmentData(tier, hour string, from int64, chunks int, sha string) ([]byte, error) {
+	if tier == tierHot {
+		// 持有 swapMu 直到读完，压缩不能在检查之后替换段文件
+		s.swapMu.RLock()
+		defer s.swapMu.RUnlock()
+	}
+	index, err := s.replicationIndexLocked(tier, hour)
+	if err != nil {
+		return nil, err
+	}
+	if chunks < 1 || chunks > len(index) || indexHash(index[:chunks]) != sha {
+		return nil, errSegmentChanged
+	}
+	end := chunksEnd(index[:chunks])
+	if from < 0 || from > end {
+		return nil, fmt.Errorf("offset %d out of range", from)
+	}
+	if tier == tierWarm {
+		return s.warm.GetRange(segmentKey(hour), from, end-from)
+	}
+	f, err := os.Open(segmentPath(s.dataDir, hour))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, end-from)
+	if _, err := f.ReadAt(data, from); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// 和 replicationIndex 相同，热层由调用方持有 swapMu
+func (s *LogStorage) replicationIndexLocked(tier, hour string) ([]chunkIndex, error) {
+	if tier != tierHot {
+		return s.replicationIndex(tier, hour)
+	}
+	path := segmentPath(s.dataDir, hour)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	chunks, ok := readSegmentIndex(path, info.Size())
+	if !ok {
+		return nil, fmt.Errorf("index of %s is stale", hour)
+	}
+	return chunks, nil
+}
+
+// follower：本地段的有效索引（不存在或失效时为空）
+func (s *LogStorage) replicaIndex(hour string) []chunkIndex {
+	path := segmentPath(s.dataDir, hour)
+	s.swapMu.RLock()
+	defer s.swapMu.RUnlock()
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	chunks, _ := readSegmentIndex(path, info.Size())
+	return chunks
+}
+
+// follower：写入从 leader 下载的段数据；from 为 0 时整段替换，否则追加到本地段末尾（本地大小必须等于 from）
+func (s *LogStorage) writeReplicaSegment(hour string, from int64, data []byte, index []chunkIndex) error {
+	path := segmentPath(s.dataDir, hour)
+	s.swapMu.Lock()
+	defer s.swapMu.Unlock()
+
+	if from == 0 {
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, data, 0644); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		return writeSegmentIndex(path, index)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err == nil && info.Size() != from {
+		err = fmt.Errorf("local size %d, expected %d", info.Size(), from)
+	}
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return writeSegmentIndex(path, index)
+}
+
+// follower：删除 leader 上已经不存在的段（保留策略或手动删除）
+func (s *LogStorage) removeReplicaSegment(hour string) error {
+	path := segmentPath(s.dataDir, hour)
+	s.swapMu.Lock()
+	defer s.swapMu.Unlock()
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	os.Remove(indexPath(path))
+	return nil
+}
+
+// 复制管理：leader 端记录各 follower 的确认位置，follower 端运行同步任务
+type ReplicationManager struct {
+	cfg     ReplicationConfig
+	tenants *TenantManager
+	dataDir string
+	client  *http.Client
+	trigger chan struct{} // 立即同步段（leader 刷盘后）
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	mu          sync.Mutex
+	following   bool
+	promotedAt  string
+	followers   map[string]*followerLink   // leader 端：follower 名称 -> 确认位置
+	tracked     map[string]*followerTenant // follower 端：租户 -> 同步状态
+	connected   bool
+	lastContact time.Time
+	lastError   string
+}
+
+// leader 端看到的一个 follower
+type followerLink struct {
+	addr     string
+	lastSeen time.Time
+	tenants  map[string]*followerAck
+}
+
+type followerAck struct {
+	acked    int64
+	caughtUp time.Time // 最近一次确认到最新日志的时间
+}
+
+// follower 端一个租户的同步状态
+type followerTenant struct {
+	id   string
+	logs *LogStorage
+
+	mu            sync.Mutex // 应用日志、同步段和 epoch 切换互斥
+	epoch         string
+	head          int64 // leader 最新序号
+	leaderFlushed int64
+	caughtUp      time.Time
+	segments      int64 // 下载过的段数
+	bytes         int64
+	coldSkipped   int // leader 冷层中本地没有的段
+	lastSync      time.Time
+	lastError     string
+}
+
+// 提升记录：提升过的节点重启后不再作为 follower（即使配置了 replication.leader）
+type promotionMarker struct {
+	PromotedAt string `json:"promoted_at"`
+	Leader     string `json:"leader"`
+}
+
+func NewReplicationManager(cfg ReplicationConfig, tenants *TenantManager, dataDir string) *ReplicationManager {
+	m := &ReplicationManager{
+		cfg:       cfg,
+		tenants:   tenants,
+		dataDir:   dataDir,
+		client:    &http.Client{},
+		trigger:   make(chan struct{}, 1),
+		followers: make(map[string]*followerLink),
+		tracked:   make(map[string]*followerTenant),
+	}
+	if cfg.Leader == "" {
+		return m
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dataDir, replicationMarker)); err == nil {
+		var marker promotionMarker
+		json.Unmarshal(data, &marker)
+		m.promotedAt = marker.PromotedAt
+		fmt.Printf("⚠️  [Replication] Promoted to leader at %s, ignoring replication.leader %s (remove %s to follow again)\n",
+			marker.PromotedAt, cfg.Leader, filepath.Join(dataDir, replicationMarker))
+		return m
+	}
+
+	// 先标记所有租户为副本，避免本地刷盘、压缩和保留策略改动同步来的段
+	for _, t := range tenants.List() {
+		t.Logs.replica.Store(true)
+	}
+	m.following = true
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.wg.Add(1)
+	go m.follow(ctx)
+	fmt.Printf("🔁 [Replication] Following %s as %s (read-only)\n", cfg.Leader, cfg.nodeID())
+	return m
+}
+
+// 是否是只读 follower（拒绝写入）
+func (m *ReplicationManager) Following() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.following
+}
+
+// 定期发现租户并同步段，每个租户一个拉取日志的任务
+func (m *ReplicationManager) follow(ctx context.Context) {
+	defer m.wg.Done()
+	for {
+		m.syncOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.trigger:
+		case <-time.After(m.cfg.Interval):
+		}
+	}
+}
+
+func (m *ReplicationManager) syncOnce(ctx context.Context) {
+	var status struct {
+		Role    string   `json:"role"`
+		Tenants []string `json:"tenants"`
+	}
+	data, err := m.get(ctx, defaultTenant, "/api/replication/status", nil, time.Minute)
+	if err == nil {
+		err = json.Unmarshal(data, &status)
+	}
+	m.recordContact(err)
+	if err != nil {
+		return
+	}
+	for _, id := range status.Tenants {
+		ft, err := m.followTenant(ctx, id)
+		if err != nil {
+			fmt.Printf("⚠️  [Replication] Cannot follow tenant %s: %v\n", id, err)
+			continue
+		}
+		if err := m.syncSegments(ctx, ft); err != nil && ctx.Err() == nil {
+			ft.mu.Lock()
+			ft.lastError = err.Error()
+			ft.mu.Unlock()
+			if !errors.Is(err, errSegmentChanged) {
+				fmt.Printf("⚠️  [Replication] Segment sync for tenant %s failed: %v\n", id, err)
+			}
+		}
+	}
+}
+
+// 开始跟随一个租户（本地不存在时创建）
+func (m *ReplicationManager) followTenant(ctx context.Context, id string) (*followerTenant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ft, ok := m.tracked[id]; ok {
+		return ft, nil
+	}
+	t, err := m.tenants.Create(id)
+	if err != nil {
+		return nil, err
+	}
+	t.Logs.replica.Store(true)
+	ft := &followerTenant{id: id, logs: t.Logs}
+	m.tracked[id] = ft
+	m.wg.Add(1)
+	go m.streamWAL(ctx, ft)
+	return ft, nil
+}
+
+// 检查 leader 的 epoch（调用方持有 ft.mu）：变化时丢弃缓冲从头同步，返回是否变化
+func (ft *followerTenant) checkEpoch(epoch string) bool {
+	if ft.epoch == epoch {
+		return false
+	}
+	if ft.epoch != "" {
+		fmt.Printf("🔁 [Replication] Leader restarted (tenant %s), resyncing\n", ft.id)
+	}
+	ft.logs.resetReplica()
+	ft.epoch = epoch
+	ft.head = 0
+	ft.leaderFlushed = 0
+	return true
+}
+
+// 持续拉取租户缓冲中的日志（长轮询）
+func (m *ReplicationManager) streamWAL(ctx context.Context, ft *followerTenant) {
+	defer m.wg.Done()
+	for ctx.Err() == nil {
+		ft.mu.Lock()
+		epoch := ft.epoch
+		offset := ft.logs.replicaPosition() + 1
+		ft.mu.Unlock()
+
+		query := url.Values{"offset": {strconv.FormatInt(offset, 10)}, "wait": {replicationWait.String()}, "follower": {m.cfg.nodeID()}}
+		data, err := m.get(ctx, ft.id, "/api/replication/wal", query, replicationWait+30*time.Second)
+		var batch replicationBatch
+		if err == nil {
+			err = json.Unmarshal(data, &batch)
+		}
+		m.recordContact(err)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(2 * time.Second):
+			}
+			continue
+		}
+
+		ft.mu.Lock()
+		// offset 是按旧 epoch 计算的（或者段同步已经切换了 epoch），丢弃这次结果重新请求
+		if epoch != batch.Epoch || ft.epoch != batch.Epoch {
+			if ft.epoch == epoch {
+				ft.checkEpoch(batch.Epoch)
+			}
+			ft.mu.Unlock()
+			m.syncNow()
+			continue
+		}
+		ft.logs.applyReplicated(batch.Records)
+		ft.head = batch.Head
+		ft.leaderFlushed = batch.Flushed
+		if ft.logs.replicaPosition() >= batch.Head {
+			ft.caughtUp = time.Now()
+		}
+		ft.mu.Unlock()
+
+		if batch.Flushed > ft.logs.replicaFlushed() {
+			m.syncNow()
+		}
+	}
+}
+
+func (s *LogStorage) replicaFlushed() int64 {
+	s.bufferMu.RLock()
+	defer s.bufferMu.RUnlock()
+	return s.repl.flushed
+}
+
+func (m *ReplicationManager) syncNow() {
+	select {
+	case m.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// 按 leader 的段清单同步一个租户：下载缺少或变化的段，删除 leader 上已经不存在的段，最后丢弃缓冲中已经在段里的日志
+func (m *ReplicationManager) syncSegments(ctx context.Context, ft *followerTenant) error {
+	data, err := m.get(ctx, ft.id, "/api/replication/segments", nil, time.Minute)
+	if err != nil {
+		return err
+	}
+	var manifest replicationManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return err
+	}
+
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	ft.checkEpoch(manifest.Epoch)
+
+	// 同一个小时可能短暂同时出现在热层和温层（正在移动），以热层为准
+	segments := make(map[string]replicaSegment)
+	for _, seg := range manifest.Segments {
+		if prev, ok := segments[seg.Hour]; !ok || prev.Tier != tierHot {
+			segments[seg.Hour] = seg
+		}
+	}
+
+	coldSkipped := 0
+	for _, hour := range sortedHours(segments) {
+		seg := segments[hour]
+		if seg.Index == "" {
+			if seg.Tier == tierCold && ft.logs.replicaIndex(hour) == nil {
+				coldSkipped++
+			}
+			continue
+		}
+		if err := m.syncSegment(ctx, ft, seg); err != nil {
+			return fmt.Errorf("%s: %w", hour, err)
+		}
+	}
+	for _, path := range listSegments(ft.logs.dataDir) {
+		hour := segmentHour(path)
+		if _, ok := segments[hour]; ok {
+			continue
+		}
+		if err := ft.logs.removeReplicaSegment(hour); err == nil {
+			fmt.Printf("🗑️  [Replication] Removed segment %s (tenant %s), gone on leader\n", hour, ft.id)
+		}
+	}
+
+	ft.logs.trimReplicated(manifest.Flushed)
+	ft.coldSkipped = coldSkipped
+	ft.lastSync = time.Now()
+	ft.lastError = ""
+	return nil
+}
+
+func sortedHours(segments map[string]replicaSegment) []string {
+	hours := make([]string, 0, len(segments))
+	for hour := range segments {
+		hours = append(hours, hour)
+	}
+	sort.Strings(hours)
+	return hours
+}
+
+// 同步一个段（调用方持有 ft.mu）
+func (m *ReplicationManager) syncSegment(ctx context.Context, ft *followerTenant, seg replicaSegment) error {
+	local := ft.logs.replicaIndex(seg.Hour)
+	if indexHash(local) == seg.Index {
+		return nil
+	}
+
+	data, err := m.get(ctx, ft.id, "/api/replication/segment", url.Values{"hour": {seg.Hour}, "tier": {seg.Tier}}, time.Minute)
+	if err != nil {
+		return err
+	}
+	index, ok := parseSegmentIndex(data, -1)
+	if !ok || len(index) == 0 {
+		return fmt.Errorf("invalid index from leader")
+	}
+	sha := indexHash(index)
+	if sha == indexHash(local) {
+		return nil
+	}
+
+	// 本地索引是 leader 索引的前缀时只下载新增的块
+	var from int64
+	if len(local) > 0 && len(local) < len(index) && indexHash(index[:len(local)]) == indexHash(local) {
+		from = chunksEnd(local)
+	}
+	query := url.Values{
+		"hour":   {seg.Hour},
+		"tier":   {seg.Tier},
+		"from":   {strconv.FormatInt(from, 10)},
+		"chunks": {strconv.Itoa(len(index))},
+		"sha":    {sha},
+	}
+	body, err := m.get(ctx, ft.id, "/api/replication/segment", query, 10*time.Minute)
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) != chunksEnd(index)-from {
+		return fmt.Errorf("short segment data: %d bytes, expected %d", len(body), chunksEnd(index)-from)
+	}
+	if err := ft.logs.writeReplicaSegment(seg.Hour, from, body, index); err != nil {
+		return err
+	}
+	ft.segments++
+	ft.bytes += int64(len(body))
+	return nil
+}
+
+// 请求 leader（X-Tenant 选择租户）；409 表示段在下载过程中被改写
+func (m *ReplicationManager) get(ctx context.Context, tenant, path string, query url.Values, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	target := strings.TrimRight(m.cfg.Leader, "/") + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.cfg.Key)
+	req.Header.Set("X-Tenant", tenant)
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusConflict {
+		return nil, errSegmentChanged
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("leader returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// 记录和 leader 的连接状态（只在状态变化时打印）
+func (m *ReplicationManager) recordContact(err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		if m.connected || m.lastError == "" {
+			fmt.Printf("⚠️  [Replication] Lost connection to leader %s: %v\n", m.cfg.Leader, err)
+		}
+		m.connected = false
+		m.lastError = err.Error()
+		return
+	}
+	if !m.connected {
+		fmt.Printf("🔗 [Replication] Connected to leader %s\n", m.cfg.Leader)
+	}
+	m.connected = true
+	m.lastContact = time.Now()
+	m.lastError = ""
+}
+
+// 把 follower 提升为 leader：停止同步，缓冲中的日志刷盘，开始接收写入
+func (m *ReplicationManager) Promote() error {
+	m.mu.Lock()
+	if !m.following {
+		m.mu.Unlock()
+		return fmt.Errorf("not a follower")
+	}
+	m.following = false
+	m.mu.Unlock()
+
+	m.cancel()
+	m.wg.Wait()
+
+	now := time.Now().Format(time.RFC3339)
+	data, _ := json.MarshalIndent(promotionMarker{PromotedAt: now, Leader: m.cfg.Leader}, "", "  ")
+	if err := os.WriteFile(filepath.Join(m.dataDir, replicationMarker), data, 0644); err != nil {
+		fmt.Println("⚠️  Cannot write promotion marker:", err)
+	}
+	for _, t := range m.tenants.List() {
+		t.Logs.promoteReplica()
+	}
+
+	m.mu.Lock()
+	m.promotedAt = now
+	m.mu.Unlock()
+	fmt.Printf("👑 [Replication] Promoted to leader (was following %s)\n", m.cfg.Leader)
+	return nil
+}
+
+// leader 端记录 follower 的确认位置；upToDate 表示 follower 在等待新日志时已经是最新的
+func (m *ReplicationManager) observe(follower, addr, tenant string, acked int64, upToDate bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	link, ok := m.followers[follower]
+	if !ok {
+		link = &followerLink{tenants: make(map[string]*followerAck)}
+		m.followers[follower] = link
+		fmt.Printf("🔁 [Replication] Follower %s connected from %s\n", follower, addr)
+	}
+	link.addr = addr
+	link.lastSeen = time.Now()
+	ack, ok := link.tenants[tenant]
+	if !ok {
+		ack = &followerAck{caughtUp: time.Now()}
+		link.tenants[tenant] = ack
+	}
+	ack.acked = acked
+	if upToDate {
+		ack.caughtUp = time.Now()
+	}
+}
+
+// 复制延迟（两端都用，也用于 /metrics）
+type replicationLag struct {
+	Role     string  `json:"-"`
+	Follower string  `json:"-"`
+	Tenant   string  `json:"tenant"`
+	Applied  int64   `json:"applied"`
+	Head     int64   `json:"head"`
+	Entries  int64   `json:"lag_entries"`
+	Seconds  float64 `json:"lag_seconds"`
+}
+
+func lagOf(applied, head int64, caughtUp, now time.Time) (int64, float64) {
+	if applied >= head {
+		return 0, 0
+	}
+	return head - applied, now.Sub(caughtUp).Seconds()
+}
+
+// 所有延迟：leader 端每个 follower 每个租户一条，follower 端每个租户一条
+func (m *ReplicationManager) lags() []replicationLag {
+	now := time.Now()
+	m.mu.Lock()
+	following, connected := m.following, m.connected
+	ids := make([]string, 0, len(m.followers))
+	for id := range m.followers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	type ackSnapshot struct {
+		follower, tenant string
+		ack              followerAck
+	}
+	acks := make([]ackSnapshot, 0)
+	for _, id := range ids {
+		link := m.followers[id]
+		for tenant, ack := range link.tenants {
+			acks = append(acks, ackSnapshot{id, tenant, *ack})
+		}
+	}
+	tracked := make([]*followerTenant, 0, len(m.tracked))
+	for _, ft := range m.tracked {
+		tracked = append(tracked, ft)
+	}
+	m.mu.Unlock()
+
+	result := make([]replicationLag, 0)
+	for _, a := range acks {
+		t, err := m.tenants.Get(a.tenant)
+		if err != nil {
+			continue
+		}
+		head := t.Logs.replicationHead()
+		entries, seconds := lagOf(a.ack.acked, head, a.ack.caughtUp, now)
+		result = append(result, replicationLag{Role: "leader", Follower: a.follower, Tenant: a.tenant, Applied: a.ack.acked, Head: head, Entries: entries, Seconds: seconds})
+	}
+	if following {
+		sort.Slice(tracked, func(i, j int) bool { return tracked[i].id < tracked[j].id })
+		for _, ft := range tracked {
+			applied := ft.logs.replicaPosition()
+			ft.mu.Lock()
+			entries, seconds := lagOf(applied, ft.head, ft.caughtUp, now)
+			// 断开时 leader 上可能已经有新日志，延迟按最后一次追上的时间计算
+			if !connected && !ft.caughtUp.IsZero() {
+				seconds = now.Sub(ft.caughtUp).Seconds()
+			}
+			result = append(result, replicationLag{Role: "follower", Follower: m.cfg.nodeID(), Tenant: ft.id, Applied: applied, Head: ft.head, Entries: entries, Seconds: seconds})
+			ft.mu.Unlock()
+		}
+	}
+	return result
+}
+
+// 复制状态（/api/replication/status）
+func (m *ReplicationManager) Status() map[string]interface{} {
+	tenantIDs := make([]string, 0)
+	for _, t := range m.tenants.List() {
+		tenantIDs = append(tenantIDs, t.ID)
+	}
+	lags := m.lags()
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := map[string]interface{}{"role": "leader", "tenants": tenantIDs}
+	if m.promotedAt != "" {
+		status["promoted_at"] = m.promotedAt
+	}
+
+	followers := make([]map[string]interface{}, 0)
+	for _, id := range sortedKeys(m.followers) {
+		link := m.followers[id]
+		tenants := make([]replicationLag, 0)
+		for _, lag := range lags {
+			if lag.Role == "leader" && lag.Follower == id {
+				tenants = append(tenants, lag)
+			}
+		}
+		followers = append(followers, map[string]interface{}{
+			"id":        id,
+			"addr":      link.addr,
+			"last_seen": link.lastSeen.Format("2006-01-02 15:04:05"),
+			"connected": now.Sub(link.lastSeen) < replicationWait+15*time.Second,
+			"tenants":   tenants,
+		})
+	}
+	status["followers"] = followers
+
+	if m.following {
+		status["role"] = "follower"
+		tenants := make([]map[string]interface{}, 0)
+		for _, lag := range lags {
+			if lag.Role != "follower" {
+				continue
+			}
+			ft := m.tracked[lag.Tenant]
+			ft.mu.Lock()
+			entry := map[string]interface{}{
+				"tenant":           lag.Tenant,
+				"epoch":            ft.epoch,
+				"applied":          lag.Applied,
+				"head":             lag.Head,
+				"leader_flushed":   ft.leaderFlushed,
+				"lag_entries":      lag.Entries,
+				"lag_seconds":      lag.Seconds,
+				"segments_fetched": ft.segments,
+				"bytes_fetched":    ft.bytes,
+				"cold_skipped":     ft.coldSkipped,
+			}
+			if !ft.lastSync.IsZero() {
+				entry["last_segment_sync"] = ft.lastSync.Format("2006-01-02 15:04:05")
+			}
+			if ft.lastError != "" {
+				entry["last_error"] = ft.lastError
+			}
+			ft.mu.Unlock()
+			tenants = append(tenants, entry)
+		}
+		leader := map[string]interface{}{
+			"url":       m.cfg.Leader,
+			"id":        m.cfg.nodeID(),
+			"connected": m.connected,
+			"tenants":   tenants,
+		}
+		if !m.lastContact.IsZero() {
+			leader["last_contact"] = m.lastContact.Format("2006-01-02 15:04:05")
+		}
+		if m.lastError != "" {
+			leader["last_error"] = m.lastError
+		}
+		status["leader"] = leader
+	}
+	return status
+}
+
+func sortedKeys(followers map[string]*followerLink) []string {
+	keys := make([]string, 0, len(followers))
+	for id := range followers {
+		keys = append(keys, id)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Prometheus：复制延迟（role=leader 为 leader 看到的各 follower，role=follower 为本节点）
+func (m *ReplicationManager) writeMetrics(p *promWriter) {
+	lags := m.lags()
+	if len(lags) == 0 {
+		return
+	}
+	p.header("minilog_replication_lag_entries", "gauge", "Log entries the follower has not received yet.")
+	for _, lag := range lags {
+		p.sample("minilog_replication_lag_entries", float64(lag.Entries), "role", lag.Role, "follower", lag.Follower, "tenant", lag.Tenant)
+	}
+	p.header("minilog_replication_lag_seconds", "gauge", "Seconds since the follower was last caught up.")
+	for _, lag := range lags {
+		p.sample("minilog_replication_lag_seconds", lag.Seconds, "role", lag.Role, "follower", lag.Follower, "tenant", lag.Tenant)
+	}
+}
+
+// API: 复制（仅管理员，作用于 X-Tenant 选择的租户）
+func (m *ReplicationManager) handleReplication(w http.ResponseWriter, r *http.Request) {
+	tenant := tenantFrom(r)
+	logs := tenant.Logs
+	action := strings.TrimPrefix(r.URL.Path, "/api/replication/")
+	q := r.URL.Query()
+
+	if r.Method != "GET" {
+		http.Error(w, "只接受 GET", http.StatusMethodNotAllowed)
+		return
+	}
+	if action != "status" && m.Following() {
+		http.Error(w, "follower 不能作为 leader 被复制", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch action {
+	case "status":
+		json.NewEncoder(w).Encode(m.Status())
+
+	case "wal":
+		offset, _ := strconv.ParseInt(q.Get("offset"), 10, 64)
+		if offset < 1 {
+			offset = 1
+		}
+		wait, _ := time.ParseDuration(q.Get("wait"))
+		if wait > 30*time.Second {
+			wait = 30 * time.Second
+		}
+		follower := q.Get("follower")
+		if follower == "" {
+			follower = r.RemoteAddr
+		}
+
+		batch, notify := logs.replicationBatch(offset, replicationBatchLimit)
+		upToDate := len(batch.Records) == 0
+		m.observe(follower, r.RemoteAddr, tenant.ID, offset-1, upToDate)
+		if upToDate && wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-notify:
+			case <-timer.C:
+			case <-r.Context().Done():
+				timer.Stop()
+				return
+			}
+			timer.Stop()
+			batch, _ = logs.replicationBatch(offset, replicationBatchLimit)
+		}
+		m.observe(follower, r.RemoteAddr, tenant.ID, offset-1, upToDate)
+		json.NewEncoder(w).Encode(batch)
+
+	case "segments":
+		manifest, err := logs.replicationSegments()
+		if err != nil {
+			http.Error(w, "读取段清单失败: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(manifest)
+
+	case "segment":
+		hour, tier := q.Get("hour"), q.Get("tier")
+		if _, err := time.Parse("2006-01-02-15", hour); err != nil {
+			http.Error(w, "hour 参数错误", http.StatusBadRequest)
+			return
+		}
+		if q.Get("from") == "" {
+			index, err := logs.replicationIndex(tier, hour)
+			if err != nil {
+				http.Error(w, "读取段索引失败: "+err.Error(), http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Write(encodeSegmentIndex(index))
+			return
+		}
+		from, _ := strconv.ParseInt(q.Get("from"), 10, 64)
+		chunks, _ := strconv.Atoi(q.Get("chunks"))
+		data, err := logs.replicationSegmentData(tier, hour, from, chunks, q.Get("sha"))
+		if errors.Is(err, errSegmentChanged) {
+			http.Error(w, "段已被改写，请重新获取索引", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "读取段失败: "+err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(data)
+
+	default:
+		http.Error(w, "未知的复制操作: "+r.URL.Path, http.StatusNotFound)
+	}
+}
+
+// API: 把 follower 提升为 leader
+func (m *ReplicationManager) handlePromote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "只接受 POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := m.Promote(); err != nil {
+		http.Error(w, "当前节点不是 follower", http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.Status())
+}
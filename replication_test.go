@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func newReplicaStorage(t *testing.T) *LogStorage {
+	t.Helper()
+	s := NewLogStorage(t.TempDir(), defaultConfig().Storage, nil, defaultTenant)
+	waitReplayed(t, s)
+	s.replica.Store(true)
+	return s
+}
+
+func replicated(seqs ...int64) []replicationRecord {
+	records := make([]replicationRecord, len(seqs))
+	for i, seq := range seqs {
+		records[i] = replicationRecord{Seq: seq, Entry: LogEntry{Timestamp: time.Now().Format(columnTimeLayout), Level: "info", Message: fmt.Sprint(seq)}}
+	}
+	return records
+}
+
+// follower 缓冲按 leader 序号追加，已经收到的和已经在段里的跳过
+func TestApplyAndTrimReplicated(t *testing.T) {
+	s := newReplicaStorage(t)
+
+	if n := s.applyReplicated(replicated(1, 2, 3)); n != 3 {
+		t.Fatalf("applied %d, want 3", n)
+	}
+	if n := s.applyReplicated(replicated(2, 3, 4)); n != 1 {
+		t.Fatalf("re-applied overlap: %d new, want 1", n)
+	}
+	if pos := s.replicaPosition(); pos != 4 {
+		t.Fatalf("position %d, want 4", pos)
+	}
+
+	// 段同步到 2：缓冲只保留 3、4
+	s.trimReplicated(2)
+	if len(s.memoryBuffer) != 2 || s.memoryBuffer[0].Message != "3" || s.repl.seqs[0] != 3 {
+		t.Fatalf("after trim: %+v %v", s.memoryBuffer, s.repl.seqs)
+	}
+	if n := s.applyReplicated(replicated(2)); n != 0 {
+		t.Error("applied a record that is already in a segment")
+	}
+	s.trimReplicated(1) // 不会后退
+	if s.replicaFlushed() != 2 {
+		t.Errorf("flushed moved backwards to %d", s.replicaFlushed())
+	}
+
+	// 段比缓冲更新：位置跟着前进，之后只接受更大的序号
+	s.trimReplicated(6)
+	if len(s.memoryBuffer) != 0 || s.replicaPosition() != 6 {
+		t.Fatalf("after trim past head: %d entries, position %d", len(s.memoryBuffer), s.replicaPosition())
+	}
+	if n := s.applyReplicated(replicated(5, 6, 7)); n != 1 {
+		t.Errorf("applied %d after trim, want 1", n)
+	}
+}
+
+// leader 的 epoch 变化时丢弃缓冲，从头同步
+func TestReplicaEpochReset(t *testing.T) {
+	s := newReplicaStorage(t)
+	ft := &followerTenant{id: defaultTenant, logs: s, epoch: "a", head: 3, leaderFlushed: 1}
+	s.applyReplicated(replicated(1, 2, 3))
+	s.trimReplicated(1)
+
+	if ft.checkEpoch("a") {
+		t.Fatal("same epoch reported as changed")
+	}
+	if !ft.checkEpoch("b") {
+		t.Fatal("new epoch not detected")
+	}
+	if len(s.memoryBuffer) != 0 || s.replicaPosition() != 0 || ft.head != 0 || ft.leaderFlushed != 0 {
+		t.Errorf("state not reset: %d entries, position %d, head %d", len(s.memoryBuffer), s.replicaPosition(), ft.head)
+	}
+	// 新 epoch 的序号从 1 开始
+	if n := s.applyReplicated(replicated(1)); n != 1 {
+		t.Error("record 1 of the new epoch rejected")
+	}
+}
+
+// 按块数和索引哈希读取段；前缀被改写时返回 errSegmentChanged
+func TestReplicationSegmentData(t *testing.T) {
+	dir := t.TempDir()
+	s := NewLogStorage(dir, defaultConfig().Storage, nil, defaultTenant)
+	waitReplayed(t, s)
+	hour := time.Now().Add(-2 * time.Hour).Truncate(time.Hour)
+	segment := segmentPath(dir, hour.Format("2006-01-02-15"))
+	codec, _ := s.codecs.get("lz4")
+	for _, msg := range []string{"a", "b"} {
+		logs := []LogEntry{{Timestamp: hour.Format(columnTimeLayout), Message: msg}}
+		if _, _, err := s.appendChunk(segment, codec, logs, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, _ := os.ReadFile(segment)
+	index, err := s.replicationIndex(tierHot, hour.Format("2006-01-02-15"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := hour.Format("2006-01-02-15")
+	got, err := s.replicationSegmentData(tierHot, h, 0, 2, indexHash(index))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("full segment: %d bytes, err %v", len(got), err)
+	}
+	got, err = s.replicationSegmentData(tierHot, h, index[1].Offset, 2, indexHash(index))
+	if err != nil || !bytes.Equal(got, data[index[1].Offset:]) {
+		t.Fatalf("second chunk only: %d bytes, err %v", len(got), err)
+	}
+	got, err = s.replicationSegmentData(tierHot, h, 0, 1, indexHash(index[:1]))
+	if err != nil || !bytes.Equal(got, data[:index[0].Length]) {
+		t.Fatalf("first chunk: %d bytes, err %v", len(got), err)
+	}
+	for _, tt := range []struct {
+		chunks int
+		sha    string
+	}{{2, indexHash(index[:1])}, {3, indexHash(index)}, {0, ""}} {
+		if _, err := s.replicationSegmentData(tierHot, h, 0, tt.chunks, tt.sha); !errors.Is(err, errSegmentChanged) {
+			t.Errorf("chunks=%d: err %v, want errSegmentChanged", tt.chunks, err)
+		}
+	}
+}
+
+// 提升时缓冲中的日志先补写 WAL：刷盘失败后重启也不会丢
+func TestPromoteReplicaWritesWAL(t *testing.T) {
+	dir := t.TempDir()
+	cfg := defaultConfig().Storage
+	s := NewLogStorage(dir, cfg, nil, defaultTenant)
+	waitReplayed(t, s)
+	s.replica.Store(true)
+	s.applyReplicated(replicated(1, 2))
+	epoch := s.repl.epoch
+
+	// 段路径被目录占住，提升后的刷盘失败
+	now := time.Now()
+	blocked := segmentPath(dir, now.Format("2006-01-02-15"))
+	if err := os.Mkdir(blocked, 0755); err != nil {
+		t.Fatal(err)
+	}
+	s.promoteReplica()
+	if s.replica.Load() || s.repl.epoch == epoch || s.repl.seqs != nil {
+		t.Fatalf("not promoted: replica %v, epoch %s", s.replica.Load(), s.repl.epoch)
+	}
+	if status := s.Append(LogEntry{Timestamp: now.Format(columnTimeLayout), Message: "3"}); status != appendStored {
+		t.Fatalf("Append after promotion: %s", status)
+	}
+	s.flushMu.Lock()
+	s.wal.file.Sync()
+	s.flushMu.Unlock()
+
+	os.Remove(blocked)
+	restarted := NewLogStorage(dir, cfg, nil, defaultTenant)
+	waitReplayed(t, restarted)
+	since := now.Add(-time.Minute).Format(columnTimeLayout)
+	if got := len(restarted.Query("", "", "", since, "", 100, nil)); got != 3 {
+		t.Errorf("after restart: %d entries, want 3", got)
+	}
+}
+
+// leader 和 follower 之间的完整同步：缓冲日志、刷盘后的段、迟到日志的增量块、压缩改写后的整段、提升。
+// leader 的冷层是内存段存储，冷层的段 follower 不复制
+func TestLeaderFollowerReplication(t *testing.T) {
+	newTenants := func(dir string, remote RemoteConfig) *TenantManager {
+		cfg := defaultConfig()
+		cfg.DataDir = dir
+		cfg.Storage.Remote = remote
+		m, err := NewTenantManager(cfg, cfg.IngestConfig([]byte("test-key")), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	leaderDir, followerDir := t.TempDir(), t.TempDir()
+	leaderTenants := newTenants(leaderDir, RemoteConfig{Type: "memory", OffloadAfter: 3 * time.Hour})
+	followerTenants := newTenants(followerDir, RemoteConfig{})
+	lt, _ := leaderTenants.Get(defaultTenant)
+	L := lt.Logs
+	waitReplayed(t, L)
+
+	// 已经转存到冷层的段
+	now := time.Now()
+	archived := now.Add(-5 * time.Hour).Truncate(time.Hour)
+	codec, _ := L.codecs.get("lz4")
+	logs := []LogEntry{{Timestamp: archived.Format(columnTimeLayout), Server: "web-01", Message: "archived"}}
+	if _, _, err := L.appendChunk(segmentPath(leaderDir, archived.Format("2006-01-02-15")), codec, logs, now); err != nil {
+		t.Fatal(err)
+	}
+	if moved, err := L.Offload(now); err != nil || len(moved) != 1 {
+		t.Fatalf("offload: %+v, %v", moved, err)
+	}
+
+	leaderRepl := NewReplicationManager(ReplicationConfig{}, leaderTenants, leaderDir)
+
+	admin := &Principal{Name: "admin", Scopes: []string{ScopeAdmin}}
+	handler := leaderTenants.Resolve(leaderRepl.handleReplication)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, admin)))
+	}))
+	t.Cleanup(srv.Close)
+
+	follower := NewReplicationManager(ReplicationConfig{Leader: srv.URL, Key: "adm", ID: "f1", Interval: 50 * time.Millisecond}, followerTenants, followerDir)
+	t.Cleanup(func() {
+		if follower.Following() {
+			follower.cancel()
+			follower.wg.Wait()
+		}
+	})
+
+	ft, _ := followerTenants.Get(defaultTenant)
+	F := ft.Logs
+	waitReplayed(t, F)
+
+	old := now.Add(-2 * time.Hour).Truncate(time.Hour)
+	since := old.Format(columnTimeLayout)
+	appendAt := func(at time.Time, msg string) {
+		t.Helper()
+		if status := L.Append(LogEntry{Timestamp: at.Format(columnTimeLayout), Server: "web-01", Level: "INFO", Message: msg}); status != appendStored {
+			t.Fatalf("Append %s: %s", msg, status)
+		}
+	}
+	flush := func() {
+		t.Helper()
+		if _, err := L.flushToDisk(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldHour := old.Format("2006-01-02-15")
+	inSync := func(hour string) func() bool {
+		return func() bool {
+			a, errA := os.ReadFile(segmentPath(leaderDir, hour))
+			b, errB := os.ReadFile(segmentPath(followerDir, hour))
+			return errA == nil && errB == nil && bytes.Equal(a, b) && F.replicaFlushed() == L.replicaFlushed()
+		}
+	}
+	tracked := func() *followerTenant {
+		follower.mu.Lock()
+		defer follower.mu.Unlock()
+		return follower.tracked[defaultTenant]
+	}
+	fetched := func() int64 {
+		ft := tracked()
+		ft.mu.Lock()
+		defer ft.mu.Unlock()
+		return ft.bytes
+	}
+
+	// 1. 缓冲中的日志通过长轮询到达 follower
+	for i := 0; i < 3; i++ {
+		appendAt(now, fmt.Sprintf("buffered-%d", i))
+	}
+	eventually(t, "buffered entries on follower", func() bool {
+		return len(F.Query("", "", "", since, "", 100, nil)) == 3
+	})
+	coldSkipped := 0
+	eventually(t, "first segment sync", func() bool {
+		ft := tracked()
+		ft.mu.Lock()
+		defer ft.mu.Unlock()
+		coldSkipped = ft.coldSkipped
+		return !ft.lastSync.IsZero()
+	})
+	if coldSkipped != 1 {
+		t.Errorf("cold segments skipped: %d, want 1", coldSkipped)
+	}
+	if _, err := os.Stat(segmentPath(followerDir, archived.Format("2006-01-02-15"))); !os.IsNotExist(err) {
+		t.Errorf("cold segment copied to follower: %v", err)
+	}
+
+	// 2. 刷盘后 follower 下载段，并丢弃缓冲中已经在段里的日志
+	appendAt(old.Add(30*time.Minute), "late-30")
+	flush()
+	appendAt(old.Add(10*time.Minute), "late-10")
+	flush()
+	eventually(t, "segments synced after flush", func() bool {
+		return inSync(oldHour)() && inSync(now.Format("2006-01-02-15"))()
+	})
+	F.bufferMu.RLock()
+	buffered := len(F.memoryBuffer)
+	F.bufferMu.RUnlock()
+	if buffered != 0 {
+		t.Errorf("follower still buffers %d flushed entries", buffered)
+	}
+	if got := len(F.Query("", "", "", since, "", 100, nil)); got != 5 {
+		t.Fatalf("follower query after flush: %d entries, want 5", got)
+	}
+
+	// 3. 迟到的日志追加到旧段：只下载新增的块
+	before := fetched()
+	appendAt(old.Add(20*time.Minute), "late-20")
+	flush()
+	eventually(t, "appended chunk synced", inSync(oldHour))
+	index, _ := L.replicationIndex(tierHot, oldHour)
+	if got, want := fetched()-before, index[len(index)-1].Length; got != want {
+		t.Errorf("prefix sync fetched %d bytes, want only the new chunk (%d)", got, want)
+	}
+
+	// 4. 压缩改写旧段（索引不再是前缀）：整段重新下载
+	before = fetched()
+	results, err := L.Compact(now, true)
+	if err != nil || len(results) != 1 || !results[0].Reordered {
+		t.Fatalf("leader compaction: %+v, %v", results, err)
+	}
+	eventually(t, "compacted segment synced", inSync(oldHour))
+	if got, want := fetched()-before, results[0].BytesAfter; got != want {
+		t.Errorf("full sync fetched %d bytes, want the whole segment (%d)", got, want)
+	}
+
+	// 5. 提升：缓冲中还没刷盘的日志写入本地，之后 follower 可以接收写入
+	appendAt(now, "unflushed")
+	eventually(t, "unflushed entry on follower", func() bool {
+		return F.replicaPosition() == L.replicationHead()
+	})
+	if err := follower.Promote(); err != nil {
+		t.Fatal(err)
+	}
+	if follower.Following() || F.replica.Load() {
+		t.Fatal("follower still read-only after promotion")
+	}
+	if _, err := os.Stat(filepath.Join(followerDir, replicationMarker)); err != nil {
+		t.Errorf("promotion marker: %v", err)
+	}
+	if err := follower.Promote(); err == nil {
+		t.Error("promoted twice")
+	}
+	if status := F.Append(LogEntry{Timestamp: now.Format(columnTimeLayout), Message: "after promotion"}); status != appendStored {
+		t.Fatalf("Append on promoted node: %s", status)
+	}
+	if _, err := F.flushToDisk(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(F.Query("", "", "", since, "", 100, nil)); got != 8 {
+		t.Errorf("promoted node has %d entries, want 8", got)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 定期删除超过保留时长的日志文件（logs-YYYY-MM-DD-HH.lz4，按小时分片）及其索引
+func (s *LogStorage) retentionJanitor() {
+	ticker := time.NewTicker(10 * time.Minute)
+	for range ticker.C {
+		s.applyRetention(time.Now())
+	}
+}
+
+// 删除整个小时都早于 now - retention 的文件（包括温层和冷层的段），返回删除的文件数；
+// follower 跳过，leader 删除后段同步会删除本地的副本
+func (s *LogStorage) applyRetention(now time.Time) int {
+	s.bufferMu.RLock()
+	retention := s.retention
+	s.bufferMu.RUnlock()
+	if retention <= 0 || s.replica.Load() {
+		return 0
+	}
+
+	cutoff := now.Add(-retention)
+	files := listSegments(s.dataDir)
+	deleted := 0
+	for _, file := range files {
+		hour := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), "logs-"), ".lz4")
+		start, err := time.ParseInLocation("2006-01-02-15", hour, time.Local)
+		if err != nil {
+			continue
+		}
+		if start.Add(time.Hour).After(cutoff) {
+			continue
+		}
+		if err := os.Remove(file); err == nil {
+			os.Remove(indexPath(file))
+			s.auditRetention(tierHot, hour, retention)
+			deleted++
+		}
+	}
+	for _, tier := range []string{tierWarm, tierCold} {
+		store := s.tierStore(tier)
+		if store == nil {
+			continue
+		}
+		hours, err := storeSegments(store)
+		if err != nil {
+			fmt.Printf("⚠️  Cannot list %s segments: %v\n", tier, err)
+		}
+		for hour := range hours {
+			start, err := time.ParseInLocation("2006-01-02-15", hour, time.Local)
+			if err != nil || start.Add(time.Hour).After(cutoff) {
+				continue
+			}
+			if err := s.deleteStoredSegment(tier, hour); err == nil {
+				s.auditRetention(tier, hour, retention)
+				deleted++
+			}
+		}
+	}
+	if deleted > 0 {
+		fmt.Printf("🗑️  [Retention] Removed %d log files older than %s in %s\n", deleted, retention, s.dataDir)
+	}
+	return deleted
+}
+
+// 每删除一个段记录一条审计（没有请求，Path 固定为 retention）
+func (s *LogStorage) auditRetention(tier, hour string, retention time.Duration) {
+	s.audit.Record(AuditEvent{
+		Action: AuditRetention,
+		Tenant: s.tenant,
+		Path:   "retention",
+		Status: http.StatusOK,
+		Details: map[string]string{
+			"tier":      tier,
+			"segment":   segmentKey(hour),
+			"retention": retention.String(),
+		},
+	})
+}
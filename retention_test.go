@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestApplyRetentionAuditsEachDeletion(t *testing.T) {
+	dir := t.TempDir()
+	audit, err := NewAuditLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.Local)
+	s := &LogStorage{dataDir: dir, retention: 24 * time.Hour, audit: audit, tenant: "team-a"}
+
+	for _, name := range []string{"logs-2024-04-29-10.lz4", "logs-2024-04-30-11.lz4", "logs-2024-04-30-13.lz4", "logs-2024-05-01-12.lz4"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := s.applyRetention(now); n != 2 {
+		t.Fatalf("deleted %d segments, want 2", n)
+	}
+	for _, name := range []string{"logs-2024-04-30-13.lz4", "logs-2024-05-01-12.lz4"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s should be kept: %v", name, err)
+		}
+	}
+
+	events, err := audit.Query(auditFilter{Action: AuditRetention, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d retention audit events, want 2", len(events))
+	}
+	for _, e := range events {
+		if e.Tenant != "team-a" || e.Details["tier"] != tierHot || e.Details["segment"] == "" {
+			t.Errorf("unexpected audit event: %+v", e)
+		}
+	}
+}
+
+func TestReconfigureDoesNotBlockOnFlushReset(t *testing.T) {
+	// 没有后台任务读取 flushReset 时连续修改刷盘间隔也不能阻塞
+	s := &LogStorage{flushInterval: time.Minute, flushReset: make(chan struct{}, 1), dedup: newDedupSet(DedupConfig{})}
+	done := make(chan struct{})
+	go func() {
+		for _, d := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+			s.Reconfigure(StorageConfig{FlushInterval: d})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Reconfigure blocked on flushReset")
+	}
+	if s.flushInterval != 3*time.Second {
+		t.Errorf("flushInterval = %s, want 3s", s.flushInterval)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 角色：限制可读取的服务器、级别和字段（绑定到 API Key）
+type Role struct {
+	Name       string   `json:"name"`
+	Servers    []string `json:"servers,omitempty"`     // 允许的服务器（支持 web-* 通配，空表示全部）
+	Levels     []string `json:"levels,omitempty"`      // 允许的级别（ERROR / >=WARN，空表示全部）
+	DenyFields []string `json:"deny_fields,omitempty"` // 结果中掩码的字段（message / source / 结构化字段名）
+}
+
+const maskedValue = "[MASKED]"
+
+type compiledRole struct {
+	servers    []string
+	levels     []levelFilter
+	denyFields map[string]bool
+}
+
+func (r *compiledRole) allowServer(server string) bool {
+	if len(r.servers) == 0 {
+		return true
+	}
+	for _, pattern := range r.servers {
+		if ok, _ := path.Match(pattern, server); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *compiledRole) allowLevel(level string) bool {
+	if len(r.levels) == 0 {
+		return true
+	}
+	for _, f := range r.levels {
+		if f.match(level) {
+			return true
+		}
+	}
+	return false
+}
+
+// 访问策略：多个角色取并集；nil 表示不受限制
+type AccessPolicy struct {
+	roles []compiledRole
+}
+
+func compileAccessPolicy(roles []Role) *AccessPolicy {
+	policy := &AccessPolicy{}
+	for _, role := range roles {
+		c := compiledRole{servers: role.Servers, denyFields: make(map[string]bool)}
+		for _, level := range role.Levels {
+			c.levels = append(c.levels, parseLevelFilter(level))
+		}
+		for _, field := range role.DenyFields {
+			c.denyFields[field] = true
+		}
+		policy.roles = append(policy.roles, c)
+	}
+	return policy
+}
+
+// 是否允许读取该服务器的数据（任一角色允许即可）
+func (p *AccessPolicy) AllowServer(server string) bool {
+	if p == nil {
+		return true
+	}
+	for i := range p.roles {
+		if p.roles[i].allowServer(server) {
+			return true
+		}
+	}
+	return false
+}
+
+// 是否允许读取该条日志
+func (p *AccessPolicy) AllowEntry(log LogEntry) bool {
+	if p == nil {
+		return true
+	}
+	for i := range p.roles {
+		if p.roles[i].allowServer(log.Server) && p.roles[i].allowLevel(log.Level) {
+			return true
+		}
+	}
+	return false
+}
+
+// 掩码字段：只有所有允许读取该条日志的角色都禁止的字段才掩码
+func (p *AccessPolicy) Mask(log LogEntry) LogEntry {
+	if p == nil {
+		return log
+	}
+
+	var denied map[string]bool
+	for i := range p.roles {
+		role := &p.roles[i]
+		if !role.allowServer(log.Server) || !role.allowLevel(log.Level) {
+			continue
+		}
+		if denied == nil {
+			denied = make(map[string]bool, len(role.denyFields))
+			for field := range role.denyFields {
+				denied[field] = true
+			}
+			continue
+		}
+		for field := range denied {
+			if !role.denyFields[field] {
+				delete(denied, field)
+			}
+		}
+	}
+	if len(denied) == 0 {
+		return log
+	}
+
+	// Fields 是共享的 map，掩码前先复制
+	if len(log.Fields) > 0 {
+		fields := make(map[string]string, len(log.Fields))
+		for k, v := range log.Fields {
+			fields[k] = v
+		}
+		log.Fields = fields
+	}
+	for field := range denied {
+		if _, ok := getField(&log, field); ok {
+			setField(&log, field, maskedValue)
+		}
+	}
+	return log
+}
+
+// 角色存储（dataDir/roles.json）
+type RoleStore struct {
+	path  string
+	mu    sync.RWMutex
+	roles map[string]Role
+}
+
+func NewRoleStore(dataDir string) (*RoleStore, error) {
+	store := &RoleStore{
+		path:  filepath.Join(dataDir, "roles.json"),
+		roles: make(map[string]Role),
+	}
+
+	data, err := os.ReadFile(store.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		var roles []Role
+		if err := json.Unmarshal(data, &roles); err != nil {
+			return nil, fmt.Errorf("%s: %w", store.path, err)
+		}
+		for _, role := range roles {
+			store.roles[role.Name] = role
+		}
+	}
+	return store, nil
+}
+
+func (s *RoleStore) Put(role Role) error {
+	if role.Name == "" {
+		return fmt.Errorf("角色名不能为空")
+	}
+	for _, pattern := range role.Servers {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("无效的服务器匹配 %q: %w", pattern, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.roles[role.Name] = role
+	return s.saveLocked()
+}
+
+func (s *RoleStore) Delete(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.roles[name]; !ok {
+		return false, nil
+	}
+	delete(s.roles, name)
+	return true, s.saveLocked()
+}
+
+func (s *RoleStore) List() []Role {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]Role, 0, len(s.roles))
+	for _, role := range s.roles {
+		result = append(result, role)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// 按角色名生成访问策略；未知角色不授予任何权限
+func (s *RoleStore) Policy(names []string) *AccessPolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	roles := make([]Role, 0, len(names))
+	for _, name := range names {
+		if role, ok := s.roles[name]; ok {
+			roles = append(roles, role)
+		}
+	}
+	return compileAccessPolicy(roles)
+}
+
+func (s *RoleStore) Exists(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.roles[name]
+	return ok
+}
+
+// 调用方持有 s.mu
+func (s *RoleStore) saveLocked() error {
+	roles := make([]Role, 0, len(s.roles))
+	for _, role := range s.roles {
+		roles = append(roles, role)
+	}
+	data, err := json.MarshalIndent(roles, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// API: 角色管理（GET 列表 / POST 创建或更新 / DELETE 删除）
+func (s *RoleStore) handleRoles(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		json.NewEncoder(w).Encode(s.List())
+
+	case "POST":
+		var role Role
+		if err := json.NewDecoder(r.Body).Decode(&role); err != nil {
+			http.Error(w, "请求格式错误: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		role.Name = strings.TrimSpace(role.Name)
+		if err := s.Put(role); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(role)
+
+	case "DELETE":
+		deleted, err := s.Delete(r.URL.Query().Get("name"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "角色不存在", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"deleted": true})
+
+	default:
+		http.Error(w, "只接受 GET / POST / DELETE", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAccessPolicy(t *testing.T) {
+	web := Role{Name: "web", Servers: []string{"web-*"}, Levels: []string{">=WARN"}, DenyFields: []string{"message", "token", "ip"}}
+	debug := Role{Name: "debug", Servers: []string{"web-01"}, DenyFields: []string{"token"}}
+	db := Role{Name: "db", Servers: []string{"db-01", "db-02"}, Levels: []string{"ERROR", "FATAL"}}
+
+	entry := func(server, level string) LogEntry {
+		return LogEntry{Server: server, Level: level, Message: "secret message", Source: "app", Fields: map[string]string{"token": "t", "ip": "10.0.0.1", "path": "/"}}
+	}
+
+	cases := []struct {
+		name   string
+		roles  []Role
+		entry  LogEntry
+		allow  bool
+		masked []string // 掩码后的字段
+	}{
+		{"no policy", nil, entry("db-09", "DEBUG"), true, nil},
+		{"server and level allowed", []Role{web}, entry("web-01", "ERROR"), true, []string{"message", "token", "ip"}},
+		{"level below filter", []Role{web}, entry("web-01", "INFO"), false, nil},
+		{"server not matched", []Role{web}, entry("db-01", "ERROR"), false, nil},
+		{"level alias", []Role{web}, entry("web-02", "warning"), true, []string{"message", "token", "ip"}},
+		{"exact level list", []Role{db}, entry("db-02", "FATAL"), true, nil},
+		{"exact level list rejects", []Role{db}, entry("db-02", "WARN"), false, nil},
+		// 多个角色取并集：只有所有允许的角色都禁止的字段才掩码
+		{"union masks intersection", []Role{web, debug}, entry("web-01", "ERROR"), true, []string{"token"}},
+		{"union other role only", []Role{web, debug}, entry("web-01", "DEBUG"), true, []string{"token"}},
+		{"union first role only", []Role{web, debug}, entry("web-02", "ERROR"), true, []string{"message", "token", "ip"}},
+		{"empty role allows everything", []Role{{Name: "all"}}, entry("x", "TRACE"), true, nil},
+		{"no roles denies everything", []Role{}, entry("web-01", "ERROR"), false, nil},
+	}
+
+	for _, c := range cases {
+		var policy *AccessPolicy
+		if c.roles != nil {
+			policy = compileAccessPolicy(c.roles)
+		}
+		if got := policy.AllowEntry(c.entry); got != c.allow {
+			t.Errorf("%s: AllowEntry = %v, want %v", c.name, got, c.allow)
+		}
+		if got := policy.AllowServer(c.entry.Server); c.allow && !got {
+			t.Errorf("%s: AllowServer = false for an allowed entry", c.name)
+		}
+		if !c.allow {
+			continue
+		}
+
+		masked := policy.Mask(c.entry)
+		want := map[string]bool{}
+		for _, field := range c.masked {
+			want[field] = true
+		}
+		for _, field := range []string{"message", "source", "token", "ip", "path"} {
+			value, _ := getField(&masked, field)
+			original, _ := getField(&c.entry, field)
+			if want[field] && value != maskedValue {
+				t.Errorf("%s: %s = %q, want masked", c.name, field, value)
+			}
+			if !want[field] && value != original {
+				t.Errorf("%s: %s = %q, want %q", c.name, field, value, original)
+			}
+		}
+		if c.entry.Fields["token"] != "t" {
+			t.Errorf("%s: Mask modified the original fields", c.name)
+		}
+	}
+}
+
+// 未知或已删除的角色不授予任何权限：绑定了这些角色的 key 什么都读不到
+func TestUnknownRoleDeniesAll(t *testing.T) {
+	dir := t.TempDir()
+	auth, err := NewAuthStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles := auth.Roles
+	if err := roles.Put(Role{Name: "web", Servers: []string{"web-*"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	log := LogEntry{Server: "web-01", Level: "ERROR", Message: "boom"}
+	if !roles.Policy([]string{"web"}).AllowEntry(log) {
+		t.Fatal("web role does not allow web-01")
+	}
+	if p := roles.Policy([]string{"missing"}); p == nil || p.AllowEntry(log) || p.AllowServer("web-01") {
+		t.Error("unknown role grants access")
+	}
+	if !roles.Policy([]string{"missing", "web"}).AllowEntry(log) {
+		t.Error("an unknown role next to a known one removed access")
+	}
+
+	secret, _, err := auth.Create(APIKey{Name: "viewer", Scopes: []string{ScopeQuery}, Roles: []string{"web"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted, err := roles.Delete("web"); !deleted || err != nil {
+		t.Fatalf("Delete: %v %v", deleted, err)
+	}
+
+	// 角色删除后重新打开，key 仍然绑定着该角色名
+	reopened, err := NewAuthStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Roles.Exists("web") {
+		t.Fatal("deleted role came back after reopening")
+	}
+	var access *AccessPolicy
+	r := httptest.NewRequest("GET", "/api/query", nil)
+	r.Header.Set("X-API-Key", secret)
+	w := httptest.NewRecorder()
+	reopened.Require(ScopeQuery, func(w http.ResponseWriter, r *http.Request) { access = accessFrom(r) })(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	if access == nil || access.AllowEntry(log) || access.AllowServer("web-01") {
+		t.Error("key bound to a deleted role is unrestricted")
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 写入规则：丢弃 / 采样 / 限流（按配置顺序，命中的第一条规则生效）
+type IngestRule struct {
+	Name   string `yaml:"name,omitempty"`
+	Action string `yaml:"action,omitempty"` // drop / sample / limit
+
+	// 匹配条件（空表示任意）
+	Server  string `yaml:"server,omitempty"`
+	Source  string `yaml:"source,omitempty"`
+	Level   string `yaml:"level,omitempty"` // 支持 level>=WARN 这类表达式
+	Keyword string `yaml:"keyword,omitempty"`
+
+	// sample：按 SampleKey 字段哈希，确定性地保留 1/SampleRate
+	SampleRate int    `yaml:"sample_rate,omitempty"`
+	SampleKey  string `yaml:"sample_key,omitempty"`
+
+	// limit：令牌桶，Per 决定按什么分桶（server / level / server,level，空表示全局）
+	Rate  float64 `yaml:"rate,omitempty"`
+	Burst int     `yaml:"burst,omitempty"`
+	Per   string  `yaml:"per,omitempty"`
+
+	level   levelFilter
+	keyword string
+	per     []string
+}
+
+func (r *IngestRule) compile() error {
+	switch r.Action {
+	case "drop":
+	case "sample":
+		if r.SampleRate < 1 {
+			return fmt.Errorf("sample_rate 必须 >= 1")
+		}
+		if r.SampleKey == "" {
+			r.SampleKey = "message"
+		}
+	case "limit":
+		if r.Rate <= 0 {
+			return fmt.Errorf("rate 必须 > 0")
+		}
+		if r.Burst <= 0 {
+			r.Burst = int(r.Rate)
+			if r.Burst < 1 {
+				r.Burst = 1
+			}
+		}
+		for _, key := range strings.Split(r.Per, ",") {
+			key = strings.TrimSpace(key)
+			if key == "" {
+				continue
+			}
+			if key != "server" && key != "level" && key != "source" {
+				return fmt.Errorf("per 只支持 server / level / source，得到 %q", key)
+			}
+			r.per = append(r.per, key)
+		}
+	default:
+		return fmt.Errorf("未知动作: %q", r.Action)
+	}
+
+	r.level = parseLevelFilter(r.Level)
+	r.keyword = strings.ToLower(r.Keyword)
+	return nil
+}
+
+func (r *IngestRule) matches(entry LogEntry) bool {
+	if r.Server != "" && !strings.EqualFold(r.Server, entry.Server) {
+		return false
+	}
+	if r.Source != "" && r.Source != entry.Source {
+		return false
+	}
+	if !r.level.match(entry.Level) {
+		return false
+	}
+	if r.keyword != "" && !strings.Contains(strings.ToLower(entry.Message), r.keyword) {
+		return false
+	}
+	return true
+}
+
+// 令牌桶
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) allow(now time.Time, rate float64, burst int) bool {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// 单条规则的计数
+type ruleStats struct {
+	Matched int64 `json:"matched"`
+	Dropped int64 `json:"dropped"`
+}
+
+type RuleSet struct {
+	rules []*IngestRule
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket // rule + 分桶 key -> 令牌桶
+	stats   map[string]*ruleStats
+	pending map[string]int64 // 上次摘要之后每条规则丢弃的条数
+	now     func() time.Time
+}
+
+func NewRuleSet(rules []IngestRule) (*RuleSet, error) {
+	set := &RuleSet{
+		buckets: make(map[string]*tokenBucket),
+		stats:   make(map[string]*ruleStats),
+		pending: make(map[string]int64),
+		now:     time.Now,
+	}
+
+	for i := range rules {
+		rule := rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("%s-%d", rule.Action, i)
+		}
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("ingest rule %s: %w", rule.Name, err)
+		}
+		set.rules = append(set.rules, &rule)
+		set.stats[rule.Name] = &ruleStats{}
+	}
+
+	return set, nil
+}
+
+// 判断一条日志是否保留
+func (s *RuleSet) Allow(entry LogEntry) bool {
+	for _, rule := range s.rules {
+		if !rule.matches(entry) {
+			continue
+		}
+
+		keep := true
+		switch rule.Action {
+		case "drop":
+			keep = false
+		case "sample":
+			value, _ := getField(&entry, rule.SampleKey)
+			h := fnv.New32a()
+			h.Write([]byte(value))
+			keep = h.Sum32()%uint32(rule.SampleRate) == 0
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if rule.Action == "limit" {
+			keep = s.bucketFor(rule, entry).allow(s.now(), rule.Rate, rule.Burst)
+		}
+
+		stats := s.stats[rule.Name]
+		stats.Matched++
+		if !keep {
+			stats.Dropped++
+			s.pending[rule.Name]++
+		}
+		return keep
+	}
+	return true
+}
+
+// 调用方持有 s.mu
+func (s *RuleSet) bucketFor(rule *IngestRule, entry LogEntry) *tokenBucket {
+	key := rule.Name
+	for _, per := range rule.per {
+		value, _ := getField(&entry, per)
+		key += "\x00" + value
+	}
+
+	bucket, exists := s.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: float64(rule.Burst), last: s.now()}
+		s.buckets[key] = bucket
+	}
+	return bucket
+}
+
+// 生成丢弃摘要（没有丢弃时返回 false），让数据丢失在查询结果里可见
+func (s *RuleSet) Summary(interval time.Duration) (LogEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 顺便清理已经回满的令牌桶（和新建的桶没有区别）
+	now := s.now()
+	for key, bucket := range s.buckets {
+		rule := s.ruleByBucket(key)
+		if rule == nil || bucket.tokens+now.Sub(bucket.last).Seconds()*rule.Rate >= float64(rule.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+
+	if len(s.pending) == 0 {
+		return LogEntry{}, false
+	}
+
+	names := make([]string, 0, len(s.pending))
+	for name := range s.pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var total int64
+	parts := make([]string, 0, len(names))
+	fields := make(map[string]string, len(names))
+	for _, name := range names {
+		count := s.pending[name]
+		total += count
+		parts = append(parts, fmt.Sprintf("%s=%d", name, count))
+		fields["dropped."+name] = fmt.Sprint(count)
+	}
+	s.pending = make(map[string]int64)
+
+	return LogEntry{
+		Timestamp: now.Format("2006-01-02 15:04:05"),
+		Level:     "WARN",
+		Server:    "minilog",
+		Source:    "ingest-rules",
+		Message:   fmt.Sprintf("Ingest rules dropped %d entries in the last %s: %s", total, interval, strings.Join(parts, " ")),
+		Fields:    fields,
+	}, true
+}
+
+func (s *RuleSet) ruleByBucket(key string) *IngestRule {
+	name := key
+	if idx := strings.IndexByte(key, 0); idx != -1 {
+		name = key[:idx]
+	}
+	for _, rule := range s.rules {
+		if rule.Name == name {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (s *RuleSet) GetStats() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rules := make(map[string]ruleStats, len(s.stats))
+	for name, stats := range s.stats {
+		rules[name] = *stats
+	}
+
+	return map[string]interface{}{
+		"ingest_rules": rules,
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRuleSet(t *testing.T, rules ...IngestRule) (*RuleSet, *time.Time) {
+	t.Helper()
+	set, err := NewRuleSet(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+	set.now = func() time.Time { return now }
+	return set, &now
+}
+
+func TestRuleDropAndMatching(t *testing.T) {
+	set, _ := newTestRuleSet(t,
+		IngestRule{Name: "health", Action: "drop", Source: "nginx", Keyword: "GET /HEALTH"},
+		IngestRule{Name: "debug", Action: "drop", Server: "WEB-01", Level: "level<INFO"},
+	)
+	cases := []struct {
+		entry LogEntry
+		keep  bool
+	}{
+		{LogEntry{Source: "nginx", Message: "get /health 200"}, false},
+		{LogEntry{Source: "app", Message: "GET /health 200"}, true},
+		{LogEntry{Server: "web-01", Level: "DEBUG"}, false},
+		{LogEntry{Server: "web-01", Level: "trace"}, false},
+		{LogEntry{Server: "web-01", Level: "INFO"}, true},
+		{LogEntry{Server: "web-02", Level: "DEBUG"}, true},
+	}
+	for _, c := range cases {
+		if got := set.Allow(c.entry); got != c.keep {
+			t.Errorf("%+v: Allow = %v, want %v", c.entry, got, c.keep)
+		}
+	}
+
+	stats := set.GetStats()["ingest_rules"].(map[string]ruleStats)
+	if stats["health"] != (ruleStats{Matched: 1, Dropped: 1}) || stats["debug"] != (ruleStats{Matched: 2, Dropped: 2}) {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+// 采样按 sample_key 的 FNV-1a 哈希决定：同一个值总是同样处理，保留比例约为 1/sample_rate
+func TestRuleSampling(t *testing.T) {
+	set, _ := newTestRuleSet(t, IngestRule{Name: "trace", Action: "sample", SampleRate: 10, SampleKey: "trace_id"})
+
+	kept := 0
+	for i := 0; i < 2000; i++ {
+		id := fmt.Sprintf("trace-%d", i)
+		h := fnv.New32a()
+		h.Write([]byte(id))
+		want := h.Sum32()%10 == 0
+
+		entry := LogEntry{Message: "span", Fields: map[string]string{"trace_id": id}}
+		got := set.Allow(entry)
+		if got != want {
+			t.Fatalf("%s: Allow = %v, want %v", id, got, want)
+		}
+		if set.Allow(entry) != got {
+			t.Fatalf("%s: sampling is not deterministic", id)
+		}
+		if got {
+			kept++
+		}
+	}
+	if kept < 150 || kept > 250 {
+		t.Errorf("kept %d of 2000 traces at rate 10", kept)
+	}
+
+	// sample_key 默认是 message
+	set, _ = newTestRuleSet(t, IngestRule{Action: "sample", SampleRate: 1})
+	if !set.Allow(LogEntry{Message: "anything"}) {
+		t.Error("sample_rate 1 dropped an entry")
+	}
+}
+
+func TestRuleLimit(t *testing.T) {
+	set, now := newTestRuleSet(t, IngestRule{Name: "flood", Action: "limit", Rate: 2, Burst: 3, Per: "server"})
+	allow := func(server string, n int) int {
+		kept := 0
+		for i := 0; i < n; i++ {
+			if set.Allow(LogEntry{Server: server, Message: "x"}) {
+				kept++
+			}
+		}
+		return kept
+	}
+
+	if got := allow("web-01", 10); got != 3 {
+		t.Errorf("burst: kept %d, want 3", got)
+	}
+	// 每台服务器单独分桶
+	if got := allow("web-02", 10); got != 3 {
+		t.Errorf("second server: kept %d, want 3", got)
+	}
+	*now = now.Add(time.Second)
+	if got := allow("web-01", 10); got != 2 {
+		t.Errorf("after 1s: kept %d, want 2", got)
+	}
+	*now = now.Add(250 * time.Millisecond)
+	if got := allow("web-01", 10); got != 0 {
+		t.Errorf("after 0.25s: kept %d, want 0", got)
+	}
+	*now = now.Add(250 * time.Millisecond)
+	if got := allow("web-01", 10); got != 1 {
+		t.Errorf("after 0.5s: kept %d, want 1", got)
+	}
+	// 桶不会超过 burst
+	*now = now.Add(time.Hour)
+	if got := allow("web-01", 10); got != 3 {
+		t.Errorf("after an hour: kept %d, want 3", got)
+	}
+}
+
+func TestRuleSummary(t *testing.T) {
+	set, now := newTestRuleSet(t,
+		IngestRule{Name: "noise", Action: "drop", Keyword: "noise"},
+		IngestRule{Name: "flood", Action: "limit", Rate: 1, Burst: 1},
+	)
+	if _, ok := set.Summary(time.Minute); ok {
+		t.Fatal("summary without drops")
+	}
+
+	for i := 0; i < 3; i++ {
+		set.Allow(LogEntry{Message: "noise"})
+	}
+	for i := 0; i < 5; i++ {
+		set.Allow(LogEntry{Message: "burst"})
+	}
+
+	summary, ok := set.Summary(time.Minute)
+	if !ok {
+		t.Fatal("no summary after drops")
+	}
+	if summary.Level != "WARN" || summary.Server != "minilog" || summary.Source != "ingest-rules" {
+		t.Errorf("summary entry %+v", summary)
+	}
+	if summary.Timestamp != now.Format("2006-01-02 15:04:05") {
+		t.Errorf("summary timestamp %q", summary.Timestamp)
+	}
+	if !strings.Contains(summary.Message, "dropped 7 entries in the last 1m0s: flood=4 noise=3") {
+		t.Errorf("summary message %q", summary.Message)
+	}
+	if summary.Fields["dropped.noise"] != "3" || summary.Fields["dropped.flood"] != "4" {
+		t.Errorf("summary fields %v", summary.Fields)
+	}
+
+	// 摘要后计数清零；回满的令牌桶被清理
+	if _, ok := set.Summary(time.Minute); ok {
+		t.Error("pending counts not reset")
+	}
+	if len(set.buckets) != 1 {
+		t.Errorf("%d buckets, want the empty one kept", len(set.buckets))
+	}
+	*now = now.Add(time.Second)
+	set.Summary(time.Minute)
+	if len(set.buckets) != 0 {
+		t.Errorf("%d buckets left after refill", len(set.buckets))
+	}
+}
+
+func TestRuleCompileErrors(t *testing.T) {
+	for _, rule := range []IngestRule{
+		{Action: "reject"},
+		{Action: "sample"},
+		{Action: "limit"},
+		{Action: "limit", Rate: 1, Per: "message"},
+	} {
+		if _, err := NewRuleSet([]IngestRule{rule}); err == nil {
+			t.Errorf("%+v: expected error", rule)
+		}
+	}
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 段：按小时分片的压缩文件 logs-YYYY-MM-DD-HH.lz4，由若干块组成（===CHUNK_<unix>_<codec>_<layout>===\n + 压缩数据，见 columnar.go）。
+// 每个段旁边有一个索引文件 .idx（每行一个块的位置、条数、时间范围、服务器和级别），
+// 用于列出段信息和查询时跳过不相关的块；索引缺失或与段文件不一致时重新扫描生成
+const chunkMarker = "===CHUNK_"
+
+// 单个块的索引
+type chunkIndex struct {
+	Offset   int64          `json:"offset"`    // 分隔符在段文件中的位置
+	Length   int64          `json:"length"`    // 分隔符 + 压缩数据
+	RawBytes int64          `json:"raw_bytes"` // 压缩前大小
+	Codec    string         `json:"codec"`
+	Entries  int            `json:"entries"`
+	MinTime  string         `json:"min_time"`
+	MaxTime  string         `json:"max_time"`
+	Servers  []string       `json:"servers,omitempty"`
+	Levels   map[string]int `json:"levels,omitempty"` // 规范级别 -> 条数
+}
+
+// 段信息（/api/admin/segments）
+type SegmentInfo struct {
+	Name    string `json:"name"`
+	Hour    string `json:"hour"`
+	Bytes   int64  `json:"bytes"`
+	Chunks  int    `json:"chunks"`
+	Entries int    `json:"entries"`
+	MinTime string `json:"min_time,omitempty"`
+	MaxTime string `json:"max_time,omitempty"`
+	Indexed bool   `json:"indexed"` // 索引文件与段一致
+	Tier    string `json:"tier"`    // hot / warm / cold
+}
+
+func segmentPath(dataDir, hour string) string {
+	return filepath.Join(dataDir, "logs-"+hour+".lz4")
+}
+
+func segmentHour(path string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "logs-"), ".lz4")
+}
+
+func indexPath(segment string) string {
+	return strings.TrimSuffix(segment, ".lz4") + ".idx"
+}
+
+// 按时间顺序列出段文件
+func listSegments(dataDir string) []string {
+	files, _ := filepath.Glob(filepath.Join(dataDir, "logs-*.lz4"))
+	sort.Strings(files)
+	return files
+}
+
+// 根据块中的日志生成索引
+func newChunkIndex(logs []LogEntry, codec string, offset, length, raw int64) chunkIndex {
+	idx := chunkIndex{Offset: offset, Length: length, RawBytes: raw, Codec: codec, Entries: len(logs), Levels: make(map[string]int)}
+	servers := make(map[string]bool)
+	for _, log := range logs {
+		if idx.MinTime == "" || log.Timestamp < idx.MinTime {
+			idx.MinTime = log.Timestamp
+		}
+		if log.Timestamp > idx.MaxTime {
+			idx.MaxTime = log.Timestamp
+		}
+		if log.Server != "" {
+			servers[log.Server] = true
+		}
+		level := log.NormLevel
+		if level == "" {
+			level = normalizeLevel(log.Level)
+		}
+		idx.Levels[level]++
+	}
+	for server := range servers {
+		idx.Servers = append(idx.Servers, server)
+	}
+	sort.Strings(idx.Servers)
+	return idx
+}
+
+// 块是否可能包含匹配的日志（服务器已转小写；未知级别别名无法判断，不跳过）
+func (c chunkIndex) mayMatch(server string, level levelFilter, since, until string) bool {
+	if c.Entries > 0 && ((since != "" && c.MaxTime < since) || (until != "" && c.MinTime > until)) {
+		return false
+	}
+	if server != "" {
+		found := false
+		for _, s := range c.Servers {
+			if strings.ToLower(s) == server {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if !level.empty() && level.sev != SeverityUnknown {
+		for l := range c.Levels {
+			if level.match(l) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// 追加一个块的索引（刷盘时调用，调用方持有 flushMu）
+func appendChunkIndex(segment string, idx chunkIndex) error {
+	f, err := os.OpenFile(indexPath(segment), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	line, _ := json.Marshal(idx)
+	_, err = f.Write(append(line, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func encodeSegmentIndex(chunks []chunkIndex) []byte {
+	var buf bytes.Buffer
+	for _, idx := range chunks {
+		line, _ := json.Marshal(idx)
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// 整体写入索引（先写临时文件再替换）
+func writeSegmentIndex(segment string, chunks []chunkIndex) error {
+	tmp := indexPath(segment) + ".tmp"
+	if err := os.WriteFile(tmp, encodeSegmentIndex(chunks), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, indexPath(segment))
+}
+
+// 读取索引；块首尾相接且覆盖整个段文件时才认为有效（没有记录编码和原始大小的旧索引需要重建）
+func readSegmentIndex(segment string, size int64) ([]chunkIndex, bool) {
+	data, err := os.ReadFile(indexPath(segment))
+	if err != nil {
+		return nil, false
+	}
+	return parseSegmentIndex(data, size)
+}
+
+// 解析索引内容；size 为 -1 时不检查段文件大小（远端段）
+func parseSegmentIndex(data []byte, size int64) ([]chunkIndex, bool) {
+	chunks := make([]chunkIndex, 0)
+	var next int64
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var idx chunkIndex
+		if err := json.Unmarshal(scanner.Bytes(), &idx); err != nil || idx.Offset != next || idx.Codec == "" {
+			return nil, false
+		}
+		next = idx.Offset + idx.Length
+		chunks = append(chunks, idx)
+	}
+	if scanner.Err() != nil || (size >= 0 && next != size) {
+		return nil, false
+	}
+	return chunks, true
+}
+
+// 段文件中的一个块
+type rawChunk struct {
+	offset  int64
+	data    []byte // 分隔符 + 压缩数据
+	maxTime string // 索引中的最新时间（没有索引时为空）
+}
+
+// 按索引的偏移切分段文件（调用方保证索引与 data 一致）
+func indexedChunks(data []byte, index []chunkIndex) []rawChunk {
+	chunks := make([]rawChunk, len(index))
+	for i, c := range index {
+		chunks[i] = rawChunk{offset: c.Offset, data: data[c.Offset : c.Offset+c.Length], maxTime: c.MaxTime}
+	}
+	return chunks
+}
+
+// 没有有效索引时按分隔符切分段文件。
+// 列式块的长度可以从列头算出，算出的结尾正好是下一个分隔符（或文件末尾）时直接跳过，
+// 不会被消息里出现的分隔符文本切断；行式块（旧格式）只能搜索下一个分隔符
+func splitChunks(data []byte) []rawChunk {
+	chunks := make([]rawChunk, 0)
+	start := bytes.Index(data, []byte(chunkMarker))
+	for start != -1 {
+		if end, ok := columnChunkEnd(data, start); ok {
+			chunks = append(chunks, rawChunk{offset: int64(start), data: data[start:end]})
+			if end == len(data) {
+				break
+			}
+			start = end
+			continue
+		}
+		end := bytes.Index(data[start+len(chunkMarker):], []byte(chunkMarker))
+		if end == -1 {
+			chunks = append(chunks, rawChunk{offset: int64(start), data: data[start:]})
+			break
+		}
+		end += start + len(chunkMarker)
+		chunks = append(chunks, rawChunk{offset: int64(start), data: data[start:end]})
+		start = end
+	}
+	return chunks
+}
+
+// 从 start 处的列式块算出块的结尾；结尾之后不是分隔符或文件末尾时返回 false
+func columnChunkEnd(data []byte, start int) (int, bool) {
+	_, layout, body, err := parseChunkHeader(data[start:])
+	if err != nil || layout != layoutColumns {
+		return 0, false
+	}
+	size, ok := columnChunkSize(body)
+	if !ok {
+		return 0, false
+	}
+	end := len(data) - len(body) + size
+	if end != len(data) && !bytes.HasPrefix(data[end:], []byte(chunkMarker)) {
+		return 0, false
+	}
+	return end, true
+}
+
+// 打开一个块（按分隔符中的编码和布局）：列式块返回读取器，各列按需解压；行式块直接解压为日志
+func openChunk(codecs *codecSet, chunk []byte) (*columnChunk, []LogEntry, error) {
+	name, layout, data, err := parseChunkHeader(chunk)
+	if err != nil {
+		return nil, nil, err
+	}
+	codec, err := codecs.get(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if layout == layoutColumns {
+		columns, err := openColumnChunk(codec, data)
+		return columns, nil, err
+	}
+
+	decompressed, err := codec.Decompress(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	logs := make([]LogEntry, 0)
+	for _, line := range strings.Split(string(decompressed), "\n") {
+		if line != "" {
+			logs = append(logs, parseLogLine(line))
+		}
+	}
+	return nil, logs, nil
+}
+
+// 解压一个块的全部日志
+func decodeChunk(codecs *codecSet, chunk []byte) ([]LogEntry, error) {
+	columns, logs, err := openChunk(codecs, chunk)
+	if err != nil {
+		return nil, err
+	}
+	if columns != nil {
+		return columns.entries()
+	}
+	return logs, nil
+}
+
+// 用指定编码把一组日志压缩为一个列式块，返回块和按行式（每行一个 JSON）计算的原始大小
+func encodeChunk(codec Codec, logs []LogEntry, created time.Time) ([]byte, int64, error) {
+	body, err := encodeColumnChunk(codec, logs)
+	if err != nil {
+		return nil, 0, err
+	}
+	chunk := append([]byte(chunkHeader(created, codec.Name(), layoutColumns)), body...)
+	return chunk, rawSize(logs), nil
+}
+
+func rawSize(logs []LogEntry) int64 {
+	var size int64
+	for _, log := range logs {
+		size += int64(len(formatLogLine(log))) + 1
+	}
+	return size
+}
+
+// 扫描段文件生成索引（无法解压的块记为 0 条）
+func scanSegment(data []byte, codecs *codecSet) []chunkIndex {
+	chunks := make([]chunkIndex, 0)
+	for _, raw := range splitChunks(data) {
+		name, _, _, _ := parseChunkHeader(raw.data)
+		logs, _ := decodeChunk(codecs, raw.data)
+		chunks = append(chunks, newChunkIndex(logs, name, raw.offset, int64(len(raw.data)), rawSize(logs)))
+	}
+	return chunks
+}
+
+// 段的块索引：优先读取索引文件，失效时扫描段文件（不写回）
+func segmentChunks(segment string, codecs *codecSet) ([]chunkIndex, int64, bool, error) {
+	info, err := os.Stat(segment)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if chunks, ok := readSegmentIndex(segment, info.Size()); ok {
+		return chunks, info.Size(), true, nil
+	}
+	data, err := os.ReadFile(segment)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	return scanSegment(data, codecs), int64(len(data)), false, nil
+}
+
+// 列出所有段
+func (s *LogStorage) Segments() []SegmentInfo {
+	result := make([]SegmentInfo, 0)
+	for _, path := range listSegments(s.dataDir) {
+		s.swapMu.RLock()
+		chunks, size, indexed, err := segmentChunks(path, s.codecs)
+		s.swapMu.RUnlock()
+		if err != nil {
+			continue
+		}
+		info := SegmentInfo{Name: filepath.Base(path), Hour: segmentHour(path), Bytes: size, Chunks: len(chunks), Indexed: indexed, Tier: tierHot}
+		for _, c := range chunks {
+			info.Entries += c.Entries
+			if c.MinTime != "" && (info.MinTime == "" || c.MinTime < info.MinTime) {
+				info.MinTime = c.MinTime
+			}
+			if c.MaxTime > info.MaxTime {
+				info.MaxTime = c.MaxTime
+			}
+		}
+		result = append(result, info)
+	}
+	return append(result, s.storedSegmentInfos()...)
+}
+
+// 查询本地段：段文件和索引一起读取（压缩替换段时等待），索引有效时只返回可能匹配的块
+func (s *LogStorage) localQueryChunks(hour, server string, level levelFilter, since, until string) []rawChunk {
+	filename := segmentPath(s.dataDir, hour)
+	s.swapMu.RLock()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		s.swapMu.RUnlock()
+		return nil
+	}
+	index, indexed := readSegmentIndex(filename, int64(len(data)))
+	s.swapMu.RUnlock()
+
+	if !indexed {
+		return splitChunks(data)
+	}
+	chunks := make([]rawChunk, 0)
+	for _, c := range index {
+		if c.mayMatch(server, level, since, until) {
+			chunks = append(chunks, rawChunk{offset: c.Offset, data: data[c.Offset : c.Offset+c.Length], maxTime: c.MaxTime})
+		}
+	}
+	return chunks
+}
+
+// 重建索引：all 为 false 时只处理缺失或失效的索引，返回重建的段数
+func (s *LogStorage) RebuildIndexes(all bool) (int, error) {
+	s.indexing.Store(true)
+	defer s.indexing.Store(false)
+
+	rebuilt := 0
+	for _, path := range listSegments(s.dataDir) {
+		ok, err := s.rebuildIndex(path, all)
+		if err != nil {
+			return rebuilt, fmt.Errorf("%s: %v", filepath.Base(path), err)
+		}
+		if ok {
+			rebuilt++
+		}
+	}
+	if rebuilt > 0 {
+		fmt.Printf("🗂️  [Index] Rebuilt %d segment indexes in %s\n", rebuilt, s.dataDir)
+	}
+	return rebuilt, nil
+}
+
+// 持有 flushMu，避免与刷盘同时写同一个段
+func (s *LogStorage) rebuildIndex(segment string, force bool) (bool, error) {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	data, err := os.ReadFile(segment)
+	if err != nil {
+		return false, err
+	}
+	if !force {
+		if _, ok := readSegmentIndex(segment, int64(len(data))); ok {
+			return false, nil
+		}
+	}
+	return true, writeSegmentIndex(segment, scanSegment(data, s.codecs))
+}
+
+// 删除完全落在 [from, to) 内的段（包括温层和冷层），返回删除的段
+func (s *LogStorage) DeleteSegments(from, to time.Time) ([]SegmentInfo, error) {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	deleted := make([]SegmentInfo, 0)
+	for _, info := range s.Segments() {
+		start, err := time.ParseInLocation("2006-01-02-15", info.Hour, time.Local)
+		if err != nil || start.Before(from) || start.Add(time.Hour).After(to) {
+			continue
+		}
+		if info.Tier != tierHot {
+			if err := s.deleteStoredSegment(info.Tier, info.Hour); err != nil {
+				return deleted, err
+			}
+			deleted = append(deleted, info)
+			continue
+		}
+		path := segmentPath(s.dataDir, info.Hour)
+		if err := os.Remove(path); err != nil {
+			return deleted, err
+		}
+		os.Remove(indexPath(path))
+		deleted = append(deleted, info)
+	}
+	if len(deleted) > 0 {
+		fmt.Printf("🗑️  [Admin] Deleted %d segments in %s\n", len(deleted), s.dataDir)
+	}
+	return deleted, nil
+}
+
+// 刷盘前段文件和索引的大小，刷盘失败时据此撤销已经追加的块
+type segmentMark struct {
+	path      string
+	size      int64 // -1 表示文件原本不存在，-2 表示不是普通文件（不处理）
+	indexSize int64
+}
+
+func markSegment(path string) segmentMark {
+	return segmentMark{path: path, size: fileMark(path), indexSize: fileMark(indexPath(path))}
+}
+
+func fileMark(path string) int64 {
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		return -1
+	case !info.Mode().IsRegular():
+		return -2
+	}
+	return info.Size()
+}
+
+// 把段文件和索引截回刷盘前的大小（原本不存在的删除；调用方持有 flushMu）
+func rollbackSegments(marks []segmentMark) {
+	restore := func(path string, size int64) {
+		var err error
+		if size == -2 {
+			return
+		}
+		if size < 0 {
+			err = os.Remove(path)
+		} else {
+			err = os.Truncate(path, size)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Printf("⚠️  Cannot roll back %s: %v\n", filepath.Base(path), err)
+		}
+	}
+	for _, mark := range marks {
+		restore(mark.path, mark.size)
+		restore(indexPath(mark.path), mark.indexSize)
+	}
+}
@@ -0,0 +1,22 @@
+// ============ API Key 认证 ============
+// key 保存在 localStorage，请求时放在 Authorization 头里；401 时提示重新输入
+function apiKey() {
+    let key = localStorage.getItem('minilogApiKey');
+    if (!key) {
+        key = prompt('MiniLog API Key (query scope):');
+        if (key) localStorage.setItem('minilogApiKey', key.trim());
+    }
+    return key || '';
+}
+
+function apiFetch(url, options = {}) {
+    options.headers = Object.assign({}, options.headers, {
+        'Authorization': 'Bearer ' + apiKey()
+    });
+    return fetch(url, options).then(response => {
+        if (response.status === 401) {
+            localStorage.removeItem('minilogApiKey');
+        }
+        return response;
+    });
+}
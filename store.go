@@ -0,0 +1,524 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 段存储：已经结束的段（logs-*.lz4 + .idx）可以转存到更便宜的存储，查询时先读索引，
+// 再按范围读取可能匹配的块。键使用 / 分隔（如 tenants/acme/logs-2024-05-01-10.lz4）
+type SegmentStore interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	GetRange(key string, offset, length int64) ([]byte, error)
+	List(prefix string) ([]StoredObject, error)
+	Delete(key string) error
+	String() string
+}
+
+type StoredObject struct {
+	Key      string    `json:"key"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+var errObjectNotFound = errors.New("object not found")
+
+// 远端段存储配置
+type RemoteConfig struct {
+	Type         string        `yaml:"type"`                 // 空表示不转存；local / s3 / memory（进程内，用于测试）
+	Dir          string        `yaml:"dir,omitempty"`        // local：目标目录（如挂载的 NAS）
+	Endpoint     string        `yaml:"endpoint,omitempty"`   // s3：如 https://s3.us-east-1.amazonaws.com、http://localhost:9000（MinIO）
+	Bucket       string        `yaml:"bucket,omitempty"`     // s3
+	Region       string        `yaml:"region,omitempty"`     // s3：默认 us-east-1
+	PathStyle    bool          `yaml:"path_style,omitempty"` // s3：使用 endpoint/bucket/key（MinIO 等）而不是 bucket.endpoint/key
+	AccessKey    string        `yaml:"access_key,omitempty"` // 也可以用 MINILOG_S3_ACCESS_KEY
+	SecretKey    string        `yaml:"secret_key,omitempty"` // 也可以用 MINILOG_S3_SECRET_KEY
+	Prefix       string        `yaml:"prefix,omitempty"`     // 键前缀（多个实例共用一个桶时区分）
+	OffloadAfter time.Duration `yaml:"offload_after"`        // 段结束多久后转存
+}
+
+var remoteTypes = []string{"", "local", "s3", "memory"}
+
+// 按配置创建段存储（未配置时返回 nil）
+func newSegmentStore(cfg RemoteConfig) (SegmentStore, error) {
+	var store SegmentStore
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case "local":
+		store = &localStore{dir: cfg.Dir}
+	case "memory":
+		store = newMemStore()
+	case "s3":
+		s3, err := newS3Store(cfg)
+		if err != nil {
+			return nil, err
+		}
+		store = s3
+	default:
+		return nil, fmt.Errorf("unknown remote store type %q", cfg.Type)
+	}
+	if cfg.Prefix != "" {
+		store = &prefixStore{SegmentStore: store, prefix: strings.Trim(cfg.Prefix, "/") + "/"}
+	}
+	return store, nil
+}
+
+// 本地目录（也是其它实现的参照）
+type localStore struct {
+	dir string
+}
+
+func (l *localStore) String() string { return "local:" + l.dir }
+
+func (l *localStore) path(key string) string {
+	return filepath.Join(l.dir, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+func (l *localStore) Put(key string, data []byte) error {
+	file := l.path(key)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+func (l *localStore) Get(key string) ([]byte, error) {
+	data, err := os.ReadFile(l.path(key))
+	if os.IsNotExist(err) {
+		return nil, errObjectNotFound
+	}
+	return data, err
+}
+
+func (l *localStore) GetRange(key string, offset, length int64) ([]byte, error) {
+	f, err := os.Open(l.path(key))
+	if os.IsNotExist(err) {
+		return nil, errObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, length)
+	if _, err := f.ReadAt(data, offset); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (l *localStore) List(prefix string) ([]StoredObject, error) {
+	objects := make([]StoredObject, 0)
+	err := filepath.Walk(l.dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(file, ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(l.dir, file)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			objects = append(objects, StoredObject{Key: key, Size: info.Size(), Modified: info.ModTime()})
+		}
+		return nil
+	})
+	return objects, err
+}
+
+func (l *localStore) Delete(key string) error {
+	err := os.Remove(l.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// 进程内的假对象存储（type: memory），重启后数据丢失
+type memStore struct {
+	mu      sync.RWMutex
+	objects map[string]memObject
+}
+
+type memObject struct {
+	data     []byte
+	modified time.Time
+}
+
+func newMemStore() *memStore {
+	return &memStore{objects: make(map[string]memObject)}
+}
+
+func (m *memStore) String() string { return "memory" }
+
+func (m *memStore) Put(key string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = memObject{data: append([]byte{}, data...), modified: time.Now()}
+	return nil
+}
+
+func (m *memStore) Get(key string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	obj, ok := m.objects[key]
+	if !ok {
+		return nil, errObjectNotFound
+	}
+	return append([]byte{}, obj.data...), nil
+}
+
+func (m *memStore) GetRange(key string, offset, length int64) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	obj, ok := m.objects[key]
+	if !ok {
+		return nil, errObjectNotFound
+	}
+	if offset < 0 || length < 0 || offset+length > int64(len(obj.data)) {
+		return nil, fmt.Errorf("range %d+%d out of bounds for %s (%d bytes)", offset, length, key, len(obj.data))
+	}
+	return append([]byte{}, obj.data[offset:offset+length]...), nil
+}
+
+func (m *memStore) List(prefix string) ([]StoredObject, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	objects := make([]StoredObject, 0)
+	for key, obj := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, StoredObject{Key: key, Size: int64(len(obj.data)), Modified: obj.modified})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (m *memStore) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+// 给所有键加上前缀（租户、实例）
+type prefixStore struct {
+	SegmentStore
+	prefix string
+}
+
+func (p *prefixStore) String() string { return p.SegmentStore.String() + "/" + p.prefix }
+
+func (p *prefixStore) Put(key string, data []byte) error {
+	return p.SegmentStore.Put(p.prefix+key, data)
+}
+
+func (p *prefixStore) Get(key string) ([]byte, error) { return p.SegmentStore.Get(p.prefix + key) }
+
+func (p *prefixStore) GetRange(key string, offset, length int64) ([]byte, error) {
+	return p.SegmentStore.GetRange(p.prefix+key, offset, length)
+}
+
+func (p *prefixStore) List(prefix string) ([]StoredObject, error) {
+	objects, err := p.SegmentStore.List(p.prefix + prefix)
+	for i := range objects {
+		objects[i].Key = strings.TrimPrefix(objects[i].Key, p.prefix)
+	}
+	return objects, err
+}
+
+func (p *prefixStore) Delete(key string) error { return p.SegmentStore.Delete(p.prefix + key) }
+
+// S3 兼容存储（AWS S3、MinIO、Ceph 等），请求使用 AWS Signature V4 签名
+type s3Store struct {
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	pathStyle bool
+	accessKey string
+	secretKey string
+	client    *http.Client
+	now       func() time.Time
+}
+
+func newS3Store(cfg RemoteConfig) (*s3Store, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", cfg.Endpoint)
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &s3Store{
+		endpoint:  endpoint,
+		bucket:    cfg.Bucket,
+		region:    region,
+		pathStyle: cfg.PathStyle,
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+		client:    &http.Client{Timeout: 60 * time.Second},
+		now:       time.Now,
+	}, nil
+}
+
+func (s *s3Store) String() string { return "s3://" + s.bucket }
+
+// 对象 URL：path style 为 endpoint/bucket/key，否则为 bucket.endpoint/key
+func (s *s3Store) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	if s.pathStyle {
+		u.Path = strings.TrimSuffix("/"+s.bucket+"/"+key, "/")
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+func (s *s3Store) do(method, key string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.objectURL(key, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.ContentLength = int64(len(body))
+	s.sign(req, body)
+	return s.client.Do(req)
+}
+
+// S3 错误响应（<Error><Code>...</Code><Message>...</Message></Error>）
+func s3Error(resp *http.Response) error {
+	var e struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		return fmt.Errorf("s3 %s: %s (%s)", resp.Status, e.Code, e.Message)
+	}
+	return fmt.Errorf("s3 %s", resp.Status)
+}
+
+func (s *s3Store) Put(key string, data []byte) error {
+	resp, err := s.do("PUT", key, nil, data, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+func (s *s3Store) Get(key string) ([]byte, error) {
+	return s.get(key, nil)
+}
+
+func (s *s3Store) GetRange(key string, offset, length int64) ([]byte, error) {
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)}}
+	data, err := s.get(key, header)
+	if err != nil {
+		return nil, err
+	}
+	// 不支持 Range 的服务返回整个对象
+	if int64(len(data)) != length {
+		if offset+length > int64(len(data)) {
+			return nil, fmt.Errorf("range %d+%d out of bounds for %s (%d bytes)", offset, length, key, len(data))
+		}
+		data = data[offset : offset+length]
+	}
+	return data, nil
+}
+
+func (s *s3Store) get(key string, header http.Header) ([]byte, error) {
+	resp, err := s.do("GET", key, nil, nil, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return io.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, errObjectNotFound
+	default:
+		return nil, s3Error(resp)
+	}
+}
+
+// ListObjectsV2，按页读取
+func (s *s3Store) List(prefix string) ([]StoredObject, error) {
+	objects := make([]StoredObject, 0)
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do("GET", "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := s3Error(resp)
+			resp.Body.Close()
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3 list: %v", err)
+		}
+		for _, c := range page.Contents {
+			objects = append(objects, StoredObject{Key: c.Key, Size: c.Size, Modified: c.LastModified})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func (s *s3Store) Delete(key string) error {
+	resp, err := s.do("DELETE", key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+// AWS Signature V4：签名 host、range 和 x-amz-* 请求头
+func (s *s3Store) sign(req *http.Request, body []byte) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		name := strings.ToLower(k)
+		if name == "range" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsEscape(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		values := append([]string{}, query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// URI 编码：只保留 A-Z a-z 0-9 - _ . ~（路径中保留 /）
+func awsEscape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			b.WriteString("%" + strings.ToUpper(strconv.FormatInt(int64(c)|0x100, 16)[1:]))
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 默认租户：数据直接放在 dataDir 下（兼容单租户部署）
+const defaultTenant = "default"
+
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// 租户配额（0 表示不限制）
+type TenantQuota struct {
+	IngestRate   float64 `json:"ingest_rate"`    // 每秒写入条数
+	IngestBurst  int     `json:"ingest_burst"`   // 突发上限
+	MaxDiskBytes int64   `json:"max_disk_bytes"` // 磁盘占用上限
+}
+
+func (q TenantQuota) burst() int {
+	if q.IngestBurst > 0 {
+		return q.IngestBurst
+	}
+	if q.IngestRate < 1 {
+		return 1
+	}
+	return int(q.IngestRate)
+}
+
+// 租户：独立的日志存储、监控存储和写入链路
+type Tenant struct {
+	ID       string
+	dataDir  string
+	Logs     *LogStorage
+	Metrics  *MetricsStorage
+	Ingester *Ingester
+
+	mu        sync.Mutex
+	quota     TenantQuota
+	bucket    tokenBucket
+	diskUsage int64
+	paused    bool // 管理员暂停写入（/api/admin/ingest/pause）
+	stats     struct {
+		RateLimited int64
+		DiskFull    int64
+	}
+}
+
+// 检查配额；返回 HTTP 状态码（0 表示允许写入）
+func (t *Tenant) admit() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.paused {
+		return http.StatusServiceUnavailable
+	}
+	if t.quota.MaxDiskBytes > 0 && t.diskUsage >= t.quota.MaxDiskBytes {
+		t.stats.DiskFull++
+		return http.StatusInsufficientStorage
+	}
+	if t.quota.IngestRate > 0 {
+		if !t.bucket.allow(time.Now(), t.quota.IngestRate, t.quota.burst()) {
+			t.stats.RateLimited++
+			return http.StatusTooManyRequests
+		}
+	}
+	return 0
+}
+
+func (t *Tenant) diskBytes() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.diskUsage
+}
+
+func (t *Tenant) GetStats() map[string]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return map[string]interface{}{
+		"tenant":               t.ID,
+		"tenant_quota":         t.quota,
+		"tenant_disk_bytes":    t.diskUsage,
+		"tenant_rate_limited":  t.stats.RateLimited,
+		"tenant_disk_full":     t.stats.DiskFull,
+		"tenant_ingest_paused": t.paused,
+	}
+}
+
+// 暂停 / 恢复写入（查询不受影响）
+func (t *Tenant) SetPaused(paused bool) {
+	t.mu.Lock()
+	t.paused = paused
+	t.mu.Unlock()
+}
+
+// 租户管理：租户通过 /api/tenants 创建，配额保存在 dataDir/tenants.json
+type TenantManager struct {
+	dataDir    string
+	storageCfg StorageConfig
+	metricsCfg MetricsConfig
+	ingestCfg  IngestConfig
+	audit      *AuditLog
+
+	mu      sync.Mutex
+	tenants map[string]*Tenant
+	quotas  map[string]TenantQuota
+}
+
+func NewTenantManager(cfg *Config, ingestCfg IngestConfig, audit *AuditLog) (*TenantManager, error) {
+	m := &TenantManager{
+		dataDir:    cfg.DataDir,
+		storageCfg: cfg.Storage,
+		metricsCfg: cfg.Metrics,
+		ingestCfg:  ingestCfg,
+		audit:      audit,
+		tenants:    make(map[string]*Tenant),
+		quotas:     make(map[string]TenantQuota),
+	}
+
+	data, err := os.ReadFile(m.quotaPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &m.quotas); err != nil {
+			return nil, fmt.Errorf("%s: %w", m.quotaPath(), err)
+		}
+	}
+
+	// 默认租户启动时就创建，再加载已经配置过配额或在磁盘上有目录的租户
+	if _, err := m.Create(defaultTenant); err != nil {
+		return nil, err
+	}
+	for id := range m.quotas {
+		if _, err := m.Create(id); err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range m.existingTenants() {
+		if _, err := m.Create(id); err != nil {
+			return nil, err
+		}
+	}
+
+	go m.diskUsageUpdater()
+
+	return m, nil
+}
+
+func (m *TenantManager) quotaPath() string {
+	return filepath.Join(m.dataDir, "tenants.json")
+}
+
+func (m *TenantManager) tenantDir(id string) string {
+	if id == defaultTenant {
+		return m.dataDir
+	}
+	return filepath.Join(m.dataDir, "tenants", id)
+}
+
+// 磁盘上已有的租户目录
+func (m *TenantManager) existingTenants() []string {
+	entries, _ := os.ReadDir(filepath.Join(m.dataDir, "tenants"))
+	ids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() && tenantIDPattern.MatchString(e.Name()) {
+			ids = append(ids, e.Name())
+		}
+	}
+	return ids
+}
+
+var errTenantNotFound = errors.New("tenant not found")
+
+// 获取已有的租户（不会创建，未知的租户返回 errTenantNotFound）
+func (m *TenantManager) Get(id string) (*Tenant, error) {
+	if !tenantIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("无效的租户 ID: %q", id)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.tenants[id]; ok {
+		return t, nil
+	}
+	return nil, errTenantNotFound
+}
+
+// 获取租户（不存在则创建）：只用于启动加载、/api/tenants 和复制跟随
+func (m *TenantManager) Create(id string) (*Tenant, error) {
+	if !tenantIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("无效的租户 ID: %q", id)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.tenants[id]; ok {
+		return t, nil
+	}
+
+	dir := m.tenantDir(id)
+	storageCfg := m.storageCfg
+	if id != defaultTenant {
+		if storageCfg.Remote.Type != "" {
+			storageCfg.Remote.Prefix = path.Join(storageCfg.Remote.Prefix, "tenants", id)
+		}
+		if storageCfg.Tiers.WarmDir != "" {
+			storageCfg.Tiers.WarmDir = filepath.Join(storageCfg.Tiers.WarmDir, "tenants", id)
+		}
+		if storageCfg.Tiers.CacheDir != "" {
+			storageCfg.Tiers.CacheDir = filepath.Join(storageCfg.Tiers.CacheDir, "tenants", id)
+		}
+	}
+	logs := NewLogStorage(dir, storageCfg, m.audit, id)
+	ingester, err := NewIngester(logs, m.ingestCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tenant{
+		ID:       id,
+		dataDir:  dir,
+		Logs:     logs,
+		Metrics:  NewMetricsStorage(dir, m.metricsCfg),
+		Ingester: ingester,
+		quota:    m.quotas[id],
+	}
+	t.bucket = tokenBucket{tokens: float64(t.quota.burst()), last: time.Now()}
+	t.diskUsage = m.measureDisk(t)
+	m.tenants[id] = t
+	return t, nil
+}
+
+// 热加载：先为所有租户构建新的写入链路，全部成功后再替换，失败时保持原配置
+func (m *TenantManager) Reconfigure(cfg *Config, ingestCfg IngestConfig) error {
+	// 持有 m.mu 直到新配置生效，避免期间新建的租户用上旧配置
+	m.mu.Lock()
+	tenants := make([]*Tenant, 0, len(m.tenants))
+	for _, t := range m.tenants {
+		tenants = append(tenants, t)
+	}
+	stages := make([]*ingestStages, len(tenants))
+	for i, t := range tenants {
+		s, err := t.Ingester.buildStages(ingestCfg)
+		if err != nil {
+			// 已经构建好的多行聚合器需要停掉
+			for _, built := range stages[:i] {
+				built.multiline.Close()
+			}
+			m.mu.Unlock()
+			return fmt.Errorf("租户 %s: %w", t.ID, err)
+		}
+		stages[i] = s
+	}
+
+	m.storageCfg = cfg.Storage
+	m.metricsCfg = cfg.Metrics
+	m.ingestCfg = ingestCfg
+	m.mu.Unlock()
+
+	for i, t := range tenants {
+		t.Ingester.swapStages(stages[i])
+		t.Logs.Reconfigure(cfg.Storage)
+		t.Metrics.Reconfigure(cfg.Metrics)
+	}
+	return nil
+}
+
+// 设置配额并持久化（租户不存在时创建）
+func (m *TenantManager) SetQuota(id string, quota TenantQuota) error {
+	t, err := m.Create(id)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.quota = quota
+	t.bucket = tokenBucket{tokens: float64(quota.burst()), last: time.Now()}
+	t.mu.Unlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.quotas[id] = quota
+	data, err := json.MarshalIndent(m.quotas, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(m.quotaPath(), data, 0644)
+}
+
+func (m *TenantManager) List() []*Tenant {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make([]*Tenant, 0, len(m.tenants))
+	for _, t := range m.tenants {
+		result = append(result, t)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+// 退出前输出所有租户拼装中的多行事件并刷盘
+func (m *TenantManager) Shutdown() {
+	for _, t := range m.List() {
+		t.Ingester.Close()
+		if _, err := t.Logs.flushToDisk(); err != nil {
+			fmt.Printf("⚠️  Tenant %s flush failed on shutdown, entries kept in WAL: %v\n", t.ID, err)
+		}
+	}
+}
+
+// 定期统计每个租户的磁盘占用
+func (m *TenantManager) diskUsageUpdater() {
+	ticker := time.NewTicker(30 * time.Second)
+	for range ticker.C {
+		for _, t := range m.List() {
+			usage := m.measureDisk(t)
+			t.mu.Lock()
+			t.diskUsage = usage
+			t.mu.Unlock()
+		}
+	}
+}
+
+// 默认租户的目录包含其它租户的子目录，统计时跳过
+func (m *TenantManager) measureDisk(t *Tenant) int64 {
+	var total int64
+	skip := filepath.Join(m.dataDir, "tenants")
+	filepath.Walk(t.dataDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() && t.ID == defaultTenant && path == skip {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+type tenantKey struct{}
+
+func tenantFrom(r *http.Request) *Tenant {
+	t, _ := r.Context().Value(tenantKey{}).(*Tenant)
+	return t
+}
+
+// 解析请求所属租户：绑定了租户的 key 只能访问该租户；
+// 未绑定租户的管理员 key 可以用 X-Tenant 选择租户，其它 key 使用默认租户
+func (m *TenantManager) Resolve(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requested := r.Header.Get("X-Tenant")
+		id := defaultTenant
+
+		principal := principalFrom(r)
+		switch {
+		case principal != nil && principal.Tenant != "":
+			if requested != "" && requested != principal.Tenant {
+				http.Error(w, "该 API Key 只能访问租户 "+principal.Tenant, http.StatusForbidden)
+				return
+			}
+			id = principal.Tenant
+		case requested != "":
+			if requested != defaultTenant && (principal == nil || !principal.isAdmin()) {
+				http.Error(w, "只有管理员 key 可以通过 X-Tenant 选择租户", http.StatusForbidden)
+				return
+			}
+			id = requested
+		}
+
+		t, err := m.Get(id)
+		if errors.Is(err, errTenantNotFound) {
+			http.Error(w, "租户不存在: "+id+"（先通过 /api/tenants 创建）", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, t)))
+	}
+}
+
+// API: 租户列表和配额（GET 列表 / POST 创建租户或设置配额）
+func (m *TenantManager) handleTenants(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		result := make([]map[string]interface{}, 0)
+		for _, t := range m.List() {
+			result = append(result, t.GetStats())
+		}
+		json.NewEncoder(w).Encode(result)
+
+	case "POST":
+		var req struct {
+			Tenant string `json:"tenant"`
+			TenantQuota
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "请求格式错误: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := m.SetQuota(req.Tenant, req.TenantQuota); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		t, _ := m.Get(req.Tenant)
+		json.NewEncoder(w).Encode(t.GetStats())
+
+	default:
+		http.Error(w, "只接受 GET / POST", http.StatusMethodNotAllowed)
+	}
+}
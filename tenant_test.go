@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTenantsAreCreatedExplicitly(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.DataDir = t.TempDir()
+	m, err := NewTenantManager(cfg, cfg.IngestConfig([]byte("test-key")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Get("team-a"); !errors.Is(err, errTenantNotFound) {
+		t.Fatalf("Get(unknown) error = %v, want errTenantNotFound", err)
+	}
+
+	admin := &Principal{Name: "admin", Scopes: []string{ScopeAdmin}}
+	resolve := func() int {
+		r := httptest.NewRequest("GET", "/api/query", nil)
+		r.Header.Set("X-Tenant", "team-a")
+		r = r.WithContext(context.WithValue(r.Context(), principalKey{}, admin))
+		w := httptest.NewRecorder()
+		m.Resolve(func(w http.ResponseWriter, r *http.Request) {})(w, r)
+		return w.Code
+	}
+	if code := resolve(); code != http.StatusNotFound {
+		t.Errorf("unknown tenant: status %d, want 404", code)
+	}
+	if len(m.List()) != 1 {
+		t.Errorf("resolving an unknown tenant created it: %d tenants", len(m.List()))
+	}
+
+	if err := m.SetQuota("team-a", TenantQuota{}); err != nil {
+		t.Fatal(err)
+	}
+	if code := resolve(); code != http.StatusOK {
+		t.Errorf("created tenant: status %d, want 200", code)
+	}
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 分层存储：按段结束后的时长在三层之间移动
+//
+//	hot   data_dir，刷盘和压缩都在这里进行
+//	warm  tiers.warm_dir（较慢、较大的本地盘），超过 tiers.warm_after 后移入
+//	cold  remote（对象存储），超过 remote.offload_after 后移入
+//
+// 温层和冷层都是 SegmentStore（段文件 + 索引），查询先读取索引，再按范围只读取可能匹配的块；
+// 冷层读取的索引和块写入本地 LRU 缓存，重复查询不再下载
+type TierConfig struct {
+	WarmDir   string        `yaml:"warm_dir,omitempty"` // 为空表示没有温层
+	WarmAfter time.Duration `yaml:"warm_after"`
+	CacheDir  string        `yaml:"cache_dir,omitempty"` // 冷层块缓存目录，默认 data_dir/cache
+	CacheSize ByteSize      `yaml:"cache_size"`          // 每个租户的缓存容量，0 表示不缓存
+}
+
+const (
+	tierHot  = "hot"
+	tierWarm = "warm"
+	tierCold = "cold"
+)
+
+func segmentKey(hour string) string {
+	return "logs-" + hour + ".lz4"
+}
+
+func segmentIndexKey(hour string) string {
+	return "logs-" + hour + ".idx"
+}
+
+// 存储中已有的段：小时 -> 段大小
+func storeSegments(store SegmentStore) (map[string]int64, error) {
+	objects, err := store.List("logs-")
+	if err != nil {
+		return nil, err
+	}
+	hours := make(map[string]int64)
+	for _, obj := range objects {
+		if strings.HasSuffix(obj.Key, ".lz4") && !strings.Contains(obj.Key, "/") {
+			hours[segmentHour(obj.Key)] = obj.Size
+		}
+	}
+	return hours, nil
+}
+
+func (s *LogStorage) tierStore(tier string) SegmentStore {
+	if tier == tierWarm {
+		return s.warm
+	}
+	return s.remote
+}
+
+// 按时长移动段：热层 → 温层 → 冷层（没有温层时热层直接转存到冷层），返回移动的段（Tier 为目标层）
+func (s *LogStorage) Offload(now time.Time) ([]SegmentInfo, error) {
+	moved := make([]SegmentInfo, 0)
+	if s.warm == nil && s.remote == nil {
+		return moved, nil
+	}
+	// 和压缩互斥：压缩中的段不移动
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	target := func(hour string) string {
+		start, err := time.ParseInLocation("2006-01-02-15", hour, time.Local)
+		if err != nil {
+			return ""
+		}
+		age := now.Sub(start.Add(time.Hour))
+		switch {
+		case s.remote != nil && age >= s.offloadAfter:
+			return tierCold
+		case s.warm != nil && age >= s.warmAfter:
+			return tierWarm
+		}
+		return ""
+	}
+
+	for _, path := range listSegments(s.dataDir) {
+		tier := target(segmentHour(path))
+		if tier == "" {
+			continue
+		}
+		info, err := s.moveHotSegment(path, tier)
+		if err != nil {
+			return moved, fmt.Errorf("%s: %v", filepath.Base(path), err)
+		}
+		moved = append(moved, *info)
+	}
+
+	if s.warm != nil && s.remote != nil {
+		hours, err := storeSegments(s.warm)
+		if err != nil {
+			return moved, err
+		}
+		for hour := range hours {
+			if target(hour) != tierCold {
+				continue
+			}
+			info, err := s.moveWarmSegment(hour)
+			if err != nil {
+				return moved, fmt.Errorf("%s: %v", segmentKey(hour), err)
+			}
+			moved = append(moved, *info)
+		}
+	}
+
+	if len(moved) > 0 {
+		fmt.Printf("☁️  [Tier] Moved %d segments from %s\n", len(moved), s.dataDir)
+	}
+	return moved, nil
+}
+
+// 热层段：上传后删除本地文件。
+// 读取时持有 flushMu：迟到的日志可能正在追加到这个段，不能读到半个块
+func (s *LogStorage) moveHotSegment(segment, tier string) (*SegmentInfo, error) {
+	s.flushMu.Lock()
+	s.swapMu.RLock()
+	data, err := os.ReadFile(segment)
+	var index []chunkIndex
+	ok := false
+	if err == nil {
+		index, ok = readSegmentIndex(segment, int64(len(data)))
+	}
+	s.swapMu.RUnlock()
+	s.flushMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		index = scanSegment(data, s.codecs)
+	}
+
+	info, err := s.uploadSegment(tier, segmentHour(segment), data, index)
+	if err != nil {
+		return nil, err
+	}
+	return info, s.removeMoved(segment, int64(len(data)))
+}
+
+// 温层段：转存到冷层后删除
+func (s *LogStorage) moveWarmSegment(hour string) (*SegmentInfo, error) {
+	data, err := s.warm.Get(segmentKey(hour))
+	if err != nil {
+		return nil, err
+	}
+	index, ok := s.storeIndex(tierWarm, hour)
+	if !ok {
+		index = scanSegment(data, s.codecs)
+	}
+	info, err := s.uploadSegment(tierCold, hour, data, index)
+	if err != nil {
+		return nil, err
+	}
+	return info, s.deleteStoredSegment(tierWarm, hour)
+}
+
+// 上传一个段：先上传段文件再上传索引（索引写入成功才算完成）。
+// 目标层已有同一小时的段时（移动后又写入了迟到的日志），新块追加在已有段后面
+func (s *LogStorage) uploadSegment(tier, hour string, data []byte, index []chunkIndex) (*SegmentInfo, error) {
+	store := s.tierStore(tier)
+	upload := data
+	existing, err := store.Get(segmentKey(hour))
+	switch {
+	case err == nil:
+		existingIndex, ok := s.storeIndex(tier, hour)
+		if !ok {
+			existingIndex = scanSegment(existing, s.codecs)
+		}
+		shifted := make([]chunkIndex, len(index))
+		for i, c := range index {
+			c.Offset += int64(len(existing))
+			shifted[i] = c
+		}
+		index = append(existingIndex, shifted...)
+		upload = append(existing, data...)
+	case !errors.Is(err, errObjectNotFound):
+		return nil, err
+	}
+
+	if err := store.Put(segmentKey(hour), upload); err != nil {
+		return nil, err
+	}
+	if err := store.Put(segmentIndexKey(hour), encodeSegmentIndex(index)); err != nil {
+		return nil, err
+	}
+	if tier == tierCold {
+		if s.cache != nil {
+			s.cache.dropSegment(hour)
+		}
+		s.tierStats.toCold.Add(1)
+	} else {
+		s.tierStats.toWarm.Add(1)
+	}
+
+	info := &SegmentInfo{Name: segmentKey(hour), Hour: hour, Bytes: int64(len(upload)), Chunks: len(index), Indexed: true, Tier: tier}
+	for _, c := range index {
+		info.Entries += c.Entries
+	}
+	return info, nil
+}
+
+// 删除已上传的热层段；上传期间又追加了块（迟到的日志）时只保留新追加的部分，下次再移动
+func (s *LogStorage) removeMoved(segment string, uploaded int64) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	s.swapMu.Lock()
+	defer s.swapMu.Unlock()
+
+	data, err := os.ReadFile(segment)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) < uploaded {
+		// 上传之后段被截短了（不应该发生），保留本地文件，下次重新移动
+		return fmt.Errorf("段在上传后变短: %d < %d 字节", len(data), uploaded)
+	}
+	if int64(len(data)) == uploaded {
+		os.Remove(indexPath(segment))
+		return os.Remove(segment)
+	}
+	tail := data[uploaded:]
+	tmp := segment + ".tmp"
+	if err := os.WriteFile(tmp, tail, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, segment); err != nil {
+		return err
+	}
+	return writeSegmentIndex(segment, scanSegment(tail, s.codecs))
+}
+
+// 读取温层或冷层的对象；冷层先查本地缓存
+func (s *LogStorage) storeRead(tier, cacheKey string, read func(SegmentStore) ([]byte, error)) ([]byte, error) {
+	if tier == tierCold && s.cache != nil {
+		if data, ok := s.cache.get(cacheKey); ok {
+			return data, nil
+		}
+	}
+	data, err := read(s.tierStore(tier))
+	if err != nil {
+		return nil, err
+	}
+	if tier == tierCold {
+		s.tierStats.fetches.Add(1)
+		s.tierStats.fetchedBytes.Add(int64(len(data)))
+		if s.cache != nil {
+			s.cache.put(cacheKey, data)
+		}
+	}
+	return data, nil
+}
+
+// 温层或冷层的段索引（无效或不存在时返回 false）
+func (s *LogStorage) storeIndex(tier, hour string) ([]chunkIndex, bool) {
+	data, err := s.storeRead(tier, hour+"/index", func(store SegmentStore) ([]byte, error) {
+		return store.Get(segmentIndexKey(hour))
+	})
+	if err != nil {
+		return nil, false
+	}
+	return parseSegmentIndex(data, -1)
+}
+
+// 查询温层或冷层的段：有索引时只按范围读取可能匹配的块，没有索引时读取整个段
+func (s *LogStorage) storeQueryChunks(tier, hour, server string, level levelFilter, since, until string) ([]rawChunk, error) {
+	index, ok := s.storeIndex(tier, hour)
+	if !ok {
+		data, err := s.storeRead(tier, hour+"/segment", func(store SegmentStore) ([]byte, error) {
+			return store.Get(segmentKey(hour))
+		})
+		if err != nil {
+			return nil, err
+		}
+		return splitChunks(data), nil
+	}
+
+	chunks := make([]rawChunk, 0)
+	for _, c := range index {
+		if !c.mayMatch(server, level, since, until) {
+			continue
+		}
+		c := c
+		data, err := s.storeRead(tier, fmt.Sprintf("%s/%d-%d.chunk", hour, c.Offset, c.Length), func(store SegmentStore) ([]byte, error) {
+			return store.GetRange(segmentKey(hour), c.Offset, c.Length)
+		})
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, rawChunk{offset: c.Offset, data: data, maxTime: c.MaxTime})
+	}
+	return chunks, nil
+}
+
+// 温层和冷层的段信息（/api/admin/segments）
+func (s *LogStorage) storedSegmentInfos() []SegmentInfo {
+	result := make([]SegmentInfo, 0)
+	for _, tier := range []string{tierWarm, tierCold} {
+		store := s.tierStore(tier)
+		if store == nil {
+			continue
+		}
+		hours, err := storeSegments(store)
+		if err != nil {
+			fmt.Printf("⚠️  Cannot list %s segments: %v\n", tier, err)
+			continue
+		}
+		for hour, size := range hours {
+			info := SegmentInfo{Name: segmentKey(hour), Hour: hour, Bytes: size, Tier: tier}
+			if index, ok := s.storeIndex(tier, hour); ok {
+				info.Indexed = true
+				info.Chunks = len(index)
+				for _, c := range index {
+					info.Entries += c.Entries
+					if c.MinTime != "" && (info.MinTime == "" || c.MinTime < info.MinTime) {
+						info.MinTime = c.MinTime
+					}
+					if c.MaxTime > info.MaxTime {
+						info.MaxTime = c.MaxTime
+					}
+				}
+			}
+			result = append(result, info)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Hour < result[j].Hour })
+	return result
+}
+
+func (s *LogStorage) deleteStoredSegment(tier, hour string) error {
+	store := s.tierStore(tier)
+	if err := store.Delete(segmentKey(hour)); err != nil {
+		return err
+	}
+	if tier == tierCold && s.cache != nil {
+		s.cache.dropSegment(hour)
+	}
+	return store.Delete(segmentIndexKey(hour))
+}
+
+// 查询要读取的一个小时：各层都可能有这一小时的段（移动后写入的迟到日志留在热层）
+type querySegment struct {
+	hour  string
+	tiers map[string]bool
+}
+
+// 段覆盖的时间范围的末尾（不包含），和时间戳按字符串比较；小时无法解析时为空
+func (q querySegment) end() string {
+	start, err := time.ParseInLocation("2006-01-02-15", q.hour, time.Local)
+	if err != nil {
+		return ""
+	}
+	return start.Add(time.Hour).Format(columnTimeLayout)
+}
+
+// 查询的段（从新到旧）：没有指定时间范围时只读当前小时；指定时读取范围内各层的段
+func (s *LogStorage) querySegments(since, until string) []querySegment {
+	if since == "" && until == "" {
+		return []querySegment{{hour: time.Now().Format("2006-01-02-15"), tiers: map[string]bool{tierHot: true}}}
+	}
+
+	segments := make(map[string]map[string]bool)
+	add := func(hour, tier string) {
+		if segments[hour] == nil {
+			segments[hour] = make(map[string]bool)
+		}
+		segments[hour][tier] = true
+	}
+	for _, path := range listSegments(s.dataDir) {
+		add(segmentHour(path), tierHot)
+	}
+	for _, tier := range []string{tierWarm, tierCold} {
+		store := s.tierStore(tier)
+		if store == nil {
+			continue
+		}
+		hours, err := storeSegments(store)
+		if err != nil {
+			fmt.Printf("⚠️  Cannot list %s segments: %v\n", tier, err)
+		}
+		for hour := range hours {
+			add(hour, tier)
+		}
+	}
+
+	result := make([]querySegment, 0, len(segments))
+	for hour, tiers := range segments {
+		start, err := time.ParseInLocation("2006-01-02-15", hour, time.Local)
+		if err != nil {
+			continue
+		}
+		// 段覆盖 [start, start+1h)，时间戳按字符串比较
+		if (since != "" && start.Add(time.Hour).Format(columnTimeLayout) <= since) ||
+			(until != "" && start.Format(columnTimeLayout) > until) {
+			continue
+		}
+		result = append(result, querySegment{hour: hour, tiers: tiers})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].hour > result[j].hour })
+	return result
+}
+
+// 读取一个小时在各层的块（热层在前）
+func (s *LogStorage) queryChunks(seg querySegment, server string, level levelFilter, since, until string) []rawChunk {
+	var chunks []rawChunk
+	if seg.tiers[tierHot] {
+		chunks = s.localQueryChunks(seg.hour, server, level, since, until)
+	}
+	for _, tier := range []string{tierWarm, tierCold} {
+		if !seg.tiers[tier] {
+			continue
+		}
+		stored, err := s.storeQueryChunks(tier, seg.hour, server, level, since, until)
+		if err != nil {
+			fmt.Printf("⚠️  Cannot read %s segment %s: %v\n", tier, seg.hour, err)
+		}
+		chunks = append(chunks, stored...)
+	}
+	return chunks
+}
+
+// 分层存储统计（/api/stats）
+func (s *LogStorage) tierStatsSnapshot() map[string]interface{} {
+	if s.warm == nil && s.remote == nil {
+		return nil
+	}
+	stats := map[string]interface{}{
+		"moved_to_warm":      s.tierStats.toWarm.Load(),
+		"moved_to_cold":      s.tierStats.toCold.Load(),
+		"cold_fetches":       s.tierStats.fetches.Load(),
+		"cold_fetched_bytes": s.tierStats.fetchedBytes.Load(),
+	}
+	if s.warm != nil {
+		stats["warm"] = s.warm.String()
+	}
+	if s.remote != nil {
+		stats["cold"] = s.remote.String()
+	}
+	return stats
+}
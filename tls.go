@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// TLS 配置
+type TLSConfig struct {
+	CertFile   string `yaml:"cert_file"`   // 服务器证书
+	KeyFile    string `yaml:"key_file"`    // 服务器私钥
+	ClientCA   string `yaml:"client_ca"`   // 客户端证书 CA（为空则不校验客户端证书）
+	ClientAuth string `yaml:"client_auth"` // optional / require（配置了 ClientCA 时生效，默认 optional）
+}
+
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// 证书热加载：每次握手都取当前证书，SIGHUP 时重新读取文件
+type certReloader struct {
+	cfg TLSConfig
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+}
+
+func newCertReloader(cfg TLSConfig) (*certReloader, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, fmt.Errorf("TLS 需要同时指定证书和私钥")
+	}
+	if cfg.ClientAuth == "" {
+		cfg.ClientAuth = "optional"
+	}
+	if cfg.ClientAuth != "optional" && cfg.ClientAuth != "require" {
+		return nil, fmt.Errorf("client auth 只支持 optional / require，得到 %q", cfg.ClientAuth)
+	}
+
+	r := &certReloader{cfg: cfg}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// 重新读取证书和客户端 CA（失败时保留旧证书继续服务）
+func (r *certReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("加载证书失败: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if r.cfg.ClientCA != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCA)
+		if err != nil {
+			return fmt.Errorf("读取客户端 CA 失败: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("客户端 CA 中没有有效证书: %s", r.cfg.ClientCA)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCA = pool
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *certReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+
+			// 返回的配置会替换 http.Server 设置的 ALPN，需要重新声明 HTTP/2
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+				NextProtos:   []string{"h2", "http/1.1"},
+			}
+			if r.clientCA != nil {
+				cfg.ClientCAs = r.clientCA
+				cfg.ClientAuth = tls.VerifyClientCertIfGiven
+				if r.cfg.ClientAuth == "require" {
+					cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				}
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// 已验证的客户端证书身份（CN + DNS SAN），没有客户端证书或证书里没有身份时返回 nil
+func clientCertIdentities(r *http.Request) []string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	identities := make([]string, 0, 1+len(cert.DNSNames))
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+	identities = append(identities, cert.DNSNames...)
+	if len(identities) == 0 {
+		return nil
+	}
+	return identities
+}
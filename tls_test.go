@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestClientCertIdentities(t *testing.T) {
+	withCert := func(cert *x509.Certificate) []string {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		return clientCertIdentities(r)
+	}
+
+	if got := clientCertIdentities(httptest.NewRequest("GET", "/", nil)); got != nil {
+		t.Errorf("no TLS: got %v, want nil", got)
+	}
+	// 证书里没有 CN 和 SAN 时按没有客户端证书处理，不能得到空的身份列表
+	if got := withCert(&x509.Certificate{}); got != nil {
+		t.Errorf("empty cert: got %#v, want nil", got)
+	}
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "web-01"}, DNSNames: []string{"web-01.local"}}
+	if got, want := withCert(cert), []string{"web-01", "web-01.local"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTLSConfigKeepsHTTP2(t *testing.T) {
+	r := &certReloader{cfg: TLSConfig{ClientAuth: "optional"}, cert: &tls.Certificate{}}
+	cfg, err := r.TLSConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.NextProtos) == 0 || cfg.NextProtos[0] != "h2" {
+		t.Errorf("NextProtos = %v, want h2 first", cfg.NextProtos)
+	}
+}
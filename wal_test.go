@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func waitReplayed(t *testing.T, s *LogStorage) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.replaying.Load() || s.indexing.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("WAL replay did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// 重放结束后的刷盘持有 flushMu
+	s.flushMu.Lock()
+	s.flushMu.Unlock()
+}
+
+func TestFailedFlushKeepsEntries(t *testing.T) {
+	dir := t.TempDir()
+	cfg := defaultConfig().Storage
+	now := time.Now()
+	prev, current := now.Add(-time.Hour), now
+	since := prev.Add(-time.Minute).Format(columnTimeLayout)
+
+	s := NewLogStorage(dir, cfg, nil, defaultTenant)
+	waitReplayed(t, s)
+	for _, log := range []LogEntry{
+		{Timestamp: prev.Format(columnTimeLayout), Server: "web-01", Level: "INFO", Message: "late"},
+		{Timestamp: current.Format(columnTimeLayout), Server: "web-01", Level: "INFO", Message: "first"},
+		{Timestamp: current.Format(columnTimeLayout), Server: "web-01", Level: "ERROR", Message: "second"},
+	} {
+		if status := s.Append(log); status != appendStored {
+			t.Fatalf("Append: %s", status)
+		}
+	}
+
+	// 当前小时的段路径被目录占住，追加块失败
+	blocked := segmentPath(dir, current.Format("2006-01-02-15"))
+	if err := os.Mkdir(blocked, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.flushToDisk(); err == nil {
+		t.Fatal("flush should fail")
+	}
+	// 已经写入的上一个小时的块被撤销，日志全部回到缓冲
+	if _, err := os.Stat(segmentPath(dir, prev.Format("2006-01-02-15"))); !os.IsNotExist(err) {
+		t.Errorf("partial flush left the previous hour segment behind: %v", err)
+	}
+	if got := len(s.Query("", "", "", since, "", 100, nil)); got != 3 {
+		t.Fatalf("after failed flush: %d entries, want 3", got)
+	}
+
+	// 重启：WAL 没有被删除，重放后的刷盘同样失败，日志仍在缓冲
+	s = NewLogStorage(dir, cfg, nil, defaultTenant)
+	waitReplayed(t, s)
+	if got := len(s.Query("", "", "", since, "", 100, nil)); got != 3 {
+		t.Fatalf("after restart: %d entries, want 3", got)
+	}
+
+	// 故障排除后下次刷盘写入全部日志，旧 WAL 随之删除
+	if err := os.Remove(blocked); err != nil {
+		t.Fatal(err)
+	}
+	s.Append(LogEntry{Timestamp: current.Format(columnTimeLayout), Server: "web-01", Level: "INFO", Message: "third"})
+	result, err := s.flushToDisk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Entries != 4 {
+		t.Errorf("flushed %d entries, want 4", result.Entries)
+	}
+	wals, _ := filepath.Glob(filepath.Join(dir, "wal-*.log"))
+	if len(wals) != 1 {
+		t.Errorf("WAL files after flush: %v, want only the current one", wals)
+	}
+
+	// 再次重启：没有需要重放的日志，也没有重复
+	s = NewLogStorage(dir, cfg, nil, defaultTenant)
+	waitReplayed(t, s)
+	logs := s.Query("", "", "", since, "", 100, nil)
+	if len(logs) != 4 {
+		t.Fatalf("after second restart: %d entries, want 4", len(logs))
+	}
+	seen := make(map[string]bool)
+	for _, log := range logs {
+		if seen[log.Message] {
+			t.Errorf("duplicate entry %q", log.Message)
+		}
+		seen[log.Message] = true
+	}
+}
+
+// 崩溃时写了一半的最后一行被跳过，不能当成一条消息重放
+func TestReadWALSkipsTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal-1.log")
+	content := `{"timestamp":"2024-05-01 10:00:00","level":"INFO","server":"web-01","message":"ok"}` + "\n" +
+		"[2024-05-01 10:00:01] [WARN] [web-02] legacy line\n" +
+		`{"timestamp":"2024-05-01 10:00:02","level":"ERR`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs []LogEntry
+	if err := readWALFile(path, func(log LogEntry) { logs = append(logs, log) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 {
+		t.Fatalf("replayed %d entries, want 2: %+v", len(logs), logs)
+	}
+	if logs[0].Message != "ok" || logs[1].Server != "web-02" || logs[1].Message != "legacy line" {
+		t.Errorf("unexpected entries: %+v", logs)
+	}
+}